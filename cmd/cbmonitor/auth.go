@@ -0,0 +1,73 @@
+package main
+
+import (
+	"cbmonitor/internal/config"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+const bearerPrefix = "bearer "
+
+// apiAuthenticator validates the credentials of API consumers
+type apiAuthenticator struct {
+	users  []config.APIUser
+	tokens []config.APIToken
+}
+
+func newAPIAuthenticator(auth config.APIAuth) *apiAuthenticator {
+	return &apiAuthenticator{
+		users:  auth.Users,
+		tokens: auth.Tokens,
+	}
+}
+
+func secureEquals(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// scopeOf returns the scope granted to the request credentials, ok is false when they are not valid
+func (a *apiAuthenticator) scopeOf(r *http.Request) (scope string, ok bool) {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(strings.ToLower(header), bearerPrefix) {
+		token := strings.TrimSpace(header[len(bearerPrefix):])
+		for _, candidate := range a.tokens {
+			if secureEquals(candidate.Token, token) {
+				return candidate.Scope, true
+			}
+		}
+		return "", false
+	}
+	username, password, hasBasic := r.BasicAuth()
+	if !hasBasic {
+		return "", false
+	}
+	for _, candidate := range a.users {
+		if secureEquals(candidate.Username, username) && secureEquals(candidate.Password, password) {
+			return candidate.Scope, true
+		}
+	}
+	return "", false
+}
+
+// Require rejects the requests whose credentials do not grant the given scope. Any request is accepted
+// when no credentials are configured
+func (a *apiAuthenticator) Require(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(a.users) == 0 && len(a.tokens) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			granted, ok := a.scopeOf(r)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Basic realm="cbmonitor"`)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			if scope == config.ScopeAdmin && granted != config.ScopeAdmin {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"cbmonitor/internal/advisor"
+	"cbmonitor/internal/alerts"
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/monitor"
+	"cbmonitor/internal/monitor/stats"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var plainPerfLabel = regexp.MustCompile(`^[A-Za-z0-9_./-]+$`)
+
+// perfMetrics cluster metrics reported as performance data, bucket metrics are reported for every bucket
+var (
+	perfMetrics       = []string{"ram_pct_used", "hd_pct_used", "get_hit_ratio"}
+	perfBucketMetrics = []string{"ops_per_sec", "quota_pct_used", "item_count"}
+)
+
+// checkResult outcome of the check of a single cluster
+type checkResult struct {
+	status monitor.ClusterStatus
+	info   monitor.ClusterInfo
+	alerts []alerts.Alert
+}
+
+// runCheck scrapes the clusters once and exits with a Nagios compatible status code
+func runCheck(args []string) {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	configFile := flags.String("config", "./config.json", "Configuration file path")
+	clusterName := flags.String("cluster", "", "Only check the configured cluster with this name")
+	labelSelector := flags.String("labels", "", "Only check the configured clusters with these labels (env=prod,team=payments)")
+	hostname := flags.String("host", "", "Check this host instead of the configured clusters")
+	name := flags.String("name", "", "Name of the cluster given by -host (defaults to the host)")
+	protocol := flags.String("protocol", "http", "Protocol of the cluster given by -host")
+	port := flags.String("port", "", "Port of the cluster given by -host (defaults to 8091 or 18091)")
+	username := flags.String("user", "Administrator", "User of the cluster given by -host")
+	callsTimeout := flags.Duration("timeout", 3*time.Second, "Monitoring call timeout")
+	defaultPassword := flags.String("password", "", "Default password (if you don't want to set one in config file)")
+	flags.Parse(args)
+	log.SetOutput(ioutil.Discard)
+
+	configFileSet := false
+	flags.Visit(func(f *flag.Flag) {
+		configFileSet = configFileSet || f.Name == "config"
+	})
+	var configuration config.Configuration
+	if *hostname == "" || configFileSet {
+		var err error
+		configuration, err = config.LoadFile(*configFile)
+		if err != nil {
+			exitCheck(alerts.SeverityUnknown, fmt.Sprintf("cannot read configuration: %s", err), "")
+		}
+	}
+	clusters := configuration.Clusters
+	if *hostname != "" {
+		cluster := config.Cluster{
+			Credentials: config.Auth{Username: *username, Password: *defaultPassword},
+			Name:        *name,
+			Hostname:    *hostname,
+			Protocol:    strings.ToLower(*protocol),
+			Port:        *port,
+		}
+		if cluster.Name == "" {
+			cluster.Name = cluster.Hostname
+		}
+		if cluster.Port == "" {
+			cluster.Port = "8091"
+			if cluster.Protocol == "https" {
+				cluster.Port = "18091"
+			}
+		}
+		clusters = []config.Cluster{cluster}
+	} else if *clusterName != "" {
+		clusters = []config.Cluster{}
+		for _, cluster := range configuration.Clusters {
+			if cluster.Name == *clusterName {
+				clusters = append(clusters, cluster)
+			}
+		}
+	}
+	if *hostname == "" {
+		var err error
+		if clusters, err = selectClusters(clusters, *labelSelector); err != nil {
+			exitCheck(alerts.SeverityUnknown, err.Error(), "")
+		}
+	}
+	if len(clusters) == 0 {
+		exitCheck(alerts.SeverityUnknown, "no cluster to check", "")
+	}
+
+	clusterAdvisor, err := advisor.NewAdvisor(configuration.Advisor)
+	if err != nil {
+		exitCheck(alerts.SeverityUnknown, fmt.Sprintf("invalid configuration: %s", err), "")
+	}
+	monitors := buildMonitors(clusters, *callsTimeout, *defaultPassword)
+	results := make(map[string]checkResult, len(monitors))
+	scrapeOnce(monitors, defaultConcurrency, func(info monitor.ClusterInfo) {
+		status := monitor.NewClusterStatus(info.Name)
+		status.Update(info, false)
+		result := checkResult{status: status, info: info, alerts: statusAlerts(status)}
+		if info.Err == nil || info.Partial() {
+			result.alerts = append(result.alerts, alerts.Calculated(info.Name, info.Stats)...)
+			result.alerts = append(result.alerts, alerts.Evaluate(info.Name, info.Stats, configuration.Rules)...)
+			clusterAdvisor.Add(info.Name, info.Time, info.Stats)
+			result.alerts = append(result.alerts, clusterAdvisor.Alerts(info.Name)...)
+		}
+		results[info.Name] = result
+	})
+	ordered := make([]checkResult, len(monitors))
+	for i, monitor := range monitors {
+		ordered[i] = results[monitor.Name()]
+	}
+	severity, summary := checkSummary(ordered)
+	exitCheck(severity, summary, checkPerfData(ordered, configuration.Rules))
+}
+
+// statusAlerts turns scrape failures into alerts: unreachable clusters are critical while
+// misconfigurations (credentials, unexpected answers) are unknown
+func statusAlerts(status monitor.ClusterStatus) []alerts.Alert {
+	switch status.State {
+	case monitor.StateDown:
+		severity := alerts.SeverityUnknown
+		switch status.ErrorCategory {
+		case monitor.CategoryNetwork, monitor.CategoryTimeout, monitor.CategoryTLS:
+			severity = alerts.SeverityCritical
+		}
+		return []alerts.Alert{{
+			Cluster:  status.Name,
+			Type:     alerts.TypeScrape,
+			Name:     string(status.ErrorCategory),
+			Severity: severity,
+			Message:  fmt.Sprintf("down (%s): %s", status.ErrorCategory, status.LastError),
+		}}
+	case monitor.StateDegraded:
+		message := status.Reason
+		if status.LastError != "" {
+			message = status.LastError
+		}
+		return []alerts.Alert{{
+			Cluster:  status.Name,
+			Type:     alerts.TypeScrape,
+			Name:     string(monitor.StateDegraded),
+			Severity: alerts.SeverityWarning,
+			Message:  "degraded: " + message,
+		}}
+	}
+	return []alerts.Alert{}
+}
+
+func checkSummary(results []checkResult) (alerts.Severity, string) {
+	all := []alerts.Alert{}
+	for _, result := range results {
+		all = append(all, result.alerts...)
+	}
+	severity := alerts.MaxSeverity(all)
+	if len(all) == 0 {
+		descriptions := make([]string, len(results))
+		for i, result := range results {
+			descriptions[i] = fmt.Sprintf("%s %s (%d nodes, %d buckets)", result.status.Name, result.status.State,
+				len(result.info.Stats.Nodes), len(result.info.Stats.Buckets))
+		}
+		return severity, strings.Join(descriptions, ", ")
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].Severity.Outranks(all[j].Severity)
+	})
+	messages := make([]string, len(all))
+	for i, alert := range all {
+		messages[i] = fmt.Sprintf("%s: %s", alert.Cluster, alert.Message)
+	}
+	return severity, strings.Join(messages, "; ")
+}
+
+func perfLabel(label string) string {
+	if plainPerfLabel.MatchString(label) {
+		return label
+	}
+	return "'" + strings.Replace(label, "'", "''", -1) + "'"
+}
+
+func perfThresholds(metric string, rules []config.Rule) string {
+	format := func(threshold *float64) string {
+		if threshold == nil {
+			return ""
+		}
+		return fmt.Sprintf("%g", *threshold)
+	}
+	for _, rule := range rules {
+		if matched, _ := path.Match(rule.Metric, metric); matched && !rule.Below {
+			return format(rule.Warning) + ";" + format(rule.Critical)
+		}
+	}
+	return ";"
+}
+
+func checkPerfData(results []checkResult, rules []config.Rule) string {
+	perfData := []string{}
+	for _, result := range results {
+		if result.info.Err != nil && !result.info.Partial() {
+			continue
+		}
+		prefix := ""
+		if len(results) > 1 {
+			prefix = result.status.Name + "_"
+		}
+		clusterStats := result.info.Stats
+		values := clusterStats.AllMetrics()
+		metrics := append([]string{}, perfMetrics...)
+		for _, bucket := range clusterStats.Buckets {
+			for _, metric := range perfBucketMetrics {
+				metrics = append(metrics, stats.BucketMetric(bucket.Name, metric))
+			}
+		}
+		for _, metric := range metrics {
+			perfData = append(perfData, fmt.Sprintf("%s=%g;%s", perfLabel(prefix+metric), values[metric],
+				perfThresholds(metric, rules)))
+		}
+		alertsCount := len(clusterStats.Alerts.Calculated) + len(clusterStats.Alerts.Cluster)
+		perfData = append(perfData,
+			fmt.Sprintf("%s=%d", perfLabel(prefix+"nodes"), len(clusterStats.Nodes)),
+			fmt.Sprintf("%s=%d", perfLabel(prefix+"unhealthy_nodes"), len(clusterStats.UnhealthyNodes())),
+			fmt.Sprintf("%s=%d", perfLabel(prefix+"buckets"), len(clusterStats.Buckets)),
+			fmt.Sprintf("%s=%d", perfLabel(prefix+"alerts"), alertsCount))
+	}
+	return strings.Join(perfData, " ")
+}
+
+func exitCheck(severity alerts.Severity, summary, perfData string) {
+	line := fmt.Sprintf("CBMONITOR %s - %s", strings.ToUpper(severity.String()), summary)
+	if perfData != "" {
+		line += " | " + perfData
+	}
+	fmt.Println(line)
+	os.Exit(int(severity))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"cbmonitor/internal/alerts"
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/monitor"
+	"cbmonitor/internal/monitor/stats"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestStatusAlerts(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   monitor.ClusterStatus
+		severity alerts.Severity
+		message  string
+	}{
+		{name: "up", status: monitor.ClusterStatus{Name: "east", State: monitor.StateUp}},
+		{name: "not scraped", status: monitor.NewClusterStatus("east")},
+		{name: "unreachable", status: monitor.ClusterStatus{Name: "east", State: monitor.StateDown,
+			ErrorCategory: monitor.CategoryNetwork, LastError: "connection refused"},
+			severity: alerts.SeverityCritical, message: "down (network): connection refused"},
+		{name: "timeout", status: monitor.ClusterStatus{Name: "east", State: monitor.StateDown,
+			ErrorCategory: monitor.CategoryTimeout, LastError: "deadline exceeded"},
+			severity: alerts.SeverityCritical, message: "down (timeout): deadline exceeded"},
+		{name: "credentials", status: monitor.ClusterStatus{Name: "east", State: monitor.StateDown,
+			ErrorCategory: monitor.CategoryAuth, LastError: "401 Unauthorized"},
+			severity: alerts.SeverityUnknown, message: "down (auth): 401 Unauthorized"},
+		{name: "partial", status: monitor.ClusterStatus{Name: "east", State: monitor.StateDegraded,
+			Reason: "partial data", LastError: "partial data: buckets"}, severity: alerts.SeverityWarning,
+			message: "degraded: partial data: buckets"},
+		{name: "unhealthy nodes", status: monitor.ClusterStatus{Name: "east", State: monitor.StateDegraded,
+			Reason: "1 unhealthy node"}, severity: alerts.SeverityWarning, message: "degraded: 1 unhealthy node"},
+	}
+	for _, test := range tests {
+		raised := statusAlerts(test.status)
+		if test.message == "" {
+			if len(raised) > 0 {
+				t.Errorf("%s: statusAlerts() = %+v, want none", test.name, raised)
+			}
+			continue
+		}
+		if len(raised) != 1 || raised[0].Severity != test.severity || raised[0].Message != test.message ||
+			raised[0].Type != alerts.TypeScrape || raised[0].Cluster != "east" {
+			t.Errorf("%s: statusAlerts() = %+v, want %s %q", test.name, raised, test.severity, test.message)
+		}
+	}
+}
+
+// newCheckResult creates the result of a cluster raising alerts of the given severities
+func newCheckResult(name string, severities ...alerts.Severity) checkResult {
+	result := checkResult{status: monitor.ClusterStatus{Name: name, State: monitor.StateUp}}
+	result.info.Stats.Nodes = []stats.Node{{Hostname: "node1"}}
+	result.info.Stats.Buckets = []stats.Bucket{{Name: "orders"}, {Name: "users"}}
+	for i, severity := range severities {
+		result.alerts = append(result.alerts, alerts.Alert{Cluster: name, Severity: severity,
+			Message: fmt.Sprintf("%s %d", severity, i)})
+	}
+	return result
+}
+
+func TestCheckSummary(t *testing.T) {
+	tests := []struct {
+		name     string
+		results  []checkResult
+		severity alerts.Severity
+		summary  string
+	}{
+		{name: "ok", results: []checkResult{newCheckResult("east"), newCheckResult("west")},
+			severity: alerts.SeverityOK, summary: "east up (1 nodes, 2 buckets), west up (1 nodes, 2 buckets)"},
+		{name: "warning", results: []checkResult{newCheckResult("east", alerts.SeverityWarning),
+			newCheckResult("west")}, severity: alerts.SeverityWarning, summary: "east: warning 0"},
+		{name: "critical before unknown", results: []checkResult{newCheckResult("east", alerts.SeverityUnknown),
+			newCheckResult("west", alerts.SeverityWarning, alerts.SeverityCritical)},
+			severity: alerts.SeverityCritical, summary: "west: critical 1; west: warning 0; east: unknown 0"},
+		{name: "unknown", results: []checkResult{newCheckResult("east", alerts.SeverityUnknown)},
+			severity: alerts.SeverityUnknown, summary: "east: unknown 0"},
+	}
+	for _, test := range tests {
+		severity, summary := checkSummary(test.results)
+		if severity != test.severity || summary != test.summary {
+			t.Errorf("%s: checkSummary() = %s %q, want %s %q", test.name, severity, summary, test.severity,
+				test.summary)
+		}
+	}
+}
+
+func TestPerfLabel(t *testing.T) {
+	tests := map[string]string{
+		"ram_pct_used":                  "ram_pct_used",
+		"buckets/orders/item_count":     "buckets/orders/item_count",
+		"east cluster_nodes":            "'east cluster_nodes'",
+		"o'brien_buckets/a/ops_per_sec": "'o''brien_buckets/a/ops_per_sec'",
+	}
+	for label, want := range tests {
+		if got := perfLabel(label); got != want {
+			t.Errorf("perfLabel(%q) = %s, want %s", label, got, want)
+		}
+	}
+}
+
+func TestPerfThresholds(t *testing.T) {
+	warning, critical := 80.0, 90.0
+	rules := []config.Rule{
+		{Metric: "get_hit_ratio", Warning: &warning, Below: true},
+		{Metric: "buckets/*/quota_pct_used", Critical: &critical},
+		{Metric: "ram_pct_used", Warning: &warning, Critical: &critical},
+	}
+	tests := map[string]string{
+		"ram_pct_used":                  "80;90",
+		"buckets/orders/quota_pct_used": ";90",
+		"get_hit_ratio":                 ";",
+		"hd_pct_used":                   ";",
+	}
+	for metric, want := range tests {
+		if got := perfThresholds(metric, rules); got != want {
+			t.Errorf("perfThresholds(%q) = %q, want %q", metric, got, want)
+		}
+	}
+}
+
+func TestCheckPerfData(t *testing.T) {
+	critical := 90.0
+	rules := []config.Rule{{Metric: "ram_pct_used", Critical: &critical}}
+	single := newCheckResult("east")
+	single.info.Stats.RAMPctUsed = 42.5
+	single.info.Stats.Buckets = []stats.Bucket{{Name: "orders", OpsPerSec: 12, ItemCount: 3}}
+	want := "ram_pct_used=42.5;;90 hd_pct_used=0;; get_hit_ratio=0;; buckets/orders/ops_per_sec=12;; " +
+		"buckets/orders/quota_pct_used=0;; buckets/orders/item_count=3;; nodes=1 unhealthy_nodes=1 buckets=1 alerts=0"
+	if got := checkPerfData([]checkResult{single}, rules); got != want {
+		t.Errorf("checkPerfData() = %q, want %q", got, want)
+	}
+
+	down := newCheckResult("west")
+	down.info.Err = errors.New("connection refused")
+	partial := newCheckResult("north")
+	partial.info.Err = fmt.Errorf("%w: buckets", stats.ErrPartialData)
+	partial.info.Stats.Buckets = nil
+	want = "north_ram_pct_used=0;;90 north_hd_pct_used=0;; north_get_hit_ratio=0;; north_nodes=1 " +
+		"north_unhealthy_nodes=1 north_buckets=0 north_alerts=0"
+	if got := checkPerfData([]checkResult{down, partial}, rules); got != want {
+		t.Errorf("checkPerfData() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"cbmonitor/internal/advisor"
+	"cbmonitor/internal/anomaly"
+	"cbmonitor/internal/forecast"
+	"cbmonitor/internal/labels"
+	"cbmonitor/internal/monitor"
+	"cbmonitor/internal/monitor/stats"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ClusterEntry statistics of a cluster together with the status of its latest scrape
+type ClusterEntry struct {
+	stats.ClusterStats
+	Status monitor.ClusterStatus `json:"status"`
+}
+
+// ClustersContainer keeps track of statistics of multiple clusters
+type ClustersContainer struct {
+	clusters map[string]*ClusterEntry
+	names    []string
+	owned    map[string]bool
+	mu       sync.RWMutex
+}
+
+// NewClustersContainer creates a container tracking the clusters of the given monitors, labeled before
+// their first scrape
+func NewClustersContainer(monitors []*monitor.Monitor) ClustersContainer {
+	clusters := make(map[string]*ClusterEntry, len(monitors))
+	for _, m := range monitors {
+		clusters[m.Name()] = &ClusterEntry{
+			ClusterStats: stats.ClusterStats{Name: m.Name(), Labels: m.Labels()},
+			Status:       monitor.NewClusterStatus(m.Name()),
+		}
+	}
+	return ClustersContainer{
+		clusters: clusters,
+		names:    monitorNames(monitors),
+	}
+}
+
+// Assign restricts the clusters of the container to those owned by the instance. The clusters given up
+// are reset, they are not served anymore and start over if they are owned again
+func (cc *ClustersContainer) Assign(names []string) {
+	owned := make(map[string]bool, len(names))
+	for _, name := range names {
+		owned[name] = true
+	}
+	cc.mu.Lock()
+	for name, entry := range cc.clusters {
+		if cc.owned != nil && cc.owned[name] && !owned[name] {
+			*entry = ClusterEntry{
+				ClusterStats: stats.ClusterStats{Name: name, Labels: entry.Labels},
+				Status:       monitor.NewClusterStatus(name),
+			}
+		}
+	}
+	cc.owned = owned
+	cc.mu.Unlock()
+}
+
+// Add refreshes the information for a given cluster, failed scrapes keep the last good statistics and
+// the results of clusters not owned anymore are ignored
+func (cc *ClustersContainer) Add(info monitor.ClusterInfo) {
+	cc.mu.Lock()
+	if cc.owned != nil && !cc.owned[info.Name] {
+		cc.mu.Unlock()
+		return
+	}
+	entry, ok := cc.clusters[info.Name]
+	if !ok {
+		entry = &ClusterEntry{
+			ClusterStats: stats.ClusterStats{Name: info.Name},
+			Status:       monitor.NewClusterStatus(info.Name),
+		}
+		cc.clusters[info.Name] = entry
+		cc.names = append(cc.names, info.Name)
+	}
+	entry.Status.Update(info, len(entry.Nodes) > 0)
+	if info.Err == nil || info.Partial() {
+		entry.ClusterStats = info.Stats
+		if entry.ClusterStats.Name == "" {
+			entry.ClusterStats.Name = info.Name
+		}
+	}
+	cc.mu.Unlock()
+}
+
+// GetAll returns the information of all (owned) clusters
+func (cc *ClustersContainer) GetAll() []ClusterEntry {
+	cc.mu.RLock()
+	all := make([]ClusterEntry, 0, len(cc.names))
+	for _, name := range cc.names {
+		if cc.owned == nil || cc.owned[name] {
+			all = append(all, *cc.clusters[name])
+		}
+	}
+	cc.mu.RUnlock()
+	return all
+}
+
+// GetStatus returns the scrape status of all (owned) clusters
+func (cc *ClustersContainer) GetStatus() []monitor.ClusterStatus {
+	cc.mu.RLock()
+	all := make([]monitor.ClusterStatus, 0, len(cc.names))
+	for _, name := range cc.names {
+		if cc.owned == nil || cc.owned[name] {
+			all = append(all, cc.clusters[name].Status)
+		}
+	}
+	cc.mu.RUnlock()
+	return all
+}
+
+// addDerivedAlerts appends the messages of the forecast, anomaly and advisor alerts of the entries to their
+// calculated alerts, as the API root and the snapshots serve them
+func addDerivedAlerts(entries []ClusterEntry, forecaster *forecast.Forecaster, detector *anomaly.Detector,
+	clusterAdvisor *advisor.Advisor, now time.Time) {
+	for i, entry := range entries {
+		name := entry.Status.Name
+		calculated := append([]string{}, entry.Alerts.Calculated...)
+		for _, alert := range forecaster.Alerts(name, forecaster.Cluster(name, now)) {
+			calculated = append(calculated, alert.Message)
+		}
+		for _, alert := range detector.Alerts(name) {
+			calculated = append(calculated, alert.Message)
+		}
+		for _, alert := range clusterAdvisor.Alerts(name) {
+			calculated = append(calculated, alert.Message)
+		}
+		entries[i].Alerts.Calculated = calculated
+	}
+}
+
+// clusterGroup clusters sharing the same value of a label
+type clusterGroup struct {
+	Value    string                `json:"value"`
+	Clusters []string              `json:"clusters"`
+	States   map[monitor.State]int `json:"states"`
+}
+
+// selectEntries returns the entries whose labels match the selector
+func selectEntries(entries []ClusterEntry, selector labels.Selector) []ClusterEntry {
+	selected := []ClusterEntry{}
+	for _, entry := range entries {
+		if selector.Matches(entry.Labels) {
+			selected = append(selected, entry)
+		}
+	}
+	return selected
+}
+
+// requestedClusters returns the clusters named by the cluster parameters of the request, or the clusters
+// matching the other parameters, except the reserved ones, as labels
+func requestedClusters(r *http.Request, entries []ClusterEntry, reserved ...string) []string {
+	if names := r.URL.Query()["cluster"]; len(names) > 0 {
+		return names
+	}
+	selected := selectEntries(entries, labels.FromQuery(r.URL.Query(), append(reserved, "cluster")...))
+	names := make([]string, len(selected))
+	for i, entry := range selected {
+		names[i] = entry.Status.Name
+	}
+	return names
+}
+
+// groupEntries groups the entries by the value of the label, entries without the label are left out
+func groupEntries(entries []ClusterEntry, label string) []clusterGroup {
+	byValue := map[string]*clusterGroup{}
+	for _, entry := range entries {
+		value, found := entry.Labels[label]
+		if !found {
+			continue
+		}
+		group, known := byValue[value]
+		if !known {
+			group = &clusterGroup{Value: value, Clusters: []string{}, States: map[monitor.State]int{}}
+			byValue[value] = group
+		}
+		group.Clusters = append(group.Clusters, entry.Status.Name)
+		group.States[entry.Status.State]++
+	}
+	groups := make([]clusterGroup, 0, len(byValue))
+	for _, group := range byValue {
+		groups = append(groups, *group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Value < groups[j].Value
+	})
+	return groups
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/fleet"
+	"cbmonitor/internal/monitor"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// fleetClusters converts the latest statistics of the clusters, partial scrapes (degraded with an error)
+// are not compared
+func fleetClusters(entries []ClusterEntry) []fleet.Cluster {
+	clusters := make([]fleet.Cluster, len(entries))
+	for i, entry := range entries {
+		state := entry.Status.State
+		clusters[i] = fleet.Cluster{
+			Name:   entry.Status.Name,
+			Labels: entry.Labels,
+			Stats:  entry.ClusterStats,
+			Available: (state == monitor.StateUp || state == monitor.StateDegraded) &&
+				entry.Status.LastError == "",
+		}
+	}
+	return clusters
+}
+
+// runFleet scrapes the clusters once and reports the configuration drift between the clusters sharing
+// a label value, it exits with 1 when differences are found
+func runFleet(args []string) {
+	flags := flag.NewFlagSet("fleet", flag.ExitOnError)
+	configFile := flags.String("config", "./config.json", "Configuration file path")
+	callsTimeout := flags.Duration("timeout", 3*time.Second, "Monitoring call timeout")
+	defaultPassword := flags.String("password", "", "Default password (if you don't want to set one in config file)")
+	label := flags.String("label", "", "Label grouping the clusters to compare (default compare all clusters)")
+	jsonOutput := flags.Bool("json", false, "Print the report as JSON")
+	logFlags := addLogFlags(flags)
+	flags.Parse(args)
+	logFlags.setup()
+	configuration, err := config.NewFileConfiguration(*configFile)
+	exitOnError("Cannot read configuration", err)
+	monitors := buildMonitors(configuration, *callsTimeout, *defaultPassword)
+	fullClusterStats := NewClustersContainer(monitors)
+	scrapeOnce(monitors, defaultConcurrency, fullClusterStats.Add)
+	report := fleet.Compare(*label, fleetClusters(fullClusterStats.GetAll()))
+
+	if *jsonOutput {
+		reportBytes, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(reportBytes))
+	} else {
+		for _, group := range report.Groups {
+			if report.Label != "" {
+				fmt.Printf("%s=%s: %d clusters, %d differences\n", report.Label, group.Value, len(group.Clusters),
+					len(group.Differences))
+			} else {
+				fmt.Printf("%d clusters, %d differences\n", len(group.Clusters), len(group.Differences))
+			}
+			for _, name := range group.Unavailable {
+				fmt.Printf("  %s: not compared, statistics unavailable\n", name)
+			}
+			for _, name := range group.IndexesUnavailable {
+				fmt.Printf("  %s: indexes not compared, they cannot be read\n", name)
+			}
+			for _, difference := range group.Differences {
+				fmt.Printf("  %s\n", difference)
+			}
+		}
+		for _, name := range report.Unlabeled {
+			fmt.Printf("%s: not compared, no %s label\n", name, report.Label)
+		}
+	}
+	if report.Differences() > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"cbmonitor/internal/monitor"
+	"testing"
+)
+
+func TestFleetClusters(t *testing.T) {
+	tests := []struct {
+		name      string
+		state     monitor.State
+		lastError string
+		available bool
+	}{
+		{name: "up", state: monitor.StateUp, available: true},
+		{name: "unhealthy", state: monitor.StateDegraded, available: true},
+		{name: "partial", state: monitor.StateDegraded, lastError: "cannot read buckets"},
+		{name: "down", state: monitor.StateDown, lastError: "connection refused"},
+		{name: "unknown", state: monitor.StateUnknown},
+	}
+	for _, test := range tests {
+		entry := newTestEntry("east", "", nil)
+		entry.Labels = map[string]string{"env": "prod"}
+		entry.Status.State = test.state
+		entry.Status.LastError = test.lastError
+		clusters := fleetClusters([]ClusterEntry{entry})
+		if len(clusters) != 1 || clusters[0].Name != "east" || clusters[0].Labels["env"] != "prod" ||
+			clusters[0].Available != test.available {
+			t.Errorf("%s: fleetClusters() = %+v, want available %v", test.name, clusters, test.available)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/election"
+	"cbmonitor/internal/lifecycle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+// forwardedHeader marks the requests forwarded by a standby replica, they are never forwarded again
+const forwardedHeader = "X-Cbmonitor-Forwarded"
+
+// forwardToLeader forwards a request received by a standby replica to the leader, so that the alert
+// history listed and acknowledged is always the leader's. It returns false when the replica leads
+func forwardToLeader(w http.ResponseWriter, r *http.Request, elector *election.Elector) bool {
+	if elector.IsLeader() {
+		return false
+	}
+	status := elector.Status()
+	if status.LeaderURL == "" || r.Header.Get(forwardedHeader) != "" {
+		http.Error(w, "no leader to forward the request to", http.StatusServiceUnavailable)
+		return true
+	}
+	target, err := url.Parse(status.LeaderURL)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid leader url: %s", err), http.StatusServiceUnavailable)
+		return true
+	}
+	r.Header.Set(forwardedHeader, status.ID)
+	httputil.NewSingleHostReverseProxy(target).ServeHTTP(w, r)
+	return true
+}
+
+// adoptAcknowledgements copies the acknowledgements of the alerts firing on the leader to the alert
+// history of a standby replica, so that they are kept when it takes over
+func adoptAcknowledgements(settings config.HA, elector *election.Elector, tracker *lifecycle.Tracker) error {
+	leader := elector.Status().LeaderURL
+	if leader == "" {
+		return nil
+	}
+	request, err := http.NewRequest(http.MethodGet, leader+"/alerts?state="+lifecycle.StateFiring, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set(forwardedHeader, settings.ID)
+	if settings.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+settings.Token)
+	}
+	client := &http.Client{Timeout: settings.Lease.Duration / 3}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("leader answered %s", response.Status)
+	}
+	var records []lifecycle.Record
+	if err := json.NewDecoder(response.Body).Decode(&records); err != nil {
+		return fmt.Errorf("invalid alert history from the leader: %w", err)
+	}
+	tracker.Adopt(records)
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"cbmonitor/internal/advisor"
+	"cbmonitor/internal/config"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// runList prints the configured clusters with their resolved address
+func runList(args []string) {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	configFile := flags.String("config", "./config.json", "Configuration file path")
+	flags.Parse(args)
+	configuration, err := config.NewFileConfiguration(*configFile)
+	exitOnError("Cannot read configuration", err)
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "NAME\tHOSTNAME\tPORT\tPROTOCOL\tURL\tUSER")
+	for _, cluster := range configuration {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s://%s:%s\t%s\n", cluster.Name, cluster.Hostname, cluster.Port,
+			cluster.Protocol, cluster.Protocol, cluster.Hostname, cluster.Port, cluster.Credentials.Username)
+	}
+	writer.Flush()
+}
+
+// runValidateConfig checks the configuration file, it exits with 1 when it is not valid
+func runValidateConfig(args []string) {
+	flags := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	configFile := flags.String("config", "./config.json", "Configuration file path")
+	flags.Parse(args)
+	configuration, err := config.LoadFile(*configFile)
+	if err != nil {
+		fmt.Printf("%s: %s\n", *configFile, err)
+		os.Exit(1)
+	}
+	problems := configuration.Validate()
+	if _, err := advisor.NewAdvisor(configuration.Advisor); err != nil {
+		problems = append(problems, err)
+	}
+	for _, problem := range problems {
+		fmt.Printf("%s: %s\n", *configFile, problem)
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+	fmt.Printf("%s: valid, %d clusters, %d rules\n", *configFile, len(configuration.Clusters), len(configuration.Rules))
+}
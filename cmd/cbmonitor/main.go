@@ -2,18 +2,15 @@ package main
 
 import (
 	"cbmonitor/internal/config"
+	"cbmonitor/internal/labels"
+	"cbmonitor/internal/logging"
 	"cbmonitor/internal/monitor"
-	"cbmonitor/internal/monitor/stats"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"log"
-	"net/http"
+	"log/slog"
 	"os"
-	"sync"
+	"strings"
 	"time"
-
-	"github.com/go-chi/chi"
 )
 
 const (
@@ -22,97 +19,160 @@ const (
 )
 
 // ToDo:
-//  - Add prometheus metrics
 //  - Create docker file
 
-// ClustersContainer keeps track of statistics of multiple clusters
-type ClustersContainer struct {
-	clusters map[string]stats.ClusterStats
-	mu       sync.RWMutex
+// command subcommand of the CLI, run receives the arguments following the command name
+type command struct {
+	name        string
+	description string
+	run         func(args []string)
+}
+
+var commands = []command{
+	{"serve", "scrape the clusters and serve the API and dashboard (default)", runServe},
+	{"tui", "live terminal dashboard", runTUI},
+	{"check", "check the clusters once with Nagios/Icinga exit codes", runCheck},
+	{"snapshot", "scrape the clusters once and write their statistics as JSON", runSnapshot},
+	{"diff", "compare two snapshot files", runDiff},
+	{"fleet", "report the configuration drift between clusters sharing a label", runFleet},
+	{"list", "print the configured clusters", runList},
+	{"validate-config", "validate the configuration file", runValidateConfig},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", cmd.name, cmd.description)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command\n", os.Args[0])
 }
 
-func NewClustersContainer() ClustersContainer {
-	return ClustersContainer{
-		clusters: make(map[string]stats.ClusterStats),
+func exitOnError(message string, err error) {
+	if err != nil {
+		slog.Error(message, "error", err)
+		os.Exit(1)
 	}
 }
 
-// Add refreshes the information for a given cluster
-func (cc *ClustersContainer) Add(stats stats.ClusterStats) {
-	cc.mu.Lock()
-	cc.clusters[stats.Name] = stats
-	cc.mu.Unlock()
+// logOptions logging flags shared by the long running commands
+type logOptions struct {
+	level  *string
+	format *string
 }
 
-// GetAll returns the information of all clusters
-func (cc *ClustersContainer) GetAll() []stats.ClusterStats {
-	cc.mu.RLock()
-	all := make([]stats.ClusterStats, len(cc.clusters))
-	ndx := 0
-	for _, stats := range cc.clusters {
-		all[ndx] = stats
+func addLogFlags(flags *flag.FlagSet) logOptions {
+	return logOptions{
+		level:  flags.String("log-level", "info", "Log level: debug, info, warn or error"),
+		format: flags.String("log-format", logging.FormatLogfmt, "Log format: logfmt or json"),
 	}
-	cc.mu.RUnlock()
-	return all
 }
 
-func exitOnError(message string, err error) {
+// setup sends the log entries to the standard error with the requested level and format
+func (o logOptions) setup() {
+	if err := logging.Setup(os.Stderr, *o.level, *o.format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+}
+
+// selectClusters keeps the configured clusters matching the label selector (name=value pairs)
+func selectClusters(configuration []config.Cluster, selector string) ([]config.Cluster, error) {
+	parsed, err := labels.Parse(selector)
 	if err != nil {
-		log.Printf("%s: %s\n", message, err)
-		os.Exit(1)
+		return nil, err
 	}
+	selected := []config.Cluster{}
+	for _, cluster := range configuration {
+		if parsed.Matches(cluster.Labels) {
+			selected = append(selected, cluster)
+		}
+	}
+	return selected, nil
 }
 
-func main() {
-	configFile := flag.String("config", "./config.json", "Configuration file path")
-	scrapInterval := flag.Duration("interval", 15*time.Second, "Monitoring interval")
-	callsTimeout := flag.Duration("timeout", 3*time.Second, "Monitoring call timeout")
-	defaultPassword := flag.String("password", "", "Default password (if you don't want to set one in config file)")
-	flag.Parse()
-	configuration, err := config.NewFileConfiguration(*configFile)
-	exitOnError("Cannot read configuration", err)
-	log.Printf("Using configuration from file: %s, found %d clusters", *configFile, len(configuration))
+// buildMonitors creates a monitor for every configured cluster
+func buildMonitors(configuration []config.Cluster, timeout time.Duration, defaultPassword string) []*monitor.Monitor {
 	monitors := make([]*monitor.Monitor, len(configuration))
 	for i, cluster := range configuration {
 		pass := cluster.Credentials.Password
 		if pass == "" {
-			pass = *defaultPassword
+			pass = defaultPassword
 		}
-		log.Printf("\t- %s @ %s://%s:%s\n", cluster.Name, cluster.Protocol, cluster.Hostname, cluster.Port)
+		slog.Info("Monitoring cluster", logging.ClusterKey, cluster.Name, logging.HostKey, cluster.Hostname,
+			"url", fmt.Sprintf("%s://%s:%s", cluster.Protocol, cluster.Hostname, cluster.Port))
 		monitor, err := monitor.NewMonitor(cluster.Hostname, cluster.Name, cluster.Credentials.Username,
 			pass, cluster.Protocol, cluster.Port)
 		exitOnError("Cannot create monitor", err)
-		monitor.SetTimeout(*callsTimeout)
+		monitor.SetTimeout(timeout)
+		monitor.SetLabels(cluster.Labels)
 		monitors[i] = monitor.Build()
 	}
+	return monitors
+}
 
-	fullClusterStats := NewClustersContainer()
-	go func() {
-		for {
-			log.Println("Processing")
-			responses := make(chan monitor.ClusterInfo, len(monitors))
-			for _, monitor := range monitors {
+func monitorNames(monitors []*monitor.Monitor) []string {
+	names := make([]string, len(monitors))
+	for i, monitor := range monitors {
+		names[i] = monitor.Name()
+	}
+	return names
+}
+
+// defaultConcurrency number of clusters scraped at once by default
+const defaultConcurrency = 16
+
+// scrapeOnce checks every cluster once, up to concurrency clusters at once, handle is called for every
+// result as they complete
+func scrapeOnce(monitors []*monitor.Monitor, concurrency int, handle func(monitor.ClusterInfo)) {
+	queue := make(chan *monitor.Monitor, len(monitors))
+	for _, monitor := range monitors {
+		queue <- monitor
+	}
+	close(queue)
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	responses := make(chan monitor.ClusterInfo, len(monitors))
+	for i := 0; i < concurrency && i < len(monitors); i++ {
+		go func() {
+			for monitor := range queue {
 				monitor.Check(responses)
 			}
-			for i := 0; i < len(monitors); i++ {
-				resp := <-responses
-				if resp.Err == nil {
-					fmt.Println(resp.Stats)
-					fullClusterStats.Add(resp.Stats)
-				} else {
-					fmt.Println(resp.Err)
-				}
-			}
-			close(responses)
-			time.Sleep(*scrapInterval)
+		}()
+	}
+	for i := 0; i < len(monitors); i++ {
+		handle(<-responses)
+	}
+	close(responses)
+}
+
+// scrapeLoop checks every cluster each interval forever, handle is called for every result
+func scrapeLoop(monitors []*monitor.Monitor, interval time.Duration, concurrency int,
+	handle func(monitor.ClusterInfo)) {
+	for cycle := 1; ; cycle++ {
+		slog.Debug("Scrape cycle started", "cycle", cycle, "clusters", len(monitors))
+		scrapeOnce(monitors, concurrency, handle)
+		time.Sleep(interval)
+	}
+}
+
+func main() {
+	// without a command (or with flags only) cbmonitor keeps behaving as a server
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
+		runServe(os.Args[1:])
+		return
+	}
+	for _, cmd := range commands {
+		if cmd.name == os.Args[1] {
+			cmd.run(os.Args[2:])
+			return
 		}
-	}()
-	r := chi.NewRouter()
-	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
-		clusters := fullClusterStats.GetAll()
-		clustersBytes, _ := json.Marshal(clusters)
-		w.Header().Set(mimeType, appJson)
-		w.Write(clustersBytes)
-	})
-	http.ListenAndServe(":3000", r)
+	}
+	if os.Args[1] != "help" {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", os.Args[1])
+	}
+	usage()
+	if os.Args[1] != "help" {
+		os.Exit(2)
+	}
 }
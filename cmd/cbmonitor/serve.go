@@ -0,0 +1,804 @@
+package main
+
+import (
+	"cbmonitor/internal/advisor"
+	"cbmonitor/internal/alerts"
+	"cbmonitor/internal/anomaly"
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/dashboard"
+	"cbmonitor/internal/election"
+	"cbmonitor/internal/events"
+	"cbmonitor/internal/fleet"
+	"cbmonitor/internal/forecast"
+	"cbmonitor/internal/graphite"
+	"cbmonitor/internal/history"
+	"cbmonitor/internal/influx"
+	"cbmonitor/internal/labels"
+	"cbmonitor/internal/lifecycle"
+	"cbmonitor/internal/metrics"
+	"cbmonitor/internal/metrics/couchbase"
+	"cbmonitor/internal/monitor"
+	"cbmonitor/internal/notify"
+	"cbmonitor/internal/otlp"
+	"cbmonitor/internal/shard"
+	"cbmonitor/internal/sink"
+	"cbmonitor/internal/statsd"
+	"cbmonitor/internal/storage"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+const defaultListenAddress = ":3000"
+
+type historyResponse struct {
+	Cluster string                     `json:"cluster"`
+	Series  map[string][]history.Point `json:"series"`
+}
+
+type forecastResponse struct {
+	Cluster   string              `json:"cluster"`
+	Forecasts []forecast.Forecast `json:"forecasts"`
+	Alerts    []alerts.Alert      `json:"alerts"`
+}
+
+type advisorResponse struct {
+	Cluster  string            `json:"cluster"`
+	Findings []advisor.Finding `json:"findings"`
+	Alerts   []alerts.Alert    `json:"alerts"`
+}
+
+type anomalyResponse struct {
+	Cluster   string            `json:"cluster"`
+	Anomalies []anomaly.Anomaly `json:"anomalies"`
+	Alerts    []alerts.Alert    `json:"alerts"`
+}
+
+// ackRequest body of an acknowledgement, By is replaced by the user of basic credentials
+type ackRequest struct {
+	By      string `json:"by"`
+	Comment string `json:"comment"`
+}
+
+type routeResponse struct {
+	Alert     alerts.Alert      `json:"alert"`
+	Decisions []notify.Decision `json:"decisions"`
+}
+
+// selfStatus state of the monitor itself
+type selfStatus struct {
+	StartedAt     time.Time               `json:"startedAt"`
+	Uptime        string                  `json:"uptime"`
+	Sinks         []sink.Status           `json:"sinks"`
+	Outputs       selfOutputs             `json:"outputs"`
+	Notifications []notify.ReceiverStatus `json:"notifications,omitempty"`
+	HA            *election.Status        `json:"ha,omitempty"`
+	Sharding      *shard.Status           `json:"sharding,omitempty"`
+}
+
+// shardResponse shard of the instance and the clusters it owns
+type shardResponse struct {
+	shard.Status
+	Clusters []string `json:"clusters"`
+}
+
+// federationResponse clusters of every instance sharing the fleet
+type federationResponse struct {
+	Instances []federatedInstance `json:"instances"`
+	Clusters  []ClusterEntry      `json:"clusters"`
+}
+
+// federatedInstance outcome of the query of an instance by the federation endpoint
+type federatedInstance struct {
+	ID       string `json:"id"`
+	Self     bool   `json:"self,omitempty"`
+	Clusters int    `json:"clusters"`
+	Error    string `json:"error,omitempty"`
+}
+
+type selfOutputs struct {
+	OTLP *otlp.Status `json:"otlp,omitempty"`
+}
+
+// runServe scrapes the configured clusters forever and serves their statistics through the API
+func runServe(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	configFile := flags.String("config", "./config.json", "Configuration file path")
+	scrapInterval := flags.Duration("interval", 15*time.Second, "Monitoring interval")
+	callsTimeout := flags.Duration("timeout", 3*time.Second, "Monitoring call timeout")
+	defaultPassword := flags.String("password", "", "Default password (if you don't want to set one in config file)")
+	listenAddress := flags.String("listen", "", "API listen address (default \":3000\" or the server listen setting)")
+	tlsCert := flags.String("tls-cert", "", "TLS certificate file to serve the API over HTTPS")
+	tlsKey := flags.String("tls-key", "", "TLS key file to serve the API over HTTPS")
+	concurrency := flags.Int("concurrency", defaultConcurrency, "Number of clusters scraped at once")
+	historySize := flags.Int("history", history.DefaultCapacity, "Number of samples kept in memory per cluster")
+	printStats := flags.Bool("print-stats", false, "Print the statistics of every scrape to the standard output")
+	logFlags := addLogFlags(flags)
+	flags.Parse(args)
+	logFlags.setup()
+	startedAt := time.Now()
+	fileConfiguration, err := config.LoadFile(*configFile)
+	exitOnError("Cannot read configuration", err)
+	configuration := fileConfiguration.Clusters
+	serverConfiguration := fileConfiguration.Server
+	if *listenAddress != "" {
+		serverConfiguration.Listen = *listenAddress
+	}
+	if serverConfiguration.Listen == "" {
+		serverConfiguration.Listen = defaultListenAddress
+	}
+	if *tlsCert != "" || *tlsKey != "" {
+		serverConfiguration.TLS = config.TLS{CertFile: *tlsCert, KeyFile: *tlsKey}
+	}
+	slog.Info("Configuration loaded", "file", *configFile, "clusters", len(configuration))
+	monitors := buildMonitors(configuration, *callsTimeout, *defaultPassword)
+	fullClusterStats := NewClustersContainer(monitors)
+	statsHistory := history.NewStore(*historySize)
+	outputs := fileConfiguration.Outputs
+	elector := election.NewElector(fileConfiguration.HA)
+	membership := shard.NewMembership(fileConfiguration.Sharding)
+	pushed := func(s sink.Sink) sink.Sink {
+		return sink.Gated(s, elector.IsLeader)
+	}
+	sinks := sink.NewRegistry()
+	if outputs.Container.IsEnabled() {
+		sinks.Register(sink.Func("container", func(info monitor.ClusterInfo) error {
+			fullClusterStats.Add(info)
+			return nil
+		}), outputs.Container.BufferSize)
+	} else {
+		slog.Warn("Container output disabled, the API will not serve any statistics")
+	}
+	if outputs.Stdout.IsEnabled() || *printStats {
+		sinks.Register(sink.NewWriter(os.Stdout), outputs.Stdout.BufferSize)
+	}
+	sinks.Register(sink.Stats("history", statsHistory.Add), config.DefaultSinkBufferSize)
+	forecaster := forecast.NewForecaster(fileConfiguration.Forecast)
+	sinks.Register(sink.Stats("forecast", forecaster.Add), config.DefaultSinkBufferSize)
+	store, err := storage.Open(fileConfiguration.Storage.Path)
+	exitOnError("Cannot open storage", err)
+	detector, err := anomaly.NewDetector(fileConfiguration.Anomaly, store)
+	exitOnError("Cannot create anomaly detector", err)
+	sinks.Register(sink.Stats("anomaly", detector.Add), config.DefaultSinkBufferSize)
+	clusterAdvisor, err := advisor.NewAdvisor(fileConfiguration.Advisor)
+	exitOnError("Cannot create advisor", err)
+	sinks.Register(sink.Stats("advisor", clusterAdvisor.Add), config.DefaultSinkBufferSize)
+	tracker, err := lifecycle.NewTracker(fileConfiguration.AlertHistory, store)
+	exitOnError("Cannot load alert history", err)
+	journal, err := events.NewJournal(fileConfiguration.Events, store)
+	exitOnError("Cannot load events", err)
+	sinks.Register(sink.Func("events", journal.Add), config.DefaultSinkBufferSize)
+	stop := make(chan struct{})
+	var running sync.WaitGroup
+	if elector.Enabled() {
+		slog.Info("Leader election enabled", "id", fileConfiguration.HA.ID, "lock", fileConfiguration.HA.LockFile,
+			"lease", fileConfiguration.HA.Lease)
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			elector.Run(stop)
+		}()
+	}
+	if membership.Enabled() {
+		settings := fileConfiguration.Sharding
+		slog.Info("Sharding enabled", "id", membership.Status().ID, "count", settings.Count, "peers",
+			len(settings.Peers))
+		membership.Probe(time.Now())
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			membership.Run(stop)
+		}()
+	}
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		detector.Run(stop)
+	}()
+	if output := outputs.Influx; output != nil {
+		slog.Info("Pushing statistics to InfluxDB", "url", output.URL)
+		influxWriter := influx.NewWriter(*output)
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			influxWriter.Run(stop)
+		}()
+		sinks.Register(pushed(sink.Stats("influx", influxWriter.Add)), config.DefaultSinkBufferSize)
+	}
+	if output := outputs.Graphite; output != nil {
+		slog.Info("Sending statistics to Graphite", "address", output.Address)
+		graphiteWriter := graphite.NewWriter(*output)
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			graphiteWriter.Run(stop)
+		}()
+		sinks.Register(pushed(sink.Stats("graphite", graphiteWriter.Add)), config.DefaultSinkBufferSize)
+	}
+	if output := outputs.StatsD; output != nil {
+		slog.Info("Sending statistics to StatsD", "address", output.Address)
+		statsdEmitter := statsd.NewEmitter(*output)
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			statsdEmitter.Run(stop)
+		}()
+		sinks.Register(pushed(sink.Stats("statsd", statsdEmitter.Add)), config.DefaultSinkBufferSize)
+	}
+	var otlpExporter *otlp.Exporter
+	if output := outputs.OTLP; output != nil {
+		slog.Info("Exporting metrics to OpenTelemetry collector", "endpoint", output.Endpoint, "protocol",
+			output.Protocol)
+		otlpExporter, err = otlp.NewExporter(*output)
+		exitOnError("Cannot configure OTLP output", err)
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			otlpExporter.Run(stop, elector.IsLeader)
+		}()
+		sinks.Register(pushed(sink.Stats("otlp", otlpExporter.Add)), config.DefaultSinkBufferSize)
+	}
+	if membership.Enabled() {
+		go scrapeShard(monitors, *scrapInterval, *concurrency, membership, &fullClusterStats, sinks.Publish)
+	} else {
+		go scrapeLoop(monitors, *scrapInterval, *concurrency, sinks.Publish)
+	}
+	raisedAlerts := func() []alerts.Alert {
+		raised := []alerts.Alert{}
+		now := time.Now()
+		for _, entry := range fullClusterStats.GetAll() {
+			name := entry.Status.Name
+			clusterAlerts := statusAlerts(entry.Status)
+			// a down cluster keeps the alerts of its last good statistics, as its forecasts, anomalies and
+			// findings, so that they do not resolve while it cannot be scraped
+			if len(entry.Nodes) > 0 {
+				clusterAlerts = append(clusterAlerts, alerts.Calculated(name, entry.ClusterStats)...)
+				clusterAlerts = append(clusterAlerts, alerts.Evaluate(name, entry.ClusterStats, fileConfiguration.Rules)...)
+			}
+			clusterAlerts = append(clusterAlerts, forecaster.Alerts(name, forecaster.Cluster(name, now))...)
+			clusterAlerts = append(clusterAlerts, detector.Alerts(name)...)
+			clusterAlerts = append(clusterAlerts, clusterAdvisor.Alerts(name)...)
+			for i := range clusterAlerts {
+				clusterAlerts[i].Labels = entry.Labels
+			}
+			raised = append(raised, clusterAlerts...)
+		}
+		return raised
+	}
+	forgetClusters := func(clusters []string) {
+		statsHistory.Forget(clusters)
+		forecaster.Forget(clusters)
+		detector.Forget(clusters)
+		clusterAdvisor.Forget(clusters)
+		if otlpExporter != nil {
+			otlpExporter.Forget(clusters)
+		}
+	}
+	dispatcher, err := notify.NewDispatcher(fileConfiguration.Notifications)
+	exitOnError("Cannot configure notifications", err)
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		evaluateAlerts(stop, *scrapInterval, &fullClusterStats, raisedAlerts, forgetClusters, tracker, journal,
+			dispatcher, elector, fileConfiguration.HA)
+	}()
+	go stopOnSignal(stop, sinks, &running)
+	authenticator := newAPIAuthenticator(serverConfiguration.Auth)
+	r := chi.NewRouter()
+	r.Use(authenticator.Require(config.ScopeRead))
+	clustersHandler := func(w http.ResponseWriter, r *http.Request) {
+		clusters := selectEntries(fullClusterStats.GetAll(), labels.FromQuery(r.URL.Query()))
+		addDerivedAlerts(clusters, forecaster, detector, clusterAdvisor, time.Now())
+		clustersBytes, _ := json.Marshal(clusters)
+		w.Header().Set(mimeType, appJson)
+		w.Write(clustersBytes)
+	}
+	r.Get("/", clustersHandler)
+	r.Get("/clusters", clustersHandler)
+	r.Get("/status", func(w http.ResponseWriter, r *http.Request) {
+		clusters := selectEntries(fullClusterStats.GetAll(), labels.FromQuery(r.URL.Query()))
+		statuses := make([]monitor.ClusterStatus, len(clusters))
+		for i, entry := range clusters {
+			statuses[i] = entry.Status
+		}
+		statusBytes, _ := json.Marshal(statuses)
+		w.Header().Set(mimeType, appJson)
+		w.Write(statusBytes)
+	})
+	r.Get("/groups", func(w http.ResponseWriter, r *http.Request) {
+		label := r.URL.Query().Get("label")
+		if label == "" {
+			http.Error(w, "missing label parameter", http.StatusBadRequest)
+			return
+		}
+		groupsBytes, _ := json.Marshal(groupEntries(fullClusterStats.GetAll(), label))
+		w.Header().Set(mimeType, appJson)
+		w.Write(groupsBytes)
+	})
+	r.Get("/self", func(w http.ResponseWriter, r *http.Request) {
+		status := selfStatus{
+			StartedAt: startedAt,
+			Uptime:    time.Since(startedAt).Round(time.Second).String(),
+			Sinks:     sinks.Status(),
+		}
+		if otlpExporter != nil {
+			exporterStatus := otlpExporter.Status()
+			status.Outputs.OTLP = &exporterStatus
+		}
+		if dispatcher.Enabled() {
+			status.Notifications = dispatcher.Status()
+		}
+		if elector.Enabled() {
+			leadership := elector.Status()
+			status.HA = &leadership
+		}
+		if membership.Enabled() {
+			sharding := membership.Status()
+			status.Sharding = &sharding
+		}
+		statusBytes, _ := json.Marshal(status)
+		w.Header().Set(mimeType, appJson)
+		w.Write(statusBytes)
+	})
+	r.Get(shard.Path, func(w http.ResponseWriter, r *http.Request) {
+		response := shardResponse{Status: membership.Status(), Clusters: []string{}}
+		for _, entry := range fullClusterStats.GetAll() {
+			response.Clusters = append(response.Clusters, entry.Status.Name)
+		}
+		shardBytes, _ := json.Marshal(response)
+		w.Header().Set(mimeType, appJson)
+		w.Write(shardBytes)
+	})
+	r.Get("/federation", func(w http.ResponseWriter, r *http.Request) {
+		local := selectEntries(fullClusterStats.GetAll(), labels.FromQuery(r.URL.Query()))
+		federationBytes, _ := json.Marshal(federate(membership, r.URL.Query(), local))
+		w.Header().Set(mimeType, appJson)
+		w.Write(federationBytes)
+	})
+	r.Get("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		entries := selectEntries(fullClusterStats.GetAll(), labels.FromQuery(r.URL.Query()))
+		clusters := make([]couchbase.Cluster, len(entries))
+		for i, entry := range entries {
+			clusters[i] = couchbase.Cluster{
+				Name:     entry.Status.Name,
+				State:    entry.Status.State,
+				Stats:    entry.ClusterStats,
+				HasStats: entry.Status.State == monitor.StateUp || entry.Status.State == monitor.StateDegraded,
+			}
+		}
+		w.Header().Set(mimeType, "text/plain; version=0.0.4; charset=utf-8")
+		metrics.WriteText(w, append(couchbase.Families(clusters), metrics.Default.Families()...))
+	})
+	r.Get("/forecast", func(w http.ResponseWriter, r *http.Request) {
+		names := requestedClusters(r, fullClusterStats.GetAll())
+		now := time.Now()
+		response := make([]forecastResponse, len(names))
+		for i, name := range names {
+			forecasts := forecaster.Cluster(name, now)
+			response[i] = forecastResponse{Cluster: name, Forecasts: forecasts, Alerts: forecaster.Alerts(name, forecasts)}
+		}
+		forecastBytes, _ := json.Marshal(response)
+		w.Header().Set(mimeType, appJson)
+		w.Write(forecastBytes)
+	})
+	r.Get("/anomalies", func(w http.ResponseWriter, r *http.Request) {
+		names := requestedClusters(r, fullClusterStats.GetAll())
+		response := make([]anomalyResponse, len(names))
+		for i, name := range names {
+			response[i] = anomalyResponse{Cluster: name, Anomalies: detector.Anomalies(name), Alerts: detector.Alerts(name)}
+		}
+		anomalyBytes, _ := json.Marshal(response)
+		w.Header().Set(mimeType, appJson)
+		w.Write(anomalyBytes)
+	})
+	r.Get("/advisor", func(w http.ResponseWriter, r *http.Request) {
+		names := requestedClusters(r, fullClusterStats.GetAll())
+		response := make([]advisorResponse, len(names))
+		for i, name := range names {
+			response[i] = advisorResponse{Cluster: name, Findings: clusterAdvisor.Findings(name),
+				Alerts: clusterAdvisor.Alerts(name)}
+		}
+		advisorBytes, _ := json.Marshal(response)
+		w.Header().Set(mimeType, appJson)
+		w.Write(advisorBytes)
+	})
+	r.Get("/fleet", func(w http.ResponseWriter, r *http.Request) {
+		report := fleet.Compare(r.URL.Query().Get("label"), fleetClusters(fullClusterStats.GetAll()))
+		reportBytes, _ := json.Marshal(report)
+		w.Header().Set(mimeType, appJson)
+		w.Write(reportBytes)
+	})
+	r.Get("/routes", func(w http.ResponseWriter, r *http.Request) {
+		raised := raisedAlerts()
+		if query := r.URL.Query(); len(query) > 0 {
+			alert, err := queryAlert(query)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			raised = []alerts.Alert{alert}
+		}
+		response := make([]routeResponse, len(raised))
+		for i, alert := range raised {
+			response[i] = routeResponse{Alert: alert, Decisions: dispatcher.Route(alert)}
+		}
+		routesBytes, _ := json.Marshal(response)
+		w.Header().Set(mimeType, appJson)
+		w.Write(routesBytes)
+	})
+	r.Get("/alerts", func(w http.ResponseWriter, r *http.Request) {
+		if forwardToLeader(w, r, elector) {
+			return
+		}
+		query, err := historyQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query.Clusters = requestedClusters(r, fullClusterStats.GetAll(), "state", "from", "to")
+		recordsBytes, _ := json.Marshal(tracker.Records(query, time.Now()))
+		w.Header().Set(mimeType, appJson)
+		w.Write(recordsBytes)
+	})
+	r.Get("/events", func(w http.ResponseWriter, r *http.Request) {
+		from, to, err := timeRange(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query := events.Query{
+			Clusters: requestedClusters(r, fullClusterStats.GetAll(), "kind", "node", "bucket", "from", "to"),
+			Kinds:    r.URL.Query()["kind"],
+			Nodes:    r.URL.Query()["node"],
+			Buckets:  r.URL.Query()["bucket"],
+			From:     from,
+			To:       to,
+		}
+		eventsBytes, _ := json.Marshal(journal.Events(query))
+		w.Header().Set(mimeType, appJson)
+		w.Write(eventsBytes)
+	})
+	r.With(authenticator.Require(config.ScopeAdmin)).Post("/alerts/{id}/ack", func(w http.ResponseWriter, r *http.Request) {
+		if forwardToLeader(w, r, elector) {
+			return
+		}
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid alert id", http.StatusBadRequest)
+			return
+		}
+		var request ackRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, fmt.Sprintf("invalid acknowledgement: %s", err), http.StatusBadRequest)
+				return
+			}
+		}
+		if username, _, ok := r.BasicAuth(); ok {
+			request.By = username
+		}
+		if request.By == "" {
+			http.Error(w, "missing by, the name of who acknowledges the alert", http.StatusBadRequest)
+			return
+		}
+		record, err := tracker.Acknowledge(id, lifecycle.Acknowledgement{By: request.By, Comment: request.Comment,
+			Time: time.Now()})
+		switch {
+		case errors.Is(err, lifecycle.ErrUnknownRecord):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, lifecycle.ErrResolved):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err := tracker.Save(); err != nil {
+			slog.Error("Cannot save alert history", "error", err)
+		}
+		slog.Info("Alert acknowledged", "id", id, "cluster", record.Alert.Cluster, "by", request.By)
+		recordBytes, _ := json.Marshal(record)
+		w.Header().Set(mimeType, appJson)
+		w.Write(recordBytes)
+	})
+	r.Get("/history", func(w http.ResponseWriter, r *http.Request) {
+		cluster := r.URL.Query().Get("cluster")
+		if cluster == "" {
+			http.Error(w, "missing cluster parameter", http.StatusBadRequest)
+			return
+		}
+		metrics := r.URL.Query()["metric"]
+		if len(metrics) == 0 {
+			metrics = statsHistory.Metrics(cluster)
+		}
+		series := make(map[string][]history.Point, len(metrics))
+		for _, metric := range metrics {
+			series[metric] = statsHistory.Series(cluster, metric)
+		}
+		historyBytes, _ := json.Marshal(historyResponse{Cluster: cluster, Series: series})
+		w.Header().Set(mimeType, appJson)
+		w.Write(historyBytes)
+	})
+	r.Get("/dashboard", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/dashboard/", http.StatusMovedPermanently)
+	})
+	r.Handle("/dashboard/*", dashboard.Handler(*scrapInterval))
+	exitOnError("Cannot serve API", serve(r, serverConfiguration))
+}
+
+// historyQuery reads the state and the time range of the alert history query parameters
+func historyQuery(r *http.Request) (lifecycle.Query, error) {
+	query := lifecycle.Query{State: r.URL.Query().Get("state")}
+	switch query.State {
+	case "", lifecycle.StateFiring, lifecycle.StateResolved:
+	default:
+		return lifecycle.Query{}, fmt.Errorf("invalid state %q, expected %q or %q", query.State,
+			lifecycle.StateFiring, lifecycle.StateResolved)
+	}
+	from, to, err := timeRange(r.URL.Query())
+	if err != nil {
+		return lifecycle.Query{}, err
+	}
+	query.From, query.To = from, to
+	return query, nil
+}
+
+// timeRange reads the RFC 3339 from and to query parameters, missing ones are left zero
+func timeRange(query url.Values) (from, to time.Time, err error) {
+	for name, target := range map[string]*time.Time{"from": &from, "to": &to} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid %s time %q, expected RFC 3339", name, value)
+		}
+		*target = parsed
+	}
+	return from, to, nil
+}
+
+// queryAlert builds the alert described by the query parameters: cluster, severity, type, name, bucket,
+// node and any other parameter as a cluster label
+func queryAlert(query url.Values) (alerts.Alert, error) {
+	alert := alerts.Alert{
+		Cluster: query.Get("cluster"),
+		Type:    query.Get("type"),
+		Name:    query.Get("name"),
+		Bucket:  query.Get("bucket"),
+		Node:    query.Get("node"),
+		Labels:  map[string]string{},
+	}
+	if severity := query.Get("severity"); severity != "" {
+		if err := alert.Severity.UnmarshalText([]byte(severity)); err != nil {
+			return alerts.Alert{}, err
+		}
+	}
+	for name := range labels.FromQuery(query, "cluster", "severity", "type", "name", "bucket", "node") {
+		alert.Labels[name] = query.Get(name)
+	}
+	return alert, nil
+}
+
+// evaluateAlerts records the lifecycle of the raised alerts, dispatches their notifications and announces
+// the new events every interval until stop is closed, the alert history and the events are saved after
+// each evaluation. Only the leader notifies, standby replicas track the notifications without sending
+// them, adopt the acknowledgements of the leader and hold the new events until they lead. The alerts of
+// the clusters given up to another shard are resolved in the history and forgotten without notifying
+// their resolution, forget drops what the other consumers of their statistics kept
+func evaluateAlerts(stop <-chan struct{}, interval time.Duration, container *ClustersContainer,
+	raisedAlerts func() []alerts.Alert, forget func(clusters []string), tracker *lifecycle.Tracker,
+	journal *events.Journal, dispatcher *notify.Dispatcher, elector *election.Elector, settings config.HA) {
+	monitored := map[string]map[string]string{}
+	held := []events.Event{}
+	// a standby takes over at most a lease and a renewal after the leader stopped, and evaluates within
+	// an interval: the events it detected during that time are announced once it leads
+	holding := 2*settings.Lease.Duration + interval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			saveState(tracker, journal)
+			return
+		case now := <-ticker.C:
+			evaluated := []string{}
+			clusterLabels := map[string]map[string]string{}
+			for _, entry := range container.GetAll() {
+				if entry.Status.State != monitor.StateUnknown {
+					evaluated = append(evaluated, entry.Status.Name)
+				}
+				clusterLabels[entry.Status.Name] = entry.Labels
+			}
+			givenUp := []string{}
+			for name := range monitored {
+				if _, found := clusterLabels[name]; !found {
+					givenUp = append(givenUp, name)
+				}
+			}
+			if len(givenUp) > 0 {
+				dispatcher.Forget(givenUp)
+				tracker.Forget(givenUp, now)
+				journal.Forget(givenUp)
+				forget(givenUp)
+			}
+			monitored = clusterLabels
+			leader := elector.IsLeader()
+			if !leader {
+				if err := adoptAcknowledgements(settings, elector, tracker); err != nil {
+					slog.Warn("Cannot adopt the acknowledgements of the leader", "error", err)
+				}
+			}
+			raised := tracker.Observe(evaluated, raisedAlerts(), now)
+			detected := append(held, journal.Take()...)
+			held = []events.Event{}
+			if !leader {
+				dispatcher.Track(raised, now)
+				for _, event := range detected {
+					if now.Sub(event.Detected) < holding {
+						held = append(held, event)
+					}
+				}
+				saveState(tracker, journal)
+				continue
+			}
+			dispatcher.Dispatch(raised, now)
+			announced := make([]alerts.Alert, len(detected))
+			for i, event := range detected {
+				announced[i] = event.Alert()
+				announced[i].Labels = clusterLabels[event.Cluster]
+			}
+			dispatcher.Announce(announced, now)
+			saveState(tracker, journal)
+		}
+	}
+}
+
+// scrapeShard checks the clusters owned by the instance each interval forever, handle is called for every
+// result. The container is restricted to the owned clusters before every cycle
+func scrapeShard(monitors []*monitor.Monitor, interval time.Duration, concurrency int,
+	membership *shard.Membership, container *ClustersContainer, handle func(monitor.ClusterInfo)) {
+	previous := map[string]bool{}
+	for cycle := 1; ; cycle++ {
+		owned := []*monitor.Monitor{}
+		current := map[string]bool{}
+		for _, m := range monitors {
+			if membership.Owns(m.Name()) {
+				owned = append(owned, m)
+				current[m.Name()] = true
+			}
+		}
+		gained, lost := 0, 0
+		for name := range current {
+			if !previous[name] {
+				gained++
+			}
+		}
+		for name := range previous {
+			if !current[name] {
+				lost++
+			}
+		}
+		if cycle == 1 || gained > 0 || lost > 0 {
+			slog.Info("Shard assigned", "clusters", len(owned), "configured", len(monitors), "gained", gained,
+				"lost", lost)
+		}
+		previous = current
+		container.Assign(monitorNames(owned))
+		slog.Debug("Scrape cycle started", "cycle", cycle, "clusters", len(owned))
+		scrapeOnce(owned, concurrency, handle)
+		time.Sleep(interval)
+	}
+}
+
+// federate merges the clusters of the instance with those of its peers answering the same query. A
+// cluster listed by two instances while they rebalance is taken from the latest check
+func federate(membership *shard.Membership, query url.Values, local []ClusterEntry) federationResponse {
+	peers := membership.Peers()
+	results := make([][]ClusterEntry, len(peers))
+	errs := make([]error, len(peers))
+	var queries sync.WaitGroup
+	for i, peer := range peers {
+		queries.Add(1)
+		go func(i int, peer string) {
+			defer queries.Done()
+			errs[i] = membership.Get(peer, "/clusters", query, &results[i])
+		}(i, peer)
+	}
+	queries.Wait()
+	response := federationResponse{
+		Instances: []federatedInstance{{ID: membership.Status().ID, Self: true, Clusters: len(local)}},
+		Clusters:  []ClusterEntry{},
+	}
+	byName := map[string]ClusterEntry{}
+	merge := func(entries []ClusterEntry) {
+		for _, entry := range entries {
+			known, found := byName[entry.Status.Name]
+			if !found || (entry.Status.LastCheck != nil &&
+				(known.Status.LastCheck == nil || entry.Status.LastCheck.After(*known.Status.LastCheck))) {
+				byName[entry.Status.Name] = entry
+			}
+		}
+	}
+	merge(local)
+	for i, peer := range peers {
+		instance := federatedInstance{ID: peer, Clusters: len(results[i])}
+		if errs[i] != nil {
+			instance.Error = errs[i].Error()
+		}
+		response.Instances = append(response.Instances, instance)
+		merge(results[i])
+	}
+	for _, entry := range byName {
+		response.Clusters = append(response.Clusters, entry)
+	}
+	sort.Slice(response.Clusters, func(i, k int) bool {
+		return response.Clusters[i].Status.Name < response.Clusters[k].Status.Name
+	})
+	return response
+}
+
+// saveState persists the alert history and the events
+func saveState(tracker *lifecycle.Tracker, journal *events.Journal) {
+	if err := tracker.Save(); err != nil {
+		slog.Error("Cannot save alert history", "error", err)
+	}
+	if err := journal.Save(); err != nil {
+		slog.Error("Cannot save events", "error", err)
+	}
+}
+
+// stopOnSignal delivers the results queued for the sinks on SIGINT or SIGTERM, then closes stop and exits
+// once the running goroutines are done, so that the outputs are flushed and the state is persisted before
+// leaving
+func stopOnSignal(stop chan struct{}, sinks *sink.Registry, running *sync.WaitGroup) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	received := <-signals
+	slog.Info("Shutting down", "signal", received.String())
+	sinks.Close()
+	close(stop)
+	running.Wait()
+	os.Exit(0)
+}
+
+// serve listens on the configured address and serves the API until it fails
+func serve(handler http.Handler, server config.Server) error {
+	var tlsConfig *tls.Config
+	if server.TLS.Enabled() {
+		certificate, err := tls.LoadX509KeyPair(server.TLS.CertFile, server.TLS.KeyFile)
+		if err != nil {
+			return fmt.Errorf("cannot load TLS certificate: %s", err)
+		}
+		tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{certificate},
+			MinVersion:   tls.VersionTLS12,
+		}
+	}
+	listener, err := net.Listen("tcp", server.Listen)
+	if err != nil {
+		return fmt.Errorf("cannot listen on %s: %s", server.Listen, err)
+	}
+	protocol := "http"
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+		protocol = "https"
+	}
+	slog.Info("Serving API", "url", fmt.Sprintf("%s://%s", protocol, listener.Addr()), "authentication",
+		server.Auth.Enabled())
+	return http.Serve(listener, handler)
+}
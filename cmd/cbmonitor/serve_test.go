@@ -0,0 +1,99 @@
+package main
+
+import (
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/monitor"
+	"cbmonitor/internal/shard"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// newTestEntry creates the entry of a cluster checked at the given time, reason tells the copies apart
+func newTestEntry(name, reason string, checked *time.Time) ClusterEntry {
+	entry := ClusterEntry{Status: monitor.NewClusterStatus(name)}
+	entry.Name = name
+	entry.Status.Reason = reason
+	entry.Status.LastCheck = checked
+	return entry
+}
+
+// newFederatedPeer starts a peer answering the probes and the queries of its clusters
+func newFederatedPeer(t *testing.T, entries []ClusterEntry) *httptest.Server {
+	t.Helper()
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case shard.Path:
+			json.NewEncoder(w).Encode(shard.Status{Mode: shard.ModePeers, ID: "http://" + r.Host})
+		case "/clusters":
+			if r.URL.Query().Get("state") != "healthy" {
+				t.Errorf("peer queried with %q, want the query of the federation", r.URL.RawQuery)
+			}
+			json.NewEncoder(w).Encode(entries)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(peer.Close)
+	return peer
+}
+
+func TestFederate(t *testing.T) {
+	earlier := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	later := earlier.Add(time.Minute)
+	first := newFederatedPeer(t, []ClusterEntry{
+		newTestEntry("moved", "first", &later),
+		newTestEntry("unchecked", "first", nil),
+		newTestEntry("b", "first", &earlier),
+	})
+	second := newFederatedPeer(t, []ClusterEntry{
+		newTestEntry("moved", "second", &earlier),
+		newTestEntry("unchecked", "second", &earlier),
+	})
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == shard.Path {
+			json.NewEncoder(w).Encode(shard.Status{Mode: shard.ModePeers, ID: "http://" + r.Host})
+			return
+		}
+		http.Error(w, "failing", http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+	membership := shard.NewMembership(config.Sharding{
+		Self:    "http://self:3000",
+		Peers:   []string{first.URL, second.URL, broken.URL},
+		Timeout: config.Duration{Duration: time.Second},
+	})
+	membership.Probe(time.Now())
+	local := []ClusterEntry{newTestEntry("a", "self", &earlier), newTestEntry("moved", "self", nil)}
+	response := federate(membership, url.Values{"state": {"healthy"}}, local)
+
+	want := []struct{ name, reason string }{{"a", "self"}, {"b", "first"}, {"moved", "first"},
+		{"unchecked", "second"}}
+	if len(response.Clusters) != len(want) {
+		t.Fatalf("federate() merged %d clusters, want %d", len(response.Clusters), len(want))
+	}
+	for i, cluster := range want {
+		got := response.Clusters[i].Status
+		if got.Name != cluster.name || got.Reason != cluster.reason {
+			t.Errorf("cluster %d = %s from %s, want %s from %s", i, got.Name, got.Reason, cluster.name,
+				cluster.reason)
+		}
+	}
+
+	instances := map[string]federatedInstance{}
+	for _, instance := range response.Instances {
+		instances[instance.ID] = instance
+	}
+	if self := instances["http://self:3000"]; !self.Self || self.Clusters != 2 {
+		t.Errorf("self instance = %+v, want 2 clusters", self)
+	}
+	if peer := instances[first.URL]; peer.Clusters != 3 || peer.Error != "" {
+		t.Errorf("first peer = %+v, want 3 clusters", peer)
+	}
+	if peer := instances[broken.URL]; peer.Error == "" || peer.Clusters != 0 {
+		t.Errorf("broken peer = %+v, want an error", peer)
+	}
+}
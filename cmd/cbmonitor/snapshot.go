@@ -0,0 +1,138 @@
+package main
+
+import (
+	"cbmonitor/internal/advisor"
+	"cbmonitor/internal/anomaly"
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/diff"
+	"cbmonitor/internal/forecast"
+	"cbmonitor/internal/monitor"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// runSnapshot scrapes the clusters once and writes the same JSON as the API root
+func runSnapshot(args []string) {
+	flags := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	configFile := flags.String("config", "./config.json", "Configuration file path")
+	callsTimeout := flags.Duration("timeout", 3*time.Second, "Monitoring call timeout")
+	defaultPassword := flags.String("password", "", "Default password (if you don't want to set one in config file)")
+	output := flags.String("output", "-", "Snapshot file, - for the standard output")
+	labelSelector := flags.String("labels", "", "Only the clusters with these labels (env=prod,team=payments)")
+	logFlags := addLogFlags(flags)
+	flags.Parse(args)
+	logFlags.setup()
+	fileConfiguration, err := config.LoadFile(*configFile)
+	exitOnError("Cannot read configuration", err)
+	configuration, err := selectClusters(fileConfiguration.Clusters, *labelSelector)
+	exitOnError("Invalid labels", err)
+	forecaster := forecast.NewForecaster(fileConfiguration.Forecast)
+	detector, err := anomaly.NewDetector(fileConfiguration.Anomaly, nil)
+	exitOnError("Cannot create anomaly detector", err)
+	clusterAdvisor, err := advisor.NewAdvisor(fileConfiguration.Advisor)
+	exitOnError("Cannot create advisor", err)
+	monitors := buildMonitors(configuration, *callsTimeout, *defaultPassword)
+	fullClusterStats := NewClustersContainer(monitors)
+	scrapeOnce(monitors, defaultConcurrency, func(info monitor.ClusterInfo) {
+		fullClusterStats.Add(info)
+		if info.Err == nil || info.Partial() {
+			forecaster.Add(info.Name, info.Time, info.Stats)
+			detector.Add(info.Name, info.Time, info.Stats)
+			clusterAdvisor.Add(info.Name, info.Time, info.Stats)
+		}
+	})
+	entries := fullClusterStats.GetAll()
+	addDerivedAlerts(entries, forecaster, detector, clusterAdvisor, time.Now())
+	snapshot, err := json.MarshalIndent(entries, "", "  ")
+	exitOnError("Cannot encode snapshot", err)
+	snapshot = append(snapshot, '\n')
+	if *output == "-" {
+		os.Stdout.Write(snapshot)
+		return
+	}
+	exitOnError("Cannot write snapshot", ioutil.WriteFile(*output, snapshot, 0644))
+}
+
+func readSnapshot(filename string) ([]ClusterEntry, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var entries []ClusterEntry
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return nil, fmt.Errorf("%s is not a snapshot: %s", filename, err)
+	}
+	return entries, nil
+}
+
+// hasStats tells whether the entry carries statistics (clusters that were never scraped do not)
+func hasStats(entry ClusterEntry) bool {
+	return entry.Status.State != monitor.StateDown || entry.Status.Stale
+}
+
+// runDiff reports the changes between two snapshots, it exits with 1 when they differ
+func runDiff(args []string) {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	jsonOutput := flags.Bool("json", false, "Print the changes as JSON")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s diff [flags] <before.json> <after.json>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	before, err := readSnapshot(flags.Arg(0))
+	exitOnError("Cannot read snapshot", err)
+	after, err := readSnapshot(flags.Arg(1))
+	exitOnError("Cannot read snapshot", err)
+
+	changes := []diff.Change{}
+	beforeEntries := make(map[string]ClusterEntry, len(before))
+	for _, entry := range before {
+		beforeEntries[entry.Status.Name] = entry
+	}
+	afterNames := make(map[string]bool, len(after))
+	for _, entry := range after {
+		afterNames[entry.Status.Name] = true
+		previous, ok := beforeEntries[entry.Status.Name]
+		if !ok {
+			changes = append(changes, diff.Change{Cluster: entry.Status.Name, Kind: diff.KindAdded, Scope: diff.ScopeCluster})
+			continue
+		}
+		if previous.Status.State != entry.Status.State {
+			changes = append(changes, diff.Change{Cluster: entry.Status.Name, Kind: diff.KindChanged,
+				Scope: diff.ScopeCluster, Field: "state", Before: string(previous.Status.State),
+				After: string(entry.Status.State)})
+		}
+		if hasStats(previous) && hasStats(entry) {
+			changes = append(changes, diff.Clusters(entry.Status.Name, previous.ClusterStats, entry.ClusterStats)...)
+			// the buckets are missing from partial scrapes, they are only compared between complete ones
+			if previous.Status.LastError == "" && entry.Status.LastError == "" {
+				changes = append(changes, diff.Buckets(entry.Status.Name, previous.Buckets, entry.Buckets)...)
+			}
+		}
+	}
+	for _, entry := range before {
+		if !afterNames[entry.Status.Name] {
+			changes = append(changes, diff.Change{Cluster: entry.Status.Name, Kind: diff.KindRemoved, Scope: diff.ScopeCluster})
+		}
+	}
+
+	if *jsonOutput {
+		changesBytes, _ := json.MarshalIndent(changes, "", "  ")
+		fmt.Println(string(changesBytes))
+	} else {
+		for _, change := range changes {
+			fmt.Println(change)
+		}
+	}
+	if len(changes) > 0 {
+		os.Exit(1)
+	}
+}
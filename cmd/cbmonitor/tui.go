@@ -0,0 +1,52 @@
+package main
+
+import (
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/monitor"
+	"cbmonitor/internal/tui"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+// runTUI scrapes the configured clusters and renders them as a live terminal dashboard
+func runTUI(args []string) {
+	flags := flag.NewFlagSet("tui", flag.ExitOnError)
+	configFile := flags.String("config", "./config.json", "Configuration file path")
+	scrapInterval := flags.Duration("interval", 15*time.Second, "Monitoring interval")
+	callsTimeout := flags.Duration("timeout", 3*time.Second, "Monitoring call timeout")
+	defaultPassword := flags.String("password", "", "Default password (if you don't want to set one in config file)")
+	labelSelector := flags.String("labels", "", "Only the clusters with these labels (env=prod,team=payments)")
+	flags.Parse(args)
+	configuration, err := config.NewFileConfiguration(*configFile)
+	exitOnError("Cannot read configuration", err)
+	configuration, err = selectClusters(configuration, *labelSelector)
+	exitOnError("Invalid labels", err)
+	monitors := buildMonitors(configuration, *callsTimeout, *defaultPassword)
+	// the terminal belongs to the dashboard from now on
+	log.SetOutput(ioutil.Discard)
+	fullClusterStats := NewClustersContainer(monitors)
+	app := tui.NewApp()
+	app.Update(tuiClusters(fullClusterStats.GetAll()))
+	go scrapeLoop(monitors, *scrapInterval, defaultConcurrency, func(resp monitor.ClusterInfo) {
+		fullClusterStats.Add(resp)
+		app.Update(tuiClusters(fullClusterStats.GetAll()))
+	})
+	if err := app.Run(); err != nil {
+		log.SetOutput(os.Stderr)
+		exitOnError("Cannot start terminal UI", err)
+	}
+}
+
+func tuiClusters(entries []ClusterEntry) []tui.Cluster {
+	clusters := make([]tui.Cluster, len(entries))
+	for i, entry := range entries {
+		clusters[i] = tui.Cluster{
+			Status: entry.Status,
+			Stats:  entry.ClusterStats,
+		}
+	}
+	return clusters
+}
@@ -1,5 +1,5 @@
 module cbmonitor
 
-go 1.12
+go 1.24
 
 require github.com/go-chi/chi v4.0.2+incompatible
@@ -0,0 +1,273 @@
+package advisor
+
+import (
+	"cbmonitor/internal/alerts"
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/monitor/stats"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Check identifiers
+const (
+	CheckReplicas        = "replicas_exceed_kv_nodes"
+	CheckSwap            = "swap_in_use"
+	CheckMemoryQuota     = "memory_quota_exceeds_ram"
+	CheckSingleIndexNode = "single_index_node"
+	CheckMixedOS         = "mixed_os"
+	CheckInactiveNodes   = "inactive_nodes"
+	activeMembership     = "active"
+	quotaServiceData     = "kv"
+	quotaServiceIndex    = "index"
+	quotaServiceFullText = "fts"
+)
+
+// Check known misconfiguration, run reports a finding for every occurrence
+type Check struct {
+	ID          string `json:"id"`
+	Explanation string `json:"explanation"`
+	run         func(clusterStats stats.ClusterStats, settings config.Advisor) []Finding
+}
+
+// Finding occurrence of a misconfiguration in a cluster
+type Finding struct {
+	Check       string `json:"check"`
+	Message     string `json:"message"`
+	Explanation string `json:"explanation"`
+	Bucket      string `json:"bucket,omitempty"`
+	Node        string `json:"node,omitempty"`
+}
+
+var checks = []Check{
+	{
+		ID: CheckReplicas,
+		Explanation: "Replicas are placed on other data nodes than the active copy, a bucket needs more data " +
+			"nodes than replicas or some replicas are never created",
+		run: replicasExceedKVNodes,
+	},
+	{
+		ID: CheckSwap,
+		Explanation: "Couchbase expects its working set in memory, swapping nodes respond slowly and may be " +
+			"failed over; lower the swappiness or the quotas",
+		run: swapInUse,
+	},
+	{
+		ID: CheckMemoryQuota,
+		Explanation: "The quotas of the services running on a node should leave room for the operating system " +
+			"and the other processes, otherwise the node may run out of memory",
+		run: memoryQuotaExceedsRAM,
+	},
+	{
+		ID: CheckSingleIndexNode,
+		Explanation: "With a single index node the indexes cannot have replicas, losing the node stops every " +
+			"query relying on them",
+		run: singleIndexNode,
+	},
+	{
+		ID: CheckMixedOS,
+		Explanation: "Nodes of a cluster should run the same operating system, mixed clusters are only " +
+			"supported while migrating",
+		run: mixedOS,
+	},
+	{
+		ID: CheckInactiveNodes,
+		Explanation: "Nodes that are not active (failed over, added but not rebalanced...) do not serve " +
+			"traffic, the cluster needs a rebalance",
+		run: inactiveNodes,
+	},
+}
+
+// Checks returns every known check
+func Checks() []Check {
+	return append([]Check{}, checks...)
+}
+
+// Advisor runs the enabled checks on the statistics of every scrape and keeps the latest findings
+type Advisor struct {
+	config   config.Advisor
+	checks   []Check
+	findings map[string][]Finding
+	mu       sync.RWMutex
+}
+
+// NewAdvisor creates an advisor for the given settings, disabling an unknown check is an error
+func NewAdvisor(settings config.Advisor) (*Advisor, error) {
+	if settings.MemoryQuotaRatio == 0 {
+		settings.MemoryQuotaRatio = config.DefaultMemoryQuotaRatio
+	}
+	disabled := make(map[string]bool, len(settings.Disabled))
+	for _, id := range settings.Disabled {
+		known := false
+		for _, check := range checks {
+			known = known || check.ID == id
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown advisor check %q", id)
+		}
+		disabled[id] = true
+	}
+	a := &Advisor{config: settings, findings: map[string][]Finding{}}
+	for _, check := range checks {
+		if !disabled[check.ID] {
+			a.checks = append(a.checks, check)
+		}
+	}
+	return a, nil
+}
+
+// Review runs the enabled checks on the statistics of a cluster
+func (a *Advisor) Review(clusterStats stats.ClusterStats) []Finding {
+	findings := []Finding{}
+	for _, check := range a.checks {
+		for _, finding := range check.run(clusterStats, a.config) {
+			finding.Check = check.ID
+			finding.Explanation = check.Explanation
+			findings = append(findings, finding)
+		}
+	}
+	return findings
+}
+
+// Add reviews the statistics of a cluster, replacing its previous findings
+func (a *Advisor) Add(cluster string, collected time.Time, clusterStats stats.ClusterStats) {
+	findings := a.Review(clusterStats)
+	a.mu.Lock()
+	a.findings[cluster] = findings
+	a.mu.Unlock()
+}
+
+// Forget drops the findings of the clusters, when they are not monitored by this instance anymore
+func (a *Advisor) Forget(clusters []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, cluster := range clusters {
+		delete(a.findings, cluster)
+	}
+}
+
+// Findings returns the findings of the latest statistics of a cluster
+func (a *Advisor) Findings(cluster string) []Finding {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return append([]Finding{}, a.findings[cluster]...)
+}
+
+// Alerts returns the warnings raised by the findings of a cluster, named after their check
+func (a *Advisor) Alerts(cluster string) []alerts.Alert {
+	findings := a.Findings(cluster)
+	raised := make([]alerts.Alert, len(findings))
+	for i, finding := range findings {
+		raised[i] = alerts.Alert{
+			Cluster:  cluster,
+			Type:     alerts.TypeCalculated,
+			Name:     finding.Check,
+			Severity: alerts.SeverityWarning,
+			Message:  finding.Message,
+			Bucket:   finding.Bucket,
+			Node:     finding.Node,
+		}
+	}
+	return raised
+}
+
+func replicasExceedKVNodes(clusterStats stats.ClusterStats, settings config.Advisor) []Finding {
+	findings := []Finding{}
+	kvNodes := clusterStats.AvailableServices.KV
+	if kvNodes == 0 {
+		return findings
+	}
+	for _, bucket := range clusterStats.Buckets {
+		if bucket.ReplicaNumber > 0 && bucket.ReplicaNumber >= kvNodes {
+			findings = append(findings, Finding{
+				Message: fmt.Sprintf("Bucket %s has %d replicas but the cluster only has %d data nodes", bucket.Name,
+					bucket.ReplicaNumber, kvNodes),
+				Bucket: bucket.Name,
+			})
+		}
+	}
+	return findings
+}
+
+func swapInUse(clusterStats stats.ClusterStats, settings config.Advisor) []Finding {
+	findings := []Finding{}
+	for _, node := range clusterStats.Nodes {
+		if node.SwapUsedMb > 0 {
+			findings = append(findings, Finding{
+				Message: fmt.Sprintf("Node %s uses %d MB of swap", node.Hostname, node.SwapUsedMb),
+				Node:    node.Hostname,
+			})
+		}
+	}
+	return findings
+}
+
+// nodeQuotaMb returns the sum of the quotas of the services running on the node
+func nodeQuotaMb(clusterStats stats.ClusterStats, node stats.Node) int64 {
+	var quota int64
+	for _, service := range node.Services {
+		switch service {
+		case quotaServiceData:
+			quota += clusterStats.MemoryQuotaMb
+		case quotaServiceIndex:
+			quota += clusterStats.IndexMemoryQuotaMb
+		case quotaServiceFullText:
+			quota += clusterStats.FTSMemoryQuotaMb
+		}
+	}
+	return quota
+}
+
+func memoryQuotaExceedsRAM(clusterStats stats.ClusterStats, settings config.Advisor) []Finding {
+	findings := []Finding{}
+	for _, node := range clusterStats.Nodes {
+		quota := nodeQuotaMb(clusterStats, node)
+		limit := int64(float64(node.MemTotalMb) * settings.MemoryQuotaRatio)
+		if node.MemTotalMb > 0 && quota > limit {
+			findings = append(findings, Finding{
+				Message: fmt.Sprintf("Node %s service quotas (%d MB) exceed %g%% of its %d MB of RAM", node.Hostname,
+					quota, settings.MemoryQuotaRatio*100, node.MemTotalMb),
+				Node: node.Hostname,
+			})
+		}
+	}
+	return findings
+}
+
+func singleIndexNode(clusterStats stats.ClusterStats, settings config.Advisor) []Finding {
+	if clusterStats.AvailableServices.Index != 1 {
+		return []Finding{}
+	}
+	return []Finding{{Message: "The cluster has a single index node"}}
+}
+
+func mixedOS(clusterStats stats.ClusterStats, settings config.Advisor) []Finding {
+	unique := map[string]bool{}
+	for _, node := range clusterStats.Nodes {
+		unique[node.OS] = true
+	}
+	if len(unique) < 2 {
+		return []Finding{}
+	}
+	systems := make([]string, 0, len(unique))
+	for os := range unique {
+		systems = append(systems, os)
+	}
+	sort.Strings(systems)
+	return []Finding{{Message: fmt.Sprintf("Nodes run different operating systems: %s", strings.Join(systems, ", "))}}
+}
+
+func inactiveNodes(clusterStats stats.ClusterStats, settings config.Advisor) []Finding {
+	findings := []Finding{}
+	for _, node := range clusterStats.Nodes {
+		if node.ClusterMembership != activeMembership {
+			findings = append(findings, Finding{
+				Message: fmt.Sprintf("Node %s cluster membership is %s", node.Hostname, node.ClusterMembership),
+				Node:    node.Hostname,
+			})
+		}
+	}
+	return findings
+}
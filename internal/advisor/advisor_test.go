@@ -0,0 +1,157 @@
+package advisor
+
+import (
+	"cbmonitor/internal/alerts"
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/monitor/stats"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+// healthyStats returns the statistics of a well configured cluster, no check finds anything
+func healthyStats() stats.ClusterStats {
+	clusterStats := stats.ClusterStats{MemoryQuotaMb: 4096, IndexMemoryQuotaMb: 1024, FTSMemoryQuotaMb: 2048}
+	clusterStats.AvailableServices.KV = 2
+	clusterStats.AvailableServices.Index = 2
+	clusterStats.Nodes = []stats.Node{
+		{Hostname: "node1", Services: []string{"kv", "index"}, MemTotalMb: 8192, ClusterMembership: "active", OS: "linux"},
+		{Hostname: "node2", Services: []string{"kv", "index"}, MemTotalMb: 8192, ClusterMembership: "active", OS: "linux"},
+	}
+	clusterStats.Buckets = []stats.Bucket{{Name: "orders", ReplicaNumber: 1}}
+	return clusterStats
+}
+
+func newTestAdvisor(t *testing.T, settings config.Advisor) *Advisor {
+	t.Helper()
+	a, err := NewAdvisor(settings)
+	if err != nil {
+		t.Fatalf("NewAdvisor() = %v", err)
+	}
+	return a
+}
+
+func TestReview(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*stats.ClusterStats)
+		want   []Finding
+	}{
+		{name: "healthy", change: func(*stats.ClusterStats) {}, want: []Finding{}},
+		{name: "replicas", change: func(s *stats.ClusterStats) { s.Buckets[0].ReplicaNumber = 2 },
+			want: []Finding{{Check: CheckReplicas, Bucket: "orders",
+				Message: "Bucket orders has 2 replicas but the cluster only has 2 data nodes"}}},
+		{name: "no data node", change: func(s *stats.ClusterStats) {
+			s.AvailableServices.KV = 0
+			s.Buckets[0].ReplicaNumber = 2
+		}, want: []Finding{}},
+		{name: "swap", change: func(s *stats.ClusterStats) { s.Nodes[1].SwapUsedMb = 300 },
+			want: []Finding{{Check: CheckSwap, Node: "node2", Message: "Node node2 uses 300 MB of swap"}}},
+		{name: "memory quota", change: func(s *stats.ClusterStats) { s.Nodes[0].Services = append(s.Nodes[0].Services, "fts") },
+			want: []Finding{{Check: CheckMemoryQuota, Node: "node1",
+				Message: "Node node1 service quotas (7168 MB) exceed 80% of its 8192 MB of RAM"}}},
+		{name: "unknown RAM", change: func(s *stats.ClusterStats) {
+			s.Nodes[0].Services = append(s.Nodes[0].Services, "fts")
+			s.Nodes[0].MemTotalMb = 0
+		}, want: []Finding{}},
+		{name: "single index node", change: func(s *stats.ClusterStats) { s.AvailableServices.Index = 1 },
+			want: []Finding{{Check: CheckSingleIndexNode, Message: "The cluster has a single index node"}}},
+		{name: "mixed OS", change: func(s *stats.ClusterStats) { s.Nodes[1].OS = "windows" },
+			want: []Finding{{Check: CheckMixedOS, Message: "Nodes run different operating systems: linux, windows"}}},
+		{name: "inactive node", change: func(s *stats.ClusterStats) { s.Nodes[0].ClusterMembership = "inactiveFailed" },
+			want: []Finding{{Check: CheckInactiveNodes, Node: "node1",
+				Message: "Node node1 cluster membership is inactiveFailed"}}},
+	}
+	a := newTestAdvisor(t, config.Advisor{})
+	for _, test := range tests {
+		clusterStats := healthyStats()
+		test.change(&clusterStats)
+		findings := a.Review(clusterStats)
+		for i := range findings {
+			if findings[i].Explanation == "" {
+				t.Errorf("%s: finding %d has no explanation", test.name, i)
+			}
+			findings[i].Explanation = ""
+		}
+		if !reflect.DeepEqual(findings, test.want) {
+			t.Errorf("%s: Review() = %+v, want %+v", test.name, findings, test.want)
+		}
+	}
+}
+
+func TestNewAdvisor(t *testing.T) {
+	clusterStats := healthyStats()
+	clusterStats.Nodes[1].SwapUsedMb = 300
+	clusterStats.Nodes[1].OS = "windows"
+	clusterStats.Nodes[0].MemTotalMb = 6000
+
+	tests := []struct {
+		name     string
+		settings config.Advisor
+		checks   []string
+		err      bool
+	}{
+		{name: "defaults", checks: []string{CheckSwap, CheckMemoryQuota, CheckMixedOS}},
+		{name: "disabled", settings: config.Advisor{Disabled: []string{CheckSwap, CheckMixedOS}},
+			checks: []string{CheckMemoryQuota}},
+		{name: "ratio", settings: config.Advisor{MemoryQuotaRatio: 0.9}, checks: []string{CheckSwap, CheckMixedOS}},
+		{name: "unknown check", settings: config.Advisor{Disabled: []string{"swap"}}, err: true},
+	}
+	for _, test := range tests {
+		a, err := NewAdvisor(test.settings)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: NewAdvisor() succeeded, want an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: NewAdvisor() = %v", test.name, err)
+		}
+		checks := []string{}
+		for _, finding := range a.Review(clusterStats) {
+			checks = append(checks, finding.Check)
+		}
+		if !reflect.DeepEqual(checks, test.checks) {
+			t.Errorf("%s: Review() found %v, want %v", test.name, checks, test.checks)
+		}
+	}
+	if len(Checks()) != 6 {
+		t.Errorf("Checks() = %d checks, want 6", len(Checks()))
+	}
+}
+
+func TestAlerts(t *testing.T) {
+	a := newTestAdvisor(t, config.Advisor{})
+	clusterStats := healthyStats()
+	clusterStats.Nodes[1].SwapUsedMb = 300
+	a.Add("east", start, clusterStats)
+	want := []alerts.Alert{{Cluster: "east", Type: alerts.TypeCalculated, Name: CheckSwap,
+		Severity: alerts.SeverityWarning, Message: "Node node2 uses 300 MB of swap", Node: "node2"}}
+	if raised := a.Alerts("east"); !reflect.DeepEqual(raised, want) {
+		t.Errorf("Alerts() = %+v, want %+v", raised, want)
+	}
+
+	// the findings of the latest statistics replace the previous ones
+	a.Add("east", start.Add(time.Minute), healthyStats())
+	if raised := a.Alerts("east"); len(raised) != 0 {
+		t.Errorf("Alerts() = %+v after the swap was freed, want none", raised)
+	}
+}
+
+func TestForget(t *testing.T) {
+	a := newTestAdvisor(t, config.Advisor{})
+	clusterStats := healthyStats()
+	clusterStats.AvailableServices.Index = 1
+	a.Add("east", start, clusterStats)
+	a.Add("west", start, clusterStats)
+	a.Forget([]string{"east"})
+	if findings := a.Findings("east"); len(findings) != 0 {
+		t.Errorf("Findings(east) = %+v after Forget, want none", findings)
+	}
+	if findings := a.Findings("west"); len(findings) != 1 {
+		t.Errorf("Findings(west) = %+v, want the single index node", findings)
+	}
+}
@@ -0,0 +1,195 @@
+package alerts
+
+import (
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/monitor/stats"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Severity of an alert, values match the Nagios plugin exit codes
+type Severity int
+
+const (
+	SeverityOK Severity = iota
+	SeverityWarning
+	SeverityCritical
+	SeverityUnknown
+)
+
+var severityNames = []string{"ok", "warning", "critical", "unknown"}
+
+func (s Severity) String() string {
+	if s < SeverityOK || s > SeverityUnknown {
+		return severityNames[SeverityUnknown]
+	}
+	return severityNames[s]
+}
+
+// severityRanks orders the severities from the least to the most severe, unlike their exit codes a
+// critical alert outranks an unknown one
+var severityRanks = map[Severity]int{SeverityOK: 0, SeverityUnknown: 1, SeverityWarning: 2, SeverityCritical: 3}
+
+// Outranks tells whether the severity is more severe than the other one
+func (s Severity) Outranks(other Severity) bool {
+	return severityRanks[s] > severityRanks[other]
+}
+
+// MarshalText encodes the severity by its name
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a severity name
+func (s *Severity) UnmarshalText(text []byte) error {
+	for i, name := range severityNames {
+		if strings.EqualFold(name, string(text)) {
+			*s = Severity(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid severity %q", text)
+}
+
+const (
+	// TypeCalculated alerts computed by the stats package
+	TypeCalculated = "calculated"
+	// TypeRule alerts raised by the configured rules
+	TypeRule = "rule"
+	// TypeScrape alerts raised when a cluster cannot be (fully) scraped
+	TypeScrape = "scrape"
+	// TypeEvent alerts announcing an event detected between scrapes, notified once
+	TypeEvent = "event"
+)
+
+// Alert condition detected on a cluster
+type Alert struct {
+	Cluster  string   `json:"cluster"`
+	Type     string   `json:"type"`
+	Name     string   `json:"name"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+	Bucket   string   `json:"bucket,omitempty"`
+	Node     string   `json:"node,omitempty"`
+	Metric   string   `json:"metric,omitempty"`
+	Value    float64  `json:"value,omitempty"`
+	// Below set when the alert is raised by low values, its value gets worse as it decreases
+	Below bool `json:"below,omitempty"`
+	// Labels configured labels of the cluster, set when the alert is routed
+	Labels map[string]string `json:"labels,omitempty"`
+	// Acknowledged set when the alert was acknowledged through the API, it is not notified again until it
+	// resolves
+	Acknowledged bool `json:"acknowledged,omitempty"`
+}
+
+// Fingerprint identifies an alert across evaluations, its severity, message and value may change. The
+// calculated alerts of the statistics are only told apart by their message
+func Fingerprint(alert Alert) string {
+	parts := []string{alert.Cluster, alert.Type, alert.Name, alert.Bucket, alert.Node, alert.Metric}
+	if alert.Name == TypeCalculated {
+		parts = append(parts, alert.Message)
+	}
+	return strings.Join(parts, "\xff")
+}
+
+// MaxSeverity returns the highest severity of the given alerts, critical first, then warning and unknown
+func MaxSeverity(alerts []Alert) Severity {
+	max := SeverityOK
+	for _, alert := range alerts {
+		if alert.Severity.Outranks(max) {
+			max = alert.Severity
+		}
+	}
+	return max
+}
+
+// Calculated converts the calculated alerts of the cluster statistics into warnings
+func Calculated(cluster string, clusterStats stats.ClusterStats) []Alert {
+	alerts := make([]Alert, len(clusterStats.Alerts.Calculated))
+	for i, message := range clusterStats.Alerts.Calculated {
+		alerts[i] = Alert{
+			Cluster:  cluster,
+			Type:     TypeCalculated,
+			Name:     TypeCalculated,
+			Severity: SeverityWarning,
+			Message:  message,
+		}
+	}
+	return alerts
+}
+
+// Evaluate returns the alerts raised by the rules on the cluster statistics
+func Evaluate(cluster string, clusterStats stats.ClusterStats, rules []config.Rule) []Alert {
+	values := clusterStats.AllMetrics()
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	alerts := []Alert{}
+	for _, rule := range rules {
+		for _, name := range names {
+			if matched, _ := path.Match(rule.Metric, name); !matched {
+				continue
+			}
+			severity, threshold := evaluateRule(rule, values[name])
+			if severity == SeverityOK {
+				continue
+			}
+			comparison := ">="
+			if rule.Below {
+				comparison = "<="
+			}
+			alert := Alert{
+				Cluster:  cluster,
+				Type:     TypeRule,
+				Name:     rule.Name,
+				Severity: severity,
+				Message:  fmt.Sprintf("%s: %s %g %s %g", rule.Name, name, values[name], comparison, threshold),
+				Metric:   name,
+				Value:    values[name],
+				Below:    rule.Below,
+			}
+			alert.Bucket, alert.Node = metricOwner(name)
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+func crosses(rule config.Rule, value float64, threshold *float64) bool {
+	if threshold == nil {
+		return false
+	}
+	if rule.Below {
+		return value <= *threshold
+	}
+	return value >= *threshold
+}
+
+func evaluateRule(rule config.Rule, value float64) (Severity, float64) {
+	if crosses(rule, value, rule.Critical) {
+		return SeverityCritical, *rule.Critical
+	}
+	if crosses(rule, value, rule.Warning) {
+		return SeverityWarning, *rule.Warning
+	}
+	return SeverityOK, 0
+}
+
+// metricOwner extracts the bucket or node of a metric named as in stats.ClusterStats.AllMetrics
+func metricOwner(metric string) (bucket, node string) {
+	parts := strings.SplitN(metric, "/", 3)
+	if len(parts) != 3 {
+		return "", ""
+	}
+	switch parts[0] {
+	case "buckets":
+		return parts[1], ""
+	case "nodes":
+		return "", parts[1]
+	}
+	return "", ""
+}
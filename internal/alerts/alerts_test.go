@@ -0,0 +1,157 @@
+package alerts
+
+import (
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/monitor/stats"
+	"encoding/json"
+	"testing"
+)
+
+func threshold(value float64) *float64 {
+	return &value
+}
+
+// testStats returns statistics of a cluster with a bucket and a node
+func testStats() stats.ClusterStats {
+	clusterStats := stats.ClusterStats{Name: "east", RAMPctUsed: 92, HdPctUsed: 40, GetHitRatio: 0.4}
+	clusterStats.Buckets = []stats.Bucket{{Name: "orders", QuotaPctUsed: 85}}
+	clusterStats.Nodes = []stats.Node{{Hostname: "10.0.0.1:8091", MemUsedPct: 97}}
+	return clusterStats
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     config.Rule
+		metric   string
+		severity Severity
+		message  string
+		bucket   string
+		node     string
+	}{
+		{name: "critical", rule: config.Rule{Name: "ram", Metric: "ram_pct_used", Warning: threshold(80),
+			Critical: threshold(90)}, metric: "ram_pct_used", severity: SeverityCritical,
+			message: "ram: ram_pct_used 92 >= 90"},
+		{name: "warning", rule: config.Rule{Name: "ram", Metric: "ram_pct_used", Warning: threshold(80),
+			Critical: threshold(95)}, metric: "ram_pct_used", severity: SeverityWarning,
+			message: "ram: ram_pct_used 92 >= 80"},
+		{name: "below", rule: config.Rule{Name: "hits", Metric: "get_hit_ratio", Warning: threshold(0.5),
+			Below: true}, metric: "get_hit_ratio", severity: SeverityWarning,
+			message: "hits: get_hit_ratio 0.4 <= 0.5"},
+		{name: "bucket", rule: config.Rule{Name: "quota", Metric: "buckets/*/quota_pct_used",
+			Critical: threshold(80)}, metric: "buckets/orders/quota_pct_used", severity: SeverityCritical,
+			message: "quota: buckets/orders/quota_pct_used 85 >= 80", bucket: "orders"},
+		{name: "node", rule: config.Rule{Name: "memory", Metric: "nodes/*/mem_pct_used",
+			Warning: threshold(90)}, metric: "nodes/10.0.0.1:8091/mem_pct_used", severity: SeverityWarning,
+			message: "memory: nodes/10.0.0.1:8091/mem_pct_used 97 >= 90", node: "10.0.0.1:8091"},
+		{name: "not crossed", rule: config.Rule{Name: "disk", Metric: "hd_pct_used", Warning: threshold(80)}},
+		{name: "no threshold", rule: config.Rule{Name: "disk", Metric: "hd_pct_used"}},
+	}
+	for _, test := range tests {
+		raised := Evaluate("east", testStats(), []config.Rule{test.rule})
+		if test.severity == SeverityOK {
+			if len(raised) > 0 {
+				t.Errorf("%s: Evaluate() = %+v, want no alert", test.name, raised)
+			}
+			continue
+		}
+		if len(raised) != 1 {
+			t.Fatalf("%s: Evaluate() = %+v, want one alert", test.name, raised)
+		}
+		alert := raised[0]
+		if alert.Metric != test.metric || alert.Severity != test.severity || alert.Message != test.message ||
+			alert.Bucket != test.bucket || alert.Node != test.node || alert.Below != test.rule.Below ||
+			alert.Cluster != "east" || alert.Type != TypeRule || alert.Name != test.rule.Name {
+			t.Errorf("%s: Evaluate() = %+v", test.name, alert)
+		}
+	}
+}
+
+func TestCalculated(t *testing.T) {
+	clusterStats := testStats()
+	clusterStats.Alerts.Calculated = []string{"node 10.0.0.1 is swapping"}
+	raised := Calculated("east", clusterStats)
+	if len(raised) != 1 || raised[0].Severity != SeverityWarning || raised[0].Type != TypeCalculated ||
+		raised[0].Message != "node 10.0.0.1 is swapping" {
+		t.Errorf("Calculated() = %+v", raised)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	base := Alert{Cluster: "east", Type: TypeRule, Name: "ram", Severity: SeverityWarning, Metric: "ram_pct_used",
+		Message: "ram: ram_pct_used 85 >= 80", Value: 85}
+	calculated := Alert{Cluster: "east", Type: TypeCalculated, Name: TypeCalculated, Severity: SeverityWarning,
+		Message: "node 10.0.0.1 is swapping"}
+	tests := []struct {
+		name   string
+		alert  Alert
+		change func(*Alert)
+		same   bool
+	}{
+		{name: "severity", alert: base, change: func(a *Alert) { a.Severity = SeverityCritical }, same: true},
+		{name: "value and message", alert: base, change: func(a *Alert) {
+			a.Value = 95
+			a.Message = "ram: ram_pct_used 95 >= 90"
+		}, same: true},
+		{name: "acknowledged", alert: base, change: func(a *Alert) { a.Acknowledged = true }, same: true},
+		{name: "cluster", alert: base, change: func(a *Alert) { a.Cluster = "west" }},
+		{name: "metric", alert: base, change: func(a *Alert) { a.Metric = "hd_pct_used" }},
+		{name: "bucket", alert: base, change: func(a *Alert) { a.Bucket = "orders" }},
+		{name: "calculated message", alert: calculated, change: func(a *Alert) {
+			a.Message = "node 10.0.0.2 is swapping"
+		}},
+	}
+	for _, test := range tests {
+		changed := test.alert
+		test.change(&changed)
+		if same := Fingerprint(test.alert) == Fingerprint(changed); same != test.same {
+			t.Errorf("%s: same fingerprint = %v, want %v", test.name, same, test.same)
+		}
+	}
+}
+
+func TestMaxSeverity(t *testing.T) {
+	tests := []struct {
+		severities []Severity
+		want       Severity
+	}{
+		{severities: nil, want: SeverityOK},
+		{severities: []Severity{SeverityWarning}, want: SeverityWarning},
+		{severities: []Severity{SeverityUnknown, SeverityWarning}, want: SeverityWarning},
+		{severities: []Severity{SeverityCritical, SeverityUnknown}, want: SeverityCritical},
+		{severities: []Severity{SeverityUnknown, SeverityCritical}, want: SeverityCritical},
+		{severities: []Severity{SeverityOK, SeverityUnknown}, want: SeverityUnknown},
+	}
+	for _, test := range tests {
+		raised := make([]Alert, len(test.severities))
+		for i, severity := range test.severities {
+			raised[i].Severity = severity
+		}
+		if got := MaxSeverity(raised); got != test.want {
+			t.Errorf("MaxSeverity(%v) = %s, want %s", test.severities, got, test.want)
+		}
+	}
+}
+
+func TestSeverityText(t *testing.T) {
+	for _, severity := range []Severity{SeverityOK, SeverityWarning, SeverityCritical, SeverityUnknown} {
+		encoded, err := json.Marshal(severity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded Severity
+		if err := json.Unmarshal(encoded, &decoded); err != nil || decoded != severity {
+			t.Errorf("%s decoded from %s as %s (%v)", severity, encoded, decoded, err)
+		}
+	}
+	var severity Severity
+	if err := severity.UnmarshalText([]byte("CRITICAL")); err != nil || severity != SeverityCritical {
+		t.Errorf("UnmarshalText(CRITICAL) = %s, %v", severity, err)
+	}
+	if err := severity.UnmarshalText([]byte("fatal")); err == nil {
+		t.Errorf("UnmarshalText(fatal) accepted an unknown severity")
+	}
+	if name := Severity(7).String(); name != "unknown" {
+		t.Errorf("Severity(7).String() = %q, want unknown", name)
+	}
+}
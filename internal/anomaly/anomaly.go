@@ -0,0 +1,292 @@
+package anomaly
+
+import (
+	"cbmonitor/internal/alerts"
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/monitor/stats"
+	"cbmonitor/internal/storage"
+	"fmt"
+	"log/slog"
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// AlertName name of the alerts raised by anomalies
+	AlertName = "anomaly"
+	// slots hours of the week a baseline is learned for
+	slots        = 7 * 24
+	documentName = "anomaly"
+	stateVersion = 1
+)
+
+// baseline running mean and variance (Welford) of the values seen in an hour of the week
+type baseline struct {
+	Count int64   `json:"n"`
+	Mean  float64 `json:"mean"`
+	M2    float64 `json:"m2"`
+}
+
+func (b *baseline) add(value float64) {
+	b.Count++
+	delta := value - b.Mean
+	b.Mean += delta / float64(b.Count)
+	b.M2 += delta * (value - b.Mean)
+}
+
+// stdDev sample standard deviation, floored to 1% of the mean so constant series do not flag tiny changes
+func (b baseline) stdDev() float64 {
+	deviation := 0.0
+	if b.Count > 1 {
+		deviation = math.Sqrt(b.M2 / float64(b.Count-1))
+	}
+	return math.Max(deviation, math.Max(math.Abs(b.Mean)*0.01, 1e-9))
+}
+
+// Anomaly value deviating from the baseline of its hour of the week
+type Anomaly struct {
+	Metric     string    `json:"metric"`
+	Bucket     string    `json:"bucket,omitempty"`
+	Node       string    `json:"node,omitempty"`
+	Value      float64   `json:"value"`
+	Mean       float64   `json:"mean"`
+	StdDev     float64   `json:"stdDev"`
+	Deviations float64   `json:"deviations"`
+	Samples    int64     `json:"samples"`
+	Weekday    string    `json:"weekday"`
+	Hour       int       `json:"hour"`
+	Time       time.Time `json:"time"`
+}
+
+// state persisted baselines, by cluster then metric
+type state struct {
+	Version   int                              `json:"version"`
+	Baselines map[string]map[string][]baseline `json:"baselines"`
+}
+
+// Detector learns seasonal baselines of the scraped metrics and flags the values deviating from them
+type Detector struct {
+	config    config.Anomaly
+	location  *time.Location
+	store     *storage.Store
+	baselines map[string]map[string][]baseline
+	anomalies map[string][]Anomaly
+	dirty     bool
+	mu        sync.Mutex
+}
+
+// NewDetector creates a detector for the given (normalized) settings, baselines saved in the store are
+// loaded back
+func NewDetector(settings config.Anomaly, store *storage.Store) (*Detector, error) {
+	location, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	d := &Detector{
+		config:    settings,
+		location:  location,
+		store:     store,
+		baselines: map[string]map[string][]baseline{},
+		anomalies: map[string][]Anomaly{},
+	}
+	var saved state
+	found, err := store.Load(documentName, &saved)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load anomaly baselines: %w", err)
+	}
+	if found && saved.Version == stateVersion && saved.Baselines != nil {
+		d.baselines = saved.Baselines
+		slog.Info("Anomaly baselines loaded", "file", store.Path(documentName), "clusters", len(saved.Baselines))
+	}
+	return d, nil
+}
+
+// tracked returns the metrics of the cluster matching the configured patterns
+func (d *Detector) tracked(clusterStats stats.ClusterStats) map[string]float64 {
+	values := map[string]float64{}
+	for metric, value := range clusterStats.AllMetrics() {
+		for _, pattern := range d.config.Metrics {
+			if matched, _ := path.Match(pattern, metric); matched {
+				values[metric] = value
+				break
+			}
+		}
+	}
+	return values
+}
+
+// Add compares the metrics of a cluster with the baselines of their hour of the week, then learns them
+func (d *Detector) Add(cluster string, collected time.Time, clusterStats stats.ClusterStats) {
+	local := collected.In(d.location)
+	slot := int(local.Weekday())*24 + local.Hour()
+	values := d.tracked(clusterStats)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	baselines, found := d.baselines[cluster]
+	if !found {
+		baselines = map[string][]baseline{}
+		d.baselines[cluster] = baselines
+	}
+	// the baselines of removed nodes and buckets are dropped, buckets are missing from partial scrapes and
+	// their baselines are only dropped once buckets are seen again
+	for metric := range baselines {
+		if _, found := values[metric]; found {
+			continue
+		}
+		if bucket, _ := owner(metric); bucket == "" || len(clusterStats.Buckets) > 0 {
+			delete(baselines, metric)
+		}
+	}
+	anomalies := []Anomaly{}
+	for metric, value := range values {
+		metricBaselines, found := baselines[metric]
+		if !found || len(metricBaselines) != slots {
+			metricBaselines = make([]baseline, slots)
+			baselines[metric] = metricBaselines
+		}
+		current := &metricBaselines[slot]
+		if current.Count >= int64(d.config.MinSamples) {
+			stdDev := current.stdDev()
+			if deviations := math.Abs(value-current.Mean) / stdDev; deviations > d.config.Deviations {
+				anomaly := Anomaly{
+					Metric:     metric,
+					Value:      value,
+					Mean:       current.Mean,
+					StdDev:     stdDev,
+					Deviations: deviations,
+					Samples:    current.Count,
+					Weekday:    local.Weekday().String(),
+					Hour:       local.Hour(),
+					Time:       collected,
+				}
+				anomaly.Bucket, anomaly.Node = owner(metric)
+				anomalies = append(anomalies, anomaly)
+			}
+		}
+		current.add(value)
+	}
+	sort.Slice(anomalies, func(i, j int) bool {
+		return anomalies[i].Metric < anomalies[j].Metric
+	})
+	d.anomalies[cluster] = anomalies
+	d.dirty = true
+}
+
+// Forget drops the baselines and anomalies of the clusters, when they are not monitored by this instance
+// anymore
+func (d *Detector) Forget(clusters []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, cluster := range clusters {
+		if _, found := d.baselines[cluster]; found {
+			delete(d.baselines, cluster)
+			d.dirty = true
+		}
+		delete(d.anomalies, cluster)
+	}
+}
+
+// owner extracts the bucket or node of a metric named as in stats.ClusterStats.AllMetrics
+func owner(metric string) (bucket, node string) {
+	parts := strings.SplitN(metric, "/", 3)
+	if len(parts) != 3 {
+		return "", ""
+	}
+	if parts[0] == "buckets" {
+		return parts[1], ""
+	}
+	return "", parts[1]
+}
+
+// Anomalies returns the anomalies found in the latest statistics of a cluster
+func (d *Detector) Anomalies(cluster string) []Anomaly {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Anomaly{}, d.anomalies[cluster]...)
+}
+
+// Alerts returns the alerts raised by the anomalies of a cluster
+func (d *Detector) Alerts(cluster string) []alerts.Alert {
+	raised := []alerts.Alert{}
+	for _, anomaly := range d.Anomalies(cluster) {
+		severity := alerts.SeverityWarning
+		if anomaly.Deviations > d.config.CriticalDeviations {
+			severity = alerts.SeverityCritical
+		}
+		below := anomaly.Value < anomaly.Mean
+		direction := "above"
+		if below {
+			direction = "below"
+		}
+		raised = append(raised, alerts.Alert{
+			Cluster:  cluster,
+			Type:     alerts.TypeCalculated,
+			Name:     AlertName,
+			Severity: severity,
+			Message: fmt.Sprintf("%s is %g, %.1f standard deviations %s its usual %g on %s at %02d:00", anomaly.Metric,
+				anomaly.Value, anomaly.Deviations, direction, math.Round(anomaly.Mean*100)/100, anomaly.Weekday,
+				anomaly.Hour),
+			Bucket: anomaly.Bucket,
+			Node:   anomaly.Node,
+			Metric: anomaly.Metric,
+			Value:  anomaly.Value,
+			Below:  below,
+		})
+	}
+	return raised
+}
+
+// Save persists the baselines when they changed since the last save
+func (d *Detector) Save() error {
+	if !d.store.Enabled() {
+		return nil
+	}
+	d.mu.Lock()
+	if !d.dirty {
+		d.mu.Unlock()
+		return nil
+	}
+	saved := state{Version: stateVersion, Baselines: make(map[string]map[string][]baseline, len(d.baselines))}
+	for cluster, baselines := range d.baselines {
+		copied := make(map[string][]baseline, len(baselines))
+		for metric, metricBaselines := range baselines {
+			copied[metric] = append([]baseline{}, metricBaselines...)
+		}
+		saved.Baselines[cluster] = copied
+	}
+	d.dirty = false
+	d.mu.Unlock()
+	if err := d.store.Save(documentName, saved); err != nil {
+		d.mu.Lock()
+		d.dirty = true
+		d.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Run saves the baselines every save interval until stop is closed, and a last time when it is
+func (d *Detector) Run(stop <-chan struct{}) {
+	if !d.store.Enabled() {
+		return
+	}
+	ticker := time.NewTicker(d.config.SaveInterval.Duration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			if err := d.Save(); err != nil {
+				slog.Error("Cannot save anomaly baselines", "error", err)
+			}
+			return
+		case <-ticker.C:
+			if err := d.Save(); err != nil {
+				slog.Error("Cannot save anomaly baselines", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package anomaly
+
+import (
+	"cbmonitor/internal/alerts"
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/monitor/stats"
+	"cbmonitor/internal/storage"
+	"math"
+	"testing"
+	"time"
+)
+
+const week = 7 * 24 * time.Hour
+
+// start falls in the Monday 14:00 UTC slot
+var start = time.Date(2026, 10, 5, 14, 30, 0, 0, time.UTC)
+
+func newTestDetector(t *testing.T, store *storage.Store, timezone string) *Detector {
+	t.Helper()
+	d, err := NewDetector(config.Anomaly{
+		Metrics:            []string{"ram_pct_used", "buckets/*/ops_per_sec", "nodes/*/cpu_rate"},
+		Deviations:         3,
+		CriticalDeviations: 6,
+		MinSamples:         3,
+		Timezone:           timezone,
+	}, store)
+	if err != nil {
+		t.Fatalf("NewDetector() = %v", err)
+	}
+	return d
+}
+
+// clusterStats returns statistics with the operations of the orders bucket and the CPU of a node
+func clusterStats(ops int, cpu float64) stats.ClusterStats {
+	clusterStats := stats.ClusterStats{RAMPctUsed: 0.5}
+	clusterStats.Buckets = []stats.Bucket{{Name: "orders", OpsPerSec: ops}}
+	clusterStats.Nodes = []stats.Node{{Hostname: "node1", CPURate: cpu}}
+	return clusterStats
+}
+
+// learn adds the operations of the orders bucket at the same hour on consecutive weeks
+func learn(d *Detector, ops ...int) {
+	for i, value := range ops {
+		d.Add("east", start.Add(time.Duration(i)*week), clusterStats(value, 20))
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		mean   float64
+		stdDev float64
+	}{
+		{name: "spread", values: []float64{2, 4, 4, 4, 5, 5, 7, 9}, mean: 5, stdDev: math.Sqrt(32.0 / 7)},
+		{name: "single", values: []float64{50}, mean: 50, stdDev: 0.5},
+		{name: "constant", values: []float64{200, 200, 200}, mean: 200, stdDev: 2},
+		{name: "zero", values: []float64{0, 0}, mean: 0, stdDev: 1e-9},
+		{name: "negative", values: []float64{-10, -10}, mean: -10, stdDev: 0.1},
+	}
+	for _, test := range tests {
+		var b baseline
+		for _, value := range test.values {
+			b.add(value)
+		}
+		if b.Count != int64(len(test.values)) || math.Abs(b.Mean-test.mean) > 1e-9 ||
+			math.Abs(b.stdDev()-test.stdDev) > 1e-9 {
+			t.Errorf("%s: mean %v and deviation %v over %d values, want %v and %v", test.name, b.Mean,
+				b.stdDev(), b.Count, test.mean, test.stdDev)
+		}
+	}
+}
+
+func TestAlerts(t *testing.T) {
+	tests := []struct {
+		name     string
+		ops      int
+		severity alerts.Severity
+		message  string
+	}{
+		{name: "usual", ops: 105},
+		{name: "above", ops: 140, severity: alerts.SeverityWarning, message: "buckets/orders/ops_per_sec is " +
+			"140, 4.9 standard deviations above its usual 100 on Monday at 14:00"},
+		{name: "far above", ops: 160, severity: alerts.SeverityCritical},
+		{name: "below", ops: 70, severity: alerts.SeverityWarning},
+	}
+	for _, test := range tests {
+		d := newTestDetector(t, nil, "UTC")
+		learn(d, 100, 110, 90, 100, test.ops)
+		raised := d.Alerts("east")
+		if test.severity == alerts.SeverityOK {
+			if len(raised) > 0 {
+				t.Errorf("%s: Alerts() = %+v, want none", test.name, raised)
+			}
+			continue
+		}
+		if len(raised) != 1 {
+			t.Fatalf("%s: Alerts() = %+v, want one alert", test.name, raised)
+		}
+		alert := raised[0]
+		if alert.Severity != test.severity || alert.Bucket != "orders" || alert.Name != AlertName ||
+			alert.Type != alerts.TypeCalculated || alert.Value != float64(test.ops) || alert.Below != (test.ops < 100) {
+			t.Errorf("%s: alert = %+v", test.name, alert)
+		}
+		if test.message != "" && alert.Message != test.message {
+			t.Errorf("%s: message %q, want %q", test.name, alert.Message, test.message)
+		}
+	}
+}
+
+func TestMinSamples(t *testing.T) {
+	d := newTestDetector(t, nil, "UTC")
+	learn(d, 100, 100, 1000)
+	if anomalies := d.Anomalies("east"); len(anomalies) > 0 {
+		t.Errorf("Anomalies() = %+v before %d samples, want none", anomalies, 3)
+	}
+}
+
+func TestSlots(t *testing.T) {
+	tests := []struct {
+		name     string
+		timezone string
+		offset   time.Duration
+		anomaly  bool
+		weekday  string
+		hour     int
+	}{
+		{name: "same hour", timezone: "UTC", offset: 20 * time.Minute, anomaly: true, weekday: "Monday", hour: 14},
+		{name: "next hour", timezone: "UTC", offset: time.Hour},
+		{name: "next day", timezone: "UTC", offset: 24 * time.Hour},
+		{name: "local time", timezone: "Asia/Tokyo", anomaly: true, weekday: "Monday", hour: 23},
+	}
+	for _, test := range tests {
+		d := newTestDetector(t, nil, test.timezone)
+		learn(d, 100, 100, 100)
+		d.Add("east", start.Add(3*week+test.offset), clusterStats(1000, 20))
+		anomalies := d.Anomalies("east")
+		if !test.anomaly {
+			if len(anomalies) > 0 {
+				t.Errorf("%s: Anomalies() = %+v, want none without baseline", test.name, anomalies)
+			}
+			continue
+		}
+		if len(anomalies) != 1 || anomalies[0].Weekday != test.weekday || anomalies[0].Hour != test.hour ||
+			anomalies[0].Samples != 3 {
+			t.Errorf("%s: Anomalies() = %+v, want one on %s at %d", test.name, anomalies, test.weekday, test.hour)
+		}
+	}
+	if _, err := NewDetector(config.Anomaly{Timezone: "Mars/Olympus"}, nil); err == nil {
+		t.Errorf("NewDetector() accepted an unknown timezone")
+	}
+}
+
+func TestPruning(t *testing.T) {
+	d := newTestDetector(t, nil, "UTC")
+	learn(d, 100)
+	if metrics := len(d.baselines["east"]); metrics != 3 {
+		t.Fatalf("%d metrics tracked, want 3", metrics)
+	}
+	// partial scrapes have no bucket
+	partial := clusterStats(0, 20)
+	partial.Buckets = nil
+	d.Add("east", start.Add(time.Hour), partial)
+	if _, found := d.baselines["east"]["buckets/orders/ops_per_sec"]; !found {
+		t.Errorf("bucket baselines dropped by a partial scrape")
+	}
+	replaced := clusterStats(100, 20)
+	replaced.Buckets[0].Name = "invoices"
+	replaced.Nodes[0].Hostname = "node2"
+	d.Add("east", start.Add(2*time.Hour), replaced)
+	for _, metric := range []string{"buckets/orders/ops_per_sec", "nodes/node1/cpu_rate"} {
+		if _, found := d.baselines["east"][metric]; found {
+			t.Errorf("baselines of %s kept after its removal", metric)
+		}
+	}
+	if metrics := len(d.baselines["east"]); metrics != 3 {
+		t.Errorf("%d metrics tracked, want the new bucket and node", metrics)
+	}
+}
+
+func TestForget(t *testing.T) {
+	d := newTestDetector(t, nil, "UTC")
+	learn(d, 100, 100, 100, 1000)
+	d.Add("west", start, clusterStats(100, 20))
+	d.Forget([]string{"east"})
+	if _, found := d.baselines["east"]; found || len(d.Alerts("east")) > 0 {
+		t.Errorf("east baselines or anomalies kept after Forget")
+	}
+	if _, found := d.baselines["west"]; !found {
+		t.Errorf("west baselines dropped")
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	store, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newTestDetector(t, store, "UTC")
+	learn(d, 100, 110, 90)
+	if err := d.Save(); err != nil {
+		t.Fatalf("Save() = %v", err)
+	}
+	loaded := newTestDetector(t, store, "UTC")
+	loaded.Add("east", start.Add(3*week), clusterStats(170, 20))
+	if raised := loaded.Alerts("east"); len(raised) != 1 || raised[0].Severity != alerts.SeverityCritical {
+		t.Errorf("Alerts() with loaded baselines = %+v, want a critical anomaly", raised)
+	}
+}
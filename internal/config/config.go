@@ -2,8 +2,21 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+)
+
+const (
+	// ScopeRead allows consulting the API
+	ScopeRead = "read"
+	// ScopeAdmin allows consulting and modifying through the API
+	ScopeAdmin = "admin"
 )
 
 // Cluster couchbase cluster information
@@ -13,6 +26,7 @@ type Cluster struct {
 	Hostname    string
 	Protocol    string
 	Port        string
+	Labels      map[string]string
 }
 
 // Auth simple authentication
@@ -22,27 +36,385 @@ type Auth struct {
 }
 
 type clusterInfo struct {
-	Name     string `json:"name"`
-	Hostname string `json:"hostname"`
-	Protocol string `json:"protocol,omitempty"`
-	Port     string `json:"port,omitempty"`
+	Name     string            `json:"name"`
+	Hostname string            `json:"hostname"`
+	Protocol string            `json:"protocol,omitempty"`
+	Port     string            `json:"port,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+// Configuration full content of a configuration file
+type Configuration struct {
+	Clusters      []Cluster
+	Server        Server
+	Rules         []Rule
+	Outputs       Outputs
+	Forecast      Forecast
+	Anomaly       Anomaly
+	Advisor       Advisor
+	Notifications Notifications
+	AlertHistory  AlertHistory
+	Events        Events
+	HA            HA
+	Sharding      Sharding
+	Storage       Storage
+}
+
+// Notifications alert notifications: every alert goes down the routing tree to the receivers of the
+// routes it matches
+type Notifications struct {
+	Receivers []Receiver `json:"receivers,omitempty"`
+	Route     *Route     `json:"route,omitempty"`
+}
+
+// Receiver destination of notifications, a notification is sent to each of its integrations
+type Receiver struct {
+	Name    string   `json:"name"`
+	Webhook *Webhook `json:"webhook,omitempty"`
+	Email   *Email   `json:"email,omitempty"`
+}
+
+// Webhook notifications POSTed as JSON to URL
+type Webhook struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Timeout Duration          `json:"timeout,omitempty"`
+}
+
+// Email notifications sent through the SMTP server at SMTP (host:port), authenticated when Username is set
+type Email struct {
+	SMTP     string   `json:"smtp"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	Username string   `json:"user,omitempty"`
+	Password string   `json:"password,omitempty"`
+}
+
+// Route node of the routing tree. An alert matches a route when every Match attribute equals and every
+// MatchRE attribute matches (anchored) its value; attributes are cluster, severity, type, name, bucket,
+// node and the cluster labels. Alerts are handled by the first matching child route, and by the next
+// ones while the matching routes have Continue set, or by the route itself when no child matches.
+// Receiver, GroupBy and the intervals are inherited from the parent route when not set
+type Route struct {
+	Name           string            `json:"name,omitempty"`
+	Receiver       string            `json:"receiver,omitempty"`
+	Match          map[string]string `json:"match,omitempty"`
+	MatchRE        map[string]string `json:"matchRe,omitempty"`
+	Continue       bool              `json:"continue,omitempty"`
+	GroupBy        []string          `json:"groupBy,omitempty"`
+	GroupInterval  Duration          `json:"groupInterval,omitempty"`
+	RepeatInterval Duration          `json:"repeatInterval,omitempty"`
+	Routes         []Route           `json:"routes,omitempty"`
+}
+
+// DefaultMemoryQuotaRatio share of the RAM of a node its service quotas may use when none is configured
+const DefaultMemoryQuotaRatio = 0.8
+
+// Advisor best-practice checks run on every scrape, checks listed in Disabled (by ID) are skipped.
+// MemoryQuotaRatio is the share of the RAM of a node its service quotas may use
+type Advisor struct {
+	Disabled         []string `json:"disabled,omitempty"`
+	MemoryQuotaRatio float64  `json:"memoryQuotaRatio,omitempty"`
+}
+
+// AlertHistory lifecycle records of the raised alerts, resolved alerts are forgotten after Retention
+type AlertHistory struct {
+	Retention Duration `json:"retention,omitempty"`
+}
+
+// Events events detected between scrapes (Couchbase server alerts...), forgotten after Retention
+type Events struct {
+	Retention Duration `json:"retention,omitempty"`
+}
+
+// HA high availability between replicas sharing LockFile (on a shared volume): the replica holding the
+// lock leads, it renews it every third of Lease and another replica takes over once it expires. Disabled
+// without LockFile, ID defaults to the hostname and process ID. URL is where the other replicas reach the
+// API of this one when it leads, Token the bearer token they send when the API requires one
+type HA struct {
+	LockFile string   `json:"lockFile,omitempty"`
+	ID       string   `json:"id,omitempty"`
+	URL      string   `json:"url,omitempty"`
+	Token    string   `json:"token,omitempty"`
+	Lease    Duration `json:"lease,omitempty"`
+}
+
+// Enabled reports whether leader election is configured
+func (h HA) Enabled() bool {
+	return h.LockFile != ""
+}
+
+// Sharding splits the clusters between instances by consistent hashing. With Count the instance owns the
+// clusters of shard Index out of Count; otherwise it probes Peers every ProbeInterval and shares the
+// clusters with those that answer, they identify each other by their URLs and Self is its own. Peers are
+// also queried by the federation endpoint, with Token as bearer token when their API requires one
+type Sharding struct {
+	Index         int      `json:"index,omitempty"`
+	Count         int      `json:"count,omitempty"`
+	Self          string   `json:"self,omitempty"`
+	Peers         []string `json:"peers,omitempty"`
+	Token         string   `json:"token,omitempty"`
+	ProbeInterval Duration `json:"probeInterval,omitempty"`
+	Timeout       Duration `json:"timeout,omitempty"`
+}
+
+// Enabled reports whether the clusters are split between instances
+func (s Sharding) Enabled() bool {
+	return s.Count > 0 || len(s.Peers) > 0
+}
+
+// Static reports whether the shards are given rather than discovered
+func (s Sharding) Static() bool {
+	return s.Count > 0
+}
+
+// Storage persistence of the monitor state (anomaly baselines, alert history, events...), disabled without
+// Path
+type Storage struct {
+	Path string `json:"path,omitempty"`
+}
+
+// DefaultAnomalyMetrics metrics whose baselines are learned when none are configured
+var DefaultAnomalyMetrics = []string{"buckets/*/ops_per_sec", "buckets/*/disk_fetches", "get_hit_ratio", "nodes/*/cpu_rate"}
+
+// Anomaly anomaly detection settings: a baseline (mean and standard deviation) is learned for every metric
+// matching Metrics in each hour of the week, values further than Deviations standard deviations from the
+// baseline of their hour raise warnings (critical beyond CriticalDeviations)
+type Anomaly struct {
+	Metrics            []string `json:"metrics,omitempty"`
+	Deviations         float64  `json:"deviations,omitempty"`
+	CriticalDeviations float64  `json:"criticalDeviations,omitempty"`
+	MinSamples         int      `json:"minSamples,omitempty"`
+	Timezone           string   `json:"timezone,omitempty"`
+	SaveInterval       Duration `json:"saveInterval,omitempty"`
+}
+
+// Forecast capacity forecasting settings: a sample per Resolution is kept over Window to fit the growth
+// of the cluster RAM, the cluster disk and the bucket quotas (in percent) until they reach their threshold
+type Forecast struct {
+	Resolution Duration           `json:"resolution,omitempty"`
+	Window     Duration           `json:"window,omitempty"`
+	MinSamples int                `json:"minSamples,omitempty"`
+	Thresholds ForecastThresholds `json:"thresholds"`
+	// Warning and Critical raise alerts when a threshold is forecast to be reached within them
+	Warning  Duration `json:"warning,omitempty"`
+	Critical Duration `json:"critical,omitempty"`
+}
+
+// ForecastThresholds usage percentages forecasts are computed for
+type ForecastThresholds struct {
+	RAM         float64 `json:"ram,omitempty"`
+	Disk        float64 `json:"disk,omitempty"`
+	BucketQuota float64 `json:"bucketQuota,omitempty"`
+}
+
+// Duration time.Duration read from JSON strings like "10s"
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON decodes durations written as strings ("1m30s") or as a number of seconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		d.Duration = time.Duration(seconds * float64(time.Second))
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	duration, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+	return nil
+}
+
+// MarshalJSON encodes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Outputs destinations the scraped statistics are pushed to
+type Outputs struct {
+	Influx   *Influx   `json:"influx,omitempty"`
+	Graphite *Graphite `json:"graphite,omitempty"`
+	StatsD   *StatsD   `json:"statsd,omitempty"`
+	OTLP     *OTLP     `json:"otlp,omitempty"`
+	// Container keeps the latest statistics served by the API, Stdout prints every scrape result and
+	// is disabled by default
+	Container Sink `json:"container"`
+	Stdout    Sink `json:"stdout"`
+}
+
+// DefaultSinkBufferSize number of scrape results queued for a sink before the oldest are dropped
+const DefaultSinkBufferSize = 64
+
+// Sink options of a built-in sink, it is enabled unless Enabled is false
+type Sink struct {
+	Enabled    *bool `json:"enabled,omitempty"`
+	BufferSize int   `json:"bufferSize,omitempty"`
+}
+
+// IsEnabled reports whether the sink should run
+func (s Sink) IsEnabled() bool {
+	return s.Enabled == nil || *s.Enabled
+}
+
+const (
+	// OTLPHTTP OTLP over HTTP with protobuf payloads
+	OTLPHTTP = "http/protobuf"
+	// OTLPGRPC OTLP over gRPC
+	OTLPGRPC = "grpc"
+)
+
+// OTLP OpenTelemetry metrics export. Endpoint is the collector URL (like http://collector:4318 or
+// https://collector:4317 for gRPC), http:// gRPC endpoints are reached without TLS
+type OTLP struct {
+	Endpoint string            `json:"endpoint"`
+	Protocol string            `json:"protocol,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Interval Duration          `json:"interval,omitempty"`
+	Timeout  Duration          `json:"timeout,omitempty"`
+	TLS      ClientTLS         `json:"tls"`
+}
+
+// ClientTLS TLS settings used to reach a remote endpoint, the system roots are used without CAFile
+type ClientTLS struct {
+	CAFile             string `json:"ca,omitempty"`
+	CertFile           string `json:"cert,omitempty"`
+	KeyFile            string `json:"key,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+}
+
+// MetricPaths templates of the dotted metric paths. {cluster}, {node}, {bucket} and {metric} are
+// replaced by the sanitized cluster name, node hostname, bucket name and metric name
+type MetricPaths struct {
+	Cluster string `json:"cluster,omitempty"`
+	Node    string `json:"node,omitempty"`
+	Bucket  string `json:"bucket,omitempty"`
+}
+
+// Graphite plaintext protocol output (TCP)
+type Graphite struct {
+	Address string      `json:"address"`
+	Paths   MetricPaths `json:"paths"`
+	Timeout Duration    `json:"timeout,omitempty"`
+}
+
+// StatsD gauges output (UDP)
+type StatsD struct {
+	Address       string      `json:"address"`
+	Paths         MetricPaths `json:"paths"`
+	MaxPacketSize int         `json:"maxPacketSize,omitempty"`
+}
+
+// Influx InfluxDB line protocol output. URL is the full write endpoint, like
+// http://influx:8086/api/v2/write?org=ops&bucket=couchbase or http://influx:8086/write?db=couchbase.
+// Up to BufferSize lines are kept while the endpoint fails, MaxRetries -1 disables the retries
+type Influx struct {
+	URL           string   `json:"url"`
+	Token         string   `json:"token,omitempty"`
+	Username      string   `json:"user,omitempty"`
+	Password      string   `json:"password,omitempty"`
+	Measurement   string   `json:"measurementPrefix,omitempty"`
+	BatchSize     int      `json:"batchSize,omitempty"`
+	BufferSize    int      `json:"bufferSize,omitempty"`
+	FlushInterval Duration `json:"flushInterval,omitempty"`
+	MaxRetries    int      `json:"maxRetries,omitempty"`
+	Timeout       Duration `json:"timeout,omitempty"`
+}
+
+// Rule threshold on a metric. Metric uses the names of the history API and accepts * wildcards (like
+// buckets/*/quota_pct_used). Below raises the alert when the value falls under the thresholds
+type Rule struct {
+	Name     string   `json:"name,omitempty"`
+	Metric   string   `json:"metric"`
+	Warning  *float64 `json:"warning,omitempty"`
+	Critical *float64 `json:"critical,omitempty"`
+	Below    bool     `json:"below,omitempty"`
+}
+
+// Server settings of the HTTP API
+type Server struct {
+	Listen string  `json:"listen,omitempty"`
+	TLS    TLS     `json:"tls"`
+	Auth   APIAuth `json:"auth"`
+}
+
+// TLS certificate and key files used to serve the API over HTTPS
+type TLS struct {
+	CertFile string `json:"cert,omitempty"`
+	KeyFile  string `json:"key,omitempty"`
+}
+
+// Enabled tells whether the API must be served over HTTPS
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+// APIAuth credentials accepted by the API, authentication is disabled when none are defined
+type APIAuth struct {
+	Users  []APIUser  `json:"users,omitempty"`
+	Tokens []APIToken `json:"tokens,omitempty"`
+}
+
+// Enabled tells whether API consumers must authenticate
+func (a APIAuth) Enabled() bool {
+	return len(a.Users) > 0 || len(a.Tokens) > 0
+}
+
+// APIUser basic authentication user of the API
+type APIUser struct {
+	Username string `json:"user"`
+	Password string `json:"password"`
+	Scope    string `json:"scope,omitempty"`
+}
+
+// APIToken bearer token accepted by the API
+type APIToken struct {
+	Token string `json:"token"`
+	Scope string `json:"scope,omitempty"`
 }
 
 type configFile struct {
-	DefaultAuth Auth
-	Clusters    []clusterInfo
+	DefaultAuth   Auth
+	Clusters      []clusterInfo
+	Server        Server
+	Rules         []Rule
+	Outputs       Outputs
+	Forecast      Forecast
+	Anomaly       Anomaly
+	Advisor       Advisor
+	Notifications Notifications
+	AlertHistory  AlertHistory
+	Events        Events
+	HA            HA
+	Sharding      Sharding
+	Storage       Storage
 }
 
 // NewFileConfiguration extracts the configuration of multiple clusters from a given file
 func NewFileConfiguration(filename string) ([]Cluster, error) {
-	bytes, err := ioutil.ReadFile(filename)
+	configuration, err := LoadFile(filename)
 	if err != nil {
 		return []Cluster{}, err
 	}
+	return configuration.Clusters, nil
+}
+
+// LoadFile reads the clusters and the server settings from a given file
+func LoadFile(filename string) (Configuration, error) {
+	bytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return Configuration{}, err
+	}
 	var fileContent configFile
 	err = json.Unmarshal(bytes, &fileContent)
 	if err != nil {
-		return []Cluster{}, err
+		return Configuration{}, err
 	}
 	clusters := make([]Cluster, len(fileContent.Clusters))
 	for i, clusterConfig := range fileContent.Clusters {
@@ -58,6 +430,9 @@ func NewFileConfiguration(filename string) ([]Cluster, error) {
 				port = "18091"
 			}
 		}
+		if err := validateLabels(clusterConfig.Name, clusterConfig.Labels); err != nil {
+			return Configuration{}, err
+		}
 		cluster := Cluster{
 			Credentials: Auth{
 				Username: fileContent.DefaultAuth.Username,
@@ -67,8 +442,521 @@ func NewFileConfiguration(filename string) ([]Cluster, error) {
 			Hostname: clusterConfig.Hostname,
 			Protocol: protocol,
 			Port:     port,
+			Labels:   clusterConfig.Labels,
 		}
 		clusters[i] = cluster
 	}
-	return clusters, nil
+	server, err := normalizeServer(fileContent.Server)
+	if err != nil {
+		return Configuration{}, err
+	}
+	rules, err := normalizeRules(fileContent.Rules)
+	if err != nil {
+		return Configuration{}, err
+	}
+	outputs, err := normalizeOutputs(fileContent.Outputs)
+	if err != nil {
+		return Configuration{}, err
+	}
+	forecast, err := normalizeForecast(fileContent.Forecast)
+	if err != nil {
+		return Configuration{}, err
+	}
+	anomaly, err := normalizeAnomaly(fileContent.Anomaly)
+	if err != nil {
+		return Configuration{}, err
+	}
+	advisor, err := normalizeAdvisor(fileContent.Advisor)
+	if err != nil {
+		return Configuration{}, err
+	}
+	notifications, err := normalizeNotifications(fileContent.Notifications)
+	if err != nil {
+		return Configuration{}, err
+	}
+	alertHistory, err := normalizeAlertHistory(fileContent.AlertHistory)
+	if err != nil {
+		return Configuration{}, err
+	}
+	events, err := normalizeEvents(fileContent.Events)
+	if err != nil {
+		return Configuration{}, err
+	}
+	ha, err := normalizeHA(fileContent.HA)
+	if err != nil {
+		return Configuration{}, err
+	}
+	sharding, err := normalizeSharding(fileContent.Sharding)
+	if err != nil {
+		return Configuration{}, err
+	}
+	if ha.Enabled() && sharding.Enabled() {
+		return Configuration{}, fmt.Errorf("ha and sharding cannot be enabled together, a single lock would elect one leader for every shard")
+	}
+	return Configuration{
+		Clusters:      clusters,
+		Server:        server,
+		Rules:         rules,
+		Outputs:       outputs,
+		Forecast:      forecast,
+		Anomaly:       anomaly,
+		Advisor:       advisor,
+		Notifications: notifications,
+		AlertHistory:  alertHistory,
+		Events:        events,
+		HA:            ha,
+		Sharding:      sharding,
+		Storage:       fileContent.Storage,
+	}, nil
+}
+
+// reservedLabels names of the labels and tags the outputs already set, and of the alert attributes
+// matched by the notification routes
+var reservedLabels = []string{"cluster", "cluster_name", "node", "bucket", "bucket_type", "version", "status",
+	"state", "le", "severity", "type", "name"}
+
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// validateLabels checks the label names are valid Prometheus label names and do not collide with the
+// labels set by the outputs
+func validateLabels(cluster string, labels map[string]string) error {
+	for name := range labels {
+		if !labelName.MatchString(name) {
+			return fmt.Errorf("cluster %s: invalid label name %q", cluster, name)
+		}
+		for _, reserved := range reservedLabels {
+			if name == reserved {
+				return fmt.Errorf("cluster %s: label name %q is reserved", cluster, name)
+			}
+		}
+	}
+	return nil
+}
+
+func normalizeNotifications(notifications Notifications) (Notifications, error) {
+	receivers := make(map[string]bool, len(notifications.Receivers))
+	for i, receiver := range notifications.Receivers {
+		if receiver.Name == "" {
+			return Notifications{}, fmt.Errorf("receiver #%d has no name", i+1)
+		}
+		if receivers[receiver.Name] {
+			return Notifications{}, fmt.Errorf("receiver name %s is used more than once", receiver.Name)
+		}
+		receivers[receiver.Name] = true
+		if receiver.Webhook == nil && receiver.Email == nil {
+			return Notifications{}, fmt.Errorf("receiver %s has no webhook nor email", receiver.Name)
+		}
+		if webhook := receiver.Webhook; webhook != nil {
+			if webhook.URL == "" {
+				return Notifications{}, fmt.Errorf("receiver %s webhook has no url", receiver.Name)
+			}
+			if webhook.Timeout.Duration <= 0 {
+				webhook.Timeout.Duration = 10 * time.Second
+			}
+		}
+		if email := receiver.Email; email != nil && (email.SMTP == "" || email.From == "" || len(email.To) == 0) {
+			return Notifications{}, fmt.Errorf("receiver %s email needs smtp, from and to", receiver.Name)
+		}
+	}
+	if notifications.Route == nil {
+		if len(notifications.Receivers) > 0 {
+			return Notifications{}, fmt.Errorf("notification receivers are configured without route")
+		}
+		return notifications, nil
+	}
+	root := *notifications.Route
+	if root.Receiver == "" {
+		return Notifications{}, fmt.Errorf("the root notification route has no receiver")
+	}
+	if len(root.GroupBy) == 0 {
+		root.GroupBy = []string{"cluster"}
+	}
+	if root.GroupInterval.Duration <= 0 {
+		root.GroupInterval.Duration = 5 * time.Minute
+	}
+	if root.RepeatInterval.Duration <= 0 {
+		root.RepeatInterval.Duration = 4 * time.Hour
+	}
+	if err := normalizeRoute(&root, root, receivers); err != nil {
+		return Notifications{}, err
+	}
+	notifications.Route = &root
+	return notifications, nil
+}
+
+// normalizeRoute fills the settings of the route and its children from their parent and checks them
+func normalizeRoute(route *Route, parent Route, receivers map[string]bool) error {
+	if route.Receiver == "" {
+		route.Receiver = parent.Receiver
+	}
+	if !receivers[route.Receiver] {
+		return fmt.Errorf("notification route uses unknown receiver %q", route.Receiver)
+	}
+	if len(route.GroupBy) == 0 {
+		route.GroupBy = parent.GroupBy
+	}
+	if route.GroupInterval.Duration <= 0 {
+		route.GroupInterval = parent.GroupInterval
+	}
+	if route.RepeatInterval.Duration <= 0 {
+		route.RepeatInterval = parent.RepeatInterval
+	}
+	for attribute, expression := range route.MatchRE {
+		if _, err := regexp.Compile("^(?:" + expression + ")$"); err != nil {
+			return fmt.Errorf("notification route matchRe %s: %s", attribute, err)
+		}
+	}
+	for i := range route.Routes {
+		if err := normalizeRoute(&route.Routes[i], *route, receivers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func normalizeAdvisor(advisor Advisor) (Advisor, error) {
+	if advisor.MemoryQuotaRatio == 0 {
+		advisor.MemoryQuotaRatio = DefaultMemoryQuotaRatio
+	}
+	if advisor.MemoryQuotaRatio < 0 || advisor.MemoryQuotaRatio > 1 {
+		return Advisor{}, fmt.Errorf("advisor memory quota ratio %g is not between 0 and 1", advisor.MemoryQuotaRatio)
+	}
+	return advisor, nil
+}
+
+func normalizeAlertHistory(alertHistory AlertHistory) (AlertHistory, error) {
+	if alertHistory.Retention.Duration < 0 {
+		return AlertHistory{}, fmt.Errorf("alert history retention %s is negative", alertHistory.Retention)
+	}
+	if alertHistory.Retention.Duration == 0 {
+		alertHistory.Retention.Duration = 30 * 24 * time.Hour
+	}
+	return alertHistory, nil
+}
+
+func normalizeSharding(sharding Sharding) (Sharding, error) {
+	if sharding.Count < 0 {
+		return Sharding{}, fmt.Errorf("sharding count %d is negative", sharding.Count)
+	}
+	if sharding.Index < 0 || (sharding.Index > 0 && sharding.Index >= sharding.Count) {
+		return Sharding{}, fmt.Errorf("sharding index %d is not between 0 and count %d", sharding.Index,
+			sharding.Count)
+	}
+	sharding.Self = strings.TrimSuffix(sharding.Self, "/")
+	peers := []string{}
+	for _, peer := range sharding.Peers {
+		peer = strings.TrimSuffix(peer, "/")
+		if peer == "" {
+			return Sharding{}, fmt.Errorf("sharding peer URL is empty")
+		}
+		if peer != sharding.Self {
+			peers = append(peers, peer)
+		}
+	}
+	sharding.Peers = peers
+	if !sharding.Static() && len(sharding.Peers) > 0 && sharding.Self == "" {
+		return Sharding{}, fmt.Errorf("sharding by peers needs the self URL of the instance")
+	}
+	if sharding.ProbeInterval.Duration == 0 {
+		sharding.ProbeInterval.Duration = 10 * time.Second
+	}
+	if sharding.Timeout.Duration == 0 {
+		sharding.Timeout.Duration = 3 * time.Second
+	}
+	return sharding, nil
+}
+
+func normalizeHA(ha HA) (HA, error) {
+	if !ha.Enabled() {
+		return ha, nil
+	}
+	if ha.Lease.Duration == 0 {
+		ha.Lease.Duration = 15 * time.Second
+	}
+	if ha.Lease.Duration < time.Second {
+		return HA{}, fmt.Errorf("ha lease %s is shorter than a second", ha.Lease)
+	}
+	if ha.URL == "" {
+		return HA{}, fmt.Errorf("ha needs the url of the replica API")
+	}
+	ha.URL = strings.TrimSuffix(ha.URL, "/")
+	if ha.ID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return HA{}, fmt.Errorf("cannot name the replica: %w", err)
+		}
+		ha.ID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	return ha, nil
+}
+
+func normalizeEvents(events Events) (Events, error) {
+	if events.Retention.Duration < 0 {
+		return Events{}, fmt.Errorf("events retention %s is negative", events.Retention)
+	}
+	if events.Retention.Duration == 0 {
+		events.Retention.Duration = 30 * 24 * time.Hour
+	}
+	return events, nil
+}
+
+func normalizeAnomaly(anomaly Anomaly) (Anomaly, error) {
+	if len(anomaly.Metrics) == 0 {
+		anomaly.Metrics = DefaultAnomalyMetrics
+	}
+	for _, pattern := range anomaly.Metrics {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return Anomaly{}, fmt.Errorf("invalid anomaly metric pattern %q", pattern)
+		}
+	}
+	if anomaly.Deviations <= 0 {
+		anomaly.Deviations = 3
+	}
+	if anomaly.CriticalDeviations <= 0 {
+		anomaly.CriticalDeviations = 2 * anomaly.Deviations
+	}
+	if anomaly.CriticalDeviations < anomaly.Deviations {
+		return Anomaly{}, fmt.Errorf("anomaly critical deviations %g are below the warning deviations %g",
+			anomaly.CriticalDeviations, anomaly.Deviations)
+	}
+	if anomaly.MinSamples < 2 {
+		anomaly.MinSamples = 20
+	}
+	if _, err := time.LoadLocation(anomaly.Timezone); err != nil {
+		return Anomaly{}, fmt.Errorf("invalid anomaly timezone %q", anomaly.Timezone)
+	}
+	if anomaly.SaveInterval.Duration <= 0 {
+		anomaly.SaveInterval.Duration = 5 * time.Minute
+	}
+	return anomaly, nil
+}
+
+func normalizeForecast(forecast Forecast) (Forecast, error) {
+	if forecast.Resolution.Duration <= 0 {
+		forecast.Resolution.Duration = 5 * time.Minute
+	}
+	if forecast.Window.Duration <= 0 {
+		forecast.Window.Duration = 7 * 24 * time.Hour
+	}
+	if forecast.Window.Duration < forecast.Resolution.Duration {
+		return Forecast{}, fmt.Errorf("forecast window %s is shorter than its resolution %s", forecast.Window,
+			forecast.Resolution)
+	}
+	if forecast.MinSamples < 2 {
+		forecast.MinSamples = 12
+	}
+	for _, threshold := range []*float64{&forecast.Thresholds.RAM, &forecast.Thresholds.Disk,
+		&forecast.Thresholds.BucketQuota} {
+		if *threshold < 0 || *threshold > 100 {
+			return Forecast{}, fmt.Errorf("forecast threshold %g is not a percentage", *threshold)
+		}
+	}
+	if forecast.Thresholds.RAM == 0 {
+		forecast.Thresholds.RAM = 90
+	}
+	if forecast.Thresholds.Disk == 0 {
+		forecast.Thresholds.Disk = 85
+	}
+	if forecast.Thresholds.BucketQuota == 0 {
+		forecast.Thresholds.BucketQuota = 90
+	}
+	if forecast.Warning.Duration <= 0 {
+		forecast.Warning.Duration = 14 * 24 * time.Hour
+	}
+	if forecast.Critical.Duration <= 0 {
+		forecast.Critical.Duration = 3 * 24 * time.Hour
+	}
+	return forecast, nil
+}
+
+func normalizeOutputs(outputs Outputs) (Outputs, error) {
+	if outputs.Stdout.Enabled == nil {
+		disabled := false
+		outputs.Stdout.Enabled = &disabled
+	}
+	for _, sink := range []*Sink{&outputs.Container, &outputs.Stdout} {
+		if sink.BufferSize <= 0 {
+			sink.BufferSize = DefaultSinkBufferSize
+		}
+	}
+	if influx := outputs.Influx; influx != nil {
+		if influx.URL == "" {
+			return Outputs{}, fmt.Errorf("influx output has no url")
+		}
+		if influx.Measurement == "" {
+			influx.Measurement = "couchbase"
+		}
+		if influx.BatchSize <= 0 {
+			influx.BatchSize = 1000
+		}
+		if influx.BufferSize < influx.BatchSize {
+			influx.BufferSize = 10 * influx.BatchSize
+		}
+		if influx.FlushInterval.Duration <= 0 {
+			influx.FlushInterval.Duration = 10 * time.Second
+		}
+		if influx.MaxRetries < 0 {
+			influx.MaxRetries = 0
+		} else if influx.MaxRetries == 0 {
+			influx.MaxRetries = 3
+		}
+		if influx.Timeout.Duration <= 0 {
+			influx.Timeout.Duration = 5 * time.Second
+		}
+	}
+	if graphite := outputs.Graphite; graphite != nil {
+		if graphite.Address == "" {
+			return Outputs{}, fmt.Errorf("graphite output has no address")
+		}
+		if err := normalizeMetricPaths(&graphite.Paths); err != nil {
+			return Outputs{}, fmt.Errorf("graphite output: %s", err)
+		}
+		if graphite.Timeout.Duration <= 0 {
+			graphite.Timeout.Duration = 5 * time.Second
+		}
+	}
+	if statsd := outputs.StatsD; statsd != nil {
+		if statsd.Address == "" {
+			return Outputs{}, fmt.Errorf("statsd output has no address")
+		}
+		if err := normalizeMetricPaths(&statsd.Paths); err != nil {
+			return Outputs{}, fmt.Errorf("statsd output: %s", err)
+		}
+		if statsd.MaxPacketSize <= 0 {
+			statsd.MaxPacketSize = 1432
+		}
+	}
+	if otlp := outputs.OTLP; otlp != nil {
+		if otlp.Endpoint == "" {
+			return Outputs{}, fmt.Errorf("otlp output has no endpoint")
+		}
+		if !strings.HasPrefix(otlp.Endpoint, "http://") && !strings.HasPrefix(otlp.Endpoint, "https://") {
+			return Outputs{}, fmt.Errorf("otlp endpoint %q must start with http:// or https://", otlp.Endpoint)
+		}
+		switch otlp.Protocol {
+		case "":
+			otlp.Protocol = OTLPHTTP
+		case OTLPHTTP, OTLPGRPC:
+		default:
+			return Outputs{}, fmt.Errorf("invalid otlp protocol %q, expected %q or %q", otlp.Protocol, OTLPHTTP, OTLPGRPC)
+		}
+		if (otlp.TLS.CertFile == "") != (otlp.TLS.KeyFile == "") {
+			return Outputs{}, fmt.Errorf("otlp output requires both TLS certificate and key files")
+		}
+		if otlp.Interval.Duration <= 0 {
+			otlp.Interval.Duration = 30 * time.Second
+		}
+		if otlp.Timeout.Duration <= 0 {
+			otlp.Timeout.Duration = 10 * time.Second
+		}
+	}
+	return outputs, nil
+}
+
+func normalizeMetricPaths(paths *MetricPaths) error {
+	if paths.Cluster == "" {
+		paths.Cluster = "couchbase.{cluster}.{metric}"
+	}
+	if paths.Node == "" {
+		paths.Node = "couchbase.{cluster}.nodes.{node}.{metric}"
+	}
+	if paths.Bucket == "" {
+		paths.Bucket = "couchbase.{cluster}.buckets.{bucket}.{metric}"
+	}
+	for _, template := range []string{paths.Cluster, paths.Node, paths.Bucket} {
+		if !strings.Contains(template, "{metric}") {
+			return fmt.Errorf("metric path %q has no {metric} placeholder", template)
+		}
+	}
+	if !strings.Contains(paths.Node, "{node}") {
+		return fmt.Errorf("node metric path %q has no {node} placeholder", paths.Node)
+	}
+	if !strings.Contains(paths.Bucket, "{bucket}") {
+		return fmt.Errorf("bucket metric path %q has no {bucket} placeholder", paths.Bucket)
+	}
+	return nil
+}
+
+func normalizeRules(rules []Rule) ([]Rule, error) {
+	for i, rule := range rules {
+		if rule.Metric == "" {
+			return nil, fmt.Errorf("rule #%d has no metric", i+1)
+		}
+		if rule.Warning == nil && rule.Critical == nil {
+			return nil, fmt.Errorf("rule #%d (%s) has no warning nor critical threshold", i+1, rule.Metric)
+		}
+		if rule.Name == "" {
+			rules[i].Name = rule.Metric
+		}
+	}
+	return rules, nil
+}
+
+// Validate lists the problems of the configuration that do not prevent reading it
+func (c Configuration) Validate() []error {
+	problems := []error{}
+	if len(c.Clusters) == 0 {
+		problems = append(problems, fmt.Errorf("no cluster configured"))
+	}
+	names := make(map[string]bool, len(c.Clusters))
+	for i, cluster := range c.Clusters {
+		name := cluster.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+			problems = append(problems, fmt.Errorf("cluster %s has no name", name))
+		} else if names[name] {
+			problems = append(problems, fmt.Errorf("cluster name %s is used more than once", name))
+		}
+		names[cluster.Name] = true
+		if cluster.Hostname == "" {
+			problems = append(problems, fmt.Errorf("cluster %s has no hostname", name))
+		}
+		if cluster.Protocol != "http" && cluster.Protocol != "https" {
+			problems = append(problems, fmt.Errorf("cluster %s has an invalid protocol %q", name, cluster.Protocol))
+		}
+		if port, err := strconv.Atoi(cluster.Port); err != nil || port <= 0 || port > 65535 {
+			problems = append(problems, fmt.Errorf("cluster %s has an invalid port %q", name, cluster.Port))
+		}
+		if cluster.Credentials.Username == "" {
+			problems = append(problems, fmt.Errorf("cluster %s has no user", name))
+		}
+	}
+	return problems
+}
+
+func normalizeScope(scope string) (string, error) {
+	switch strings.ToLower(scope) {
+	case "", ScopeRead:
+		return ScopeRead, nil
+	case ScopeAdmin:
+		return ScopeAdmin, nil
+	}
+	return "", fmt.Errorf("invalid API scope %q, expected %q or %q", scope, ScopeRead, ScopeAdmin)
+}
+
+func normalizeServer(server Server) (Server, error) {
+	if server.TLS.Enabled() && (server.TLS.CertFile == "" || server.TLS.KeyFile == "") {
+		return Server{}, fmt.Errorf("both TLS certificate and key files are required")
+	}
+	for i, user := range server.Auth.Users {
+		if user.Username == "" {
+			return Server{}, fmt.Errorf("API user #%d has no name", i+1)
+		}
+		scope, err := normalizeScope(user.Scope)
+		if err != nil {
+			return Server{}, fmt.Errorf("API user %s: %s", user.Username, err)
+		}
+		server.Auth.Users[i].Scope = scope
+	}
+	for i, token := range server.Auth.Tokens {
+		if token.Token == "" {
+			return Server{}, fmt.Errorf("API token #%d is empty", i+1)
+		}
+		scope, err := normalizeScope(token.Scope)
+		if err != nil {
+			return Server{}, fmt.Errorf("API token #%d: %s", i+1, err)
+		}
+		server.Auth.Tokens[i].Scope = scope
+	}
+	return server, nil
 }
@@ -0,0 +1,276 @@
+package dashboard
+
+// The dashboard is kept in Go sources so it ships inside the binary without external assets
+
+const indexHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>cbmonitor</title>
+<link rel="stylesheet" href="style.css">
+</head>
+<body data-refresh="{{refreshMs}}">
+<header>
+  <h1>cbmonitor</h1>
+  <span id="updated"></span>
+  <span id="error" class="error"></span>
+</header>
+<main>
+  <section id="grid" class="grid"></section>
+  <section id="detail" class="detail hidden"></section>
+</main>
+<script src="app.js"></script>
+</body>
+</html>
+`
+
+const styleCSS = `* { box-sizing: border-box; }
+body { margin: 0; font: 14px/1.4 -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; background: #f4f5f7; color: #1d2330; }
+header { display: flex; align-items: baseline; gap: 16px; padding: 12px 20px; background: #1d2330; color: #fff; }
+header h1 { margin: 0; font-size: 18px; }
+#updated { color: #aab; font-size: 12px; }
+.error { color: #ff8a80; font-size: 12px; }
+main { padding: 20px; }
+.grid { display: grid; grid-template-columns: repeat(auto-fill, minmax(240px, 1fr)); gap: 12px; }
+.card { background: #fff; border-radius: 6px; border-top: 6px solid #9aa0a6; padding: 12px; cursor: pointer; box-shadow: 0 1px 2px rgba(0,0,0,.1); }
+.card.selected { outline: 2px solid #3b6fd8; }
+.card h2 { margin: 0 0 6px; font-size: 16px; }
+.card .metrics { display: grid; grid-template-columns: 1fr 1fr; font-size: 12px; color: #555; }
+.state-up { border-color: #2e9e52; }
+.state-degraded { border-color: #f0a020; }
+.state-down { border-color: #d93025; }
+.state-unknown { border-color: #9aa0a6; }
+.badge { display: inline-block; padding: 0 6px; border-radius: 3px; font-size: 11px; color: #fff; background: #9aa0a6; text-transform: uppercase; }
+.badge.state-up { background: #2e9e52; }
+.badge.state-degraded { background: #f0a020; }
+.badge.state-down { background: #d93025; }
+.detail { margin-top: 20px; background: #fff; border-radius: 6px; padding: 16px; box-shadow: 0 1px 2px rgba(0,0,0,.1); }
+.detail h2 { margin-top: 0; }
+.detail h3 { margin: 18px 0 6px; font-size: 14px; }
+.hidden { display: none; }
+table { border-collapse: collapse; width: 100%; font-size: 12px; }
+th, td { text-align: left; padding: 4px 8px; border-bottom: 1px solid #e4e6ea; }
+th { color: #555; font-weight: 600; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+ul.alerts { margin: 0; padding-left: 18px; }
+ul.alerts li { color: #a4251c; }
+svg.spark { vertical-align: middle; }
+svg.spark polyline { fill: none; stroke: #3b6fd8; stroke-width: 1.5; }
+`
+
+const appJS = `(function () {
+  "use strict";
+  var refreshMs = parseInt(document.body.getAttribute("data-refresh"), 10) || 15000;
+  var clusters = [];
+  var selected = null;
+  var clusterHistory = {};
+
+  function el(tag, attrs, children) {
+    var node = document.createElement(tag);
+    Object.keys(attrs || {}).forEach(function (name) {
+      node.setAttribute(name, attrs[name]);
+    });
+    (children || []).forEach(function (child) {
+      if (child === null || child === undefined) {
+        return;
+      }
+      node.appendChild(typeof child === "object" ? child : document.createTextNode(String(child)));
+    });
+    return node;
+  }
+
+  function clear(node) {
+    while (node.firstChild) {
+      node.removeChild(node.firstChild);
+    }
+  }
+
+  function pct(value) {
+    return (100 * (value || 0)).toFixed(1) + "%";
+  }
+
+  function fetchJSON(url) {
+    return fetch(url, { credentials: "same-origin" }).then(function (response) {
+      if (!response.ok) {
+        throw new Error(url + ": " + response.status + " " + response.statusText);
+      }
+      return response.json();
+    });
+  }
+
+  function historyURL(cluster, metrics) {
+    var query = "cluster=" + encodeURIComponent(cluster);
+    metrics.forEach(function (metric) {
+      query += "&metric=" + encodeURIComponent(metric);
+    });
+    return "/history?" + query;
+  }
+
+  function sparkline(points, width, height, max) {
+    var svgNS = "http://www.w3.org/2000/svg";
+    var svg = document.createElementNS(svgNS, "svg");
+    svg.setAttribute("class", "spark");
+    svg.setAttribute("width", width);
+    svg.setAttribute("height", height);
+    if (!points || points.length < 2) {
+      return svg;
+    }
+    var values = points.map(function (point) { return point.v; });
+    var top = max || Math.max.apply(null, values);
+    var bottom = max ? 0 : Math.min.apply(null, values);
+    var range = top - bottom || 1;
+    var coordinates = values.map(function (value, i) {
+      var x = (i / (values.length - 1)) * (width - 2) + 1;
+      var y = height - 1 - ((value - bottom) / range) * (height - 2);
+      return x.toFixed(1) + "," + y.toFixed(1);
+    });
+    var line = document.createElementNS(svgNS, "polyline");
+    line.setAttribute("points", coordinates.join(" "));
+    svg.appendChild(line);
+    return svg;
+  }
+
+  function alertsOf(cluster) {
+    var alerts = [];
+    ((cluster.alerts && cluster.alerts.calculated) || []).forEach(function (message) {
+      alerts.push(message);
+    });
+    ((cluster.alerts && cluster.alerts.cluster) || []).forEach(function (alert) {
+      alerts.push(alert.serverTime + ": " + alert.msg);
+    });
+    return alerts;
+  }
+
+  function stateOf(cluster) {
+    return (cluster.status && cluster.status.state) || "unknown";
+  }
+
+  function renderGrid() {
+    var grid = document.getElementById("grid");
+    clear(grid);
+    clusters.forEach(function (cluster) {
+      var nodes = cluster.node || [];
+      var series = clusterHistory[cluster.status.name] || {};
+      var card = el("div", { "class": "card state-" + stateOf(cluster) + (selected === cluster.status.name ? " selected" : "") }, [
+        el("h2", {}, [cluster.status.name + " ", el("span", { "class": "badge state-" + stateOf(cluster) }, [stateOf(cluster)])]),
+        el("div", { "class": "metrics" }, [
+          el("span", {}, ["Version: " + (nodes.length ? nodes[0].version : "-")]),
+          el("span", {}, ["Nodes: " + nodes.length]),
+          el("span", {}, ["Buckets: " + (cluster.buckets || []).length]),
+          el("span", {}, ["Alerts: " + alertsOf(cluster).length]),
+          el("span", {}, ["RAM: " + pct(cluster.ramPctUsed)]),
+          el("span", {}, ["Disk: " + pct(cluster.hdPctUsed)])
+        ]),
+        el("div", {}, [sparkline(series.ram_pct_used, 210, 28, 1)])
+      ]);
+      card.addEventListener("click", function () {
+        selected = selected === cluster.status.name ? null : cluster.status.name;
+        render();
+        refreshDetail();
+      });
+      grid.appendChild(card);
+    });
+  }
+
+  function table(headers, rows) {
+    return el("table", {}, [
+      el("thead", {}, [el("tr", {}, headers.map(function (header) { return el("th", {}, [header]); }))]),
+      el("tbody", {}, rows)
+    ]);
+  }
+
+  function renderDetail(series) {
+    var detail = document.getElementById("detail");
+    clear(detail);
+    var cluster = clusters.filter(function (candidate) { return candidate.status.name === selected; })[0];
+    if (!cluster) {
+      detail.className = "detail hidden";
+      return;
+    }
+    detail.className = "detail";
+    series = series || {};
+    var status = cluster.status;
+    detail.appendChild(el("h2", {}, [status.name + " ", el("span", { "class": "badge state-" + stateOf(cluster) }, [stateOf(cluster)])]));
+    if (status.lastError) {
+      detail.appendChild(el("p", { "class": "error" }, [status.errorCategory + ": " + status.lastError + (status.stale ? " (showing stale data)" : "")]));
+    }
+    if (status.reason) {
+      detail.appendChild(el("p", {}, [status.reason]));
+    }
+    detail.appendChild(el("h3", {}, ["Nodes"]));
+    detail.appendChild(table(["Hostname", "Status", "Membership", "Version", "Services", "CPU", "Memory", "CPU history"],
+      (cluster.node || []).map(function (node) {
+        return el("tr", {}, [
+          el("td", {}, [node.hostname]),
+          el("td", {}, [node.status]),
+          el("td", {}, [node.clusterMembership]),
+          el("td", {}, [node.version]),
+          el("td", {}, [(node.services || []).join(", ")]),
+          el("td", { "class": "num" }, [(node.cpuRate || 0).toFixed(1) + "%"]),
+          el("td", { "class": "num" }, [pct(node.memPctUsed)]),
+          el("td", {}, [sparkline(series["nodes/" + node.hostname + "/cpu_rate"], 120, 20, 100)])
+        ]);
+      })));
+    detail.appendChild(el("h3", {}, ["Buckets"]));
+    detail.appendChild(table(["Name", "Type", "Replicas", "Items", "Ops/s", "Disk fetches", "Quota used", "Ops history"],
+      (cluster.buckets || []).map(function (bucket) {
+        return el("tr", {}, [
+          el("td", {}, [bucket.name]),
+          el("td", {}, [bucket.bucketType]),
+          el("td", { "class": "num" }, [bucket.replicaNumber]),
+          el("td", { "class": "num" }, [bucket.itemCount]),
+          el("td", { "class": "num" }, [bucket.opsPerSec]),
+          el("td", { "class": "num" }, [bucket.diskFetches]),
+          el("td", { "class": "num" }, [(bucket.quotaPctUsed || 0).toFixed(1) + "%"]),
+          el("td", {}, [sparkline(series["buckets/" + bucket.name + "/ops_per_sec"], 120, 20)])
+        ]);
+      })));
+    var alerts = alertsOf(cluster);
+    detail.appendChild(el("h3", {}, ["Alerts (" + alerts.length + ")"]));
+    detail.appendChild(el("ul", { "class": "alerts" }, alerts.map(function (alert) { return el("li", {}, [alert]); })));
+  }
+
+  function render() {
+    renderGrid();
+    renderDetail();
+  }
+
+  function refreshDetail() {
+    var cluster = clusters.filter(function (candidate) { return candidate.status.name === selected; })[0];
+    if (!cluster) {
+      renderDetail();
+      return;
+    }
+    var metrics = [];
+    (cluster.node || []).forEach(function (node) { metrics.push("nodes/" + node.hostname + "/cpu_rate"); });
+    (cluster.buckets || []).forEach(function (bucket) { metrics.push("buckets/" + bucket.name + "/ops_per_sec"); });
+    fetchJSON(historyURL(selected, metrics)).then(function (history) {
+      renderDetail(history.series);
+    }).catch(showError);
+  }
+
+  function showError(error) {
+    document.getElementById("error").textContent = error.message;
+  }
+
+  function refresh() {
+    fetchJSON("/").then(function (entries) {
+      clusters = entries || [];
+      document.getElementById("error").textContent = "";
+      document.getElementById("updated").textContent = "Updated " + new Date().toLocaleTimeString();
+      return Promise.all(clusters.map(function (cluster) {
+        return fetchJSON(historyURL(cluster.status.name, ["ram_pct_used"])).then(function (history) {
+          clusterHistory[cluster.status.name] = history.series;
+        });
+      }));
+    }).then(function () {
+      renderGrid();
+      refreshDetail();
+    }).catch(showError);
+  }
+
+  refresh();
+  setInterval(refresh, refreshMs);
+})();
+`
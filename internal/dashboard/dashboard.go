@@ -0,0 +1,36 @@
+package dashboard
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type asset struct {
+	mimeType string
+	content  string
+}
+
+// Handler serves the web dashboard, it must be mounted under a path ending with a slash (like
+// /dashboard/). The dashboard polls the JSON API every refresh interval
+func Handler(refresh time.Duration) http.Handler {
+	index := strings.Replace(indexHTML, "{{refreshMs}}", strconv.FormatInt(int64(refresh/time.Millisecond), 10), 1)
+	assets := map[string]asset{
+		"":           {mimeType: "text/html; charset=utf-8", content: index},
+		"index.html": {mimeType: "text/html; charset=utf-8", content: index},
+		"app.js":     {mimeType: "application/javascript; charset=utf-8", content: appJS},
+		"style.css":  {mimeType: "text/css; charset=utf-8", content: styleCSS},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		file, ok := assets[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("content-type", file.mimeType)
+		w.Header().Set("cache-control", "no-cache")
+		w.Write([]byte(file.content))
+	})
+}
@@ -0,0 +1,138 @@
+package diff
+
+import (
+	"cbmonitor/internal/monitor/stats"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	KindAdded   = "added"
+	KindRemoved = "removed"
+	KindChanged = "changed"
+
+	ScopeCluster = "cluster"
+	ScopeNode    = "node"
+	ScopeBucket  = "bucket"
+)
+
+// Change difference between two versions of the statistics of a cluster
+type Change struct {
+	Cluster string `json:"cluster"`
+	Kind    string `json:"kind"`
+	Scope   string `json:"scope"`
+	Subject string `json:"subject,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Before  string `json:"before,omitempty"`
+	After   string `json:"after,omitempty"`
+}
+
+func (c Change) String() string {
+	subject := c.Scope
+	if c.Subject != "" {
+		subject = fmt.Sprintf("%s %s", c.Scope, c.Subject)
+	}
+	if c.Kind == KindChanged {
+		return fmt.Sprintf("%s: %s %s changed from %s to %s", c.Cluster, subject, c.Field, c.Before, c.After)
+	}
+	return fmt.Sprintf("%s: %s %s", c.Cluster, subject, c.Kind)
+}
+
+type changes struct {
+	cluster string
+	list    []Change
+}
+
+func (c *changes) add(kind, scope, subject string) {
+	c.list = append(c.list, Change{Cluster: c.cluster, Kind: kind, Scope: scope, Subject: subject})
+}
+
+func (c *changes) compare(scope, subject, field string, before, after interface{}) {
+	beforeText, afterText := fmt.Sprint(before), fmt.Sprint(after)
+	if beforeText != afterText {
+		c.list = append(c.list, Change{Cluster: c.cluster, Kind: KindChanged, Scope: scope, Subject: subject,
+			Field: field, Before: beforeText, After: afterText})
+	}
+}
+
+// Versions lists the distinct versions of the nodes, sorted and comma separated
+func Versions(nodes []stats.Node) string {
+	unique := make(map[string]bool)
+	for _, node := range nodes {
+		unique[node.Version] = true
+	}
+	list := make([]string, 0, len(unique))
+	for version := range unique {
+		list = append(list, version)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+// Services lists the services of the node, sorted and comma separated
+func Services(node stats.Node) string {
+	list := append([]string{}, node.Services...)
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+// Clusters lists the node, version and quota changes between two versions of the statistics of a
+// cluster, their buckets are compared by Buckets
+func Clusters(cluster string, before, after stats.ClusterStats) []Change {
+	found := &changes{cluster: cluster, list: []Change{}}
+	found.compare(ScopeCluster, "", "versions", Versions(before.Nodes), Versions(after.Nodes))
+	found.compare(ScopeCluster, "", "memoryQuota", before.MemoryQuotaMb, after.MemoryQuotaMb)
+	found.compare(ScopeCluster, "", "indexMemoryQuota", before.IndexMemoryQuotaMb, after.IndexMemoryQuotaMb)
+	found.compare(ScopeCluster, "", "ftsMemoryQuota", before.FTSMemoryQuotaMb, after.FTSMemoryQuotaMb)
+
+	beforeNodes := make(map[string]stats.Node, len(before.Nodes))
+	for _, node := range before.Nodes {
+		beforeNodes[node.Hostname] = node
+	}
+	afterNodes := make(map[string]bool, len(after.Nodes))
+	for _, node := range after.Nodes {
+		afterNodes[node.Hostname] = true
+		previous, ok := beforeNodes[node.Hostname]
+		if !ok {
+			found.add(KindAdded, ScopeNode, node.Hostname)
+			continue
+		}
+		found.compare(ScopeNode, node.Hostname, "version", previous.Version, node.Version)
+		found.compare(ScopeNode, node.Hostname, "services", Services(previous), Services(node))
+	}
+	for _, node := range before.Nodes {
+		if !afterNodes[node.Hostname] {
+			found.add(KindRemoved, ScopeNode, node.Hostname)
+		}
+	}
+	return found.list
+}
+
+// Buckets lists the added and removed buckets and the type, replica and quota changes between two lists
+// of buckets of a cluster
+func Buckets(cluster string, before, after []stats.Bucket) []Change {
+	found := &changes{cluster: cluster, list: []Change{}}
+	beforeBuckets := make(map[string]stats.Bucket, len(before))
+	for _, bucket := range before {
+		beforeBuckets[bucket.Name] = bucket
+	}
+	afterBuckets := make(map[string]bool, len(after))
+	for _, bucket := range after {
+		afterBuckets[bucket.Name] = true
+		previous, ok := beforeBuckets[bucket.Name]
+		if !ok {
+			found.add(KindAdded, ScopeBucket, bucket.Name)
+			continue
+		}
+		found.compare(ScopeBucket, bucket.Name, "bucketType", previous.BucketType, bucket.BucketType)
+		found.compare(ScopeBucket, bucket.Name, "replicaNumber", previous.ReplicaNumber, bucket.ReplicaNumber)
+		found.compare(ScopeBucket, bucket.Name, "ramQuotaMb", previous.RAMQuotaMb, bucket.RAMQuotaMb)
+	}
+	for _, bucket := range before {
+		if !afterBuckets[bucket.Name] {
+			found.add(KindRemoved, ScopeBucket, bucket.Name)
+		}
+	}
+	return found.list
+}
@@ -0,0 +1,118 @@
+package diff
+
+import (
+	"cbmonitor/internal/monitor/stats"
+	"strings"
+	"testing"
+)
+
+// describe joins the descriptions of the changes, one per line
+func describe(changes []Change) string {
+	lines := make([]string, len(changes))
+	for i, change := range changes {
+		lines[i] = change.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestClusters(t *testing.T) {
+	node := func(hostname, version string, services ...string) stats.Node {
+		return stats.Node{Hostname: hostname, Version: version, Services: services}
+	}
+	base := stats.ClusterStats{MemoryQuotaMb: 1024, IndexMemoryQuotaMb: 512, FTSMemoryQuotaMb: 256}
+	base.Nodes = []stats.Node{node("n1", "7.2.0", "kv", "index"), node("n2", "7.2.0", "kv")}
+	tests := []struct {
+		name   string
+		change func(*stats.ClusterStats)
+		want   string
+	}{
+		{name: "identical", change: func(*stats.ClusterStats) {}},
+		{name: "services order", change: func(c *stats.ClusterStats) {
+			c.Nodes = []stats.Node{node("n1", "7.2.0", "index", "kv"), node("n2", "7.2.0", "kv")}
+		}},
+		{name: "nodes order", change: func(c *stats.ClusterStats) {
+			c.Nodes = []stats.Node{c.Nodes[1], c.Nodes[0]}
+		}},
+		{name: "quotas", change: func(c *stats.ClusterStats) {
+			c.MemoryQuotaMb = 2048
+			c.FTSMemoryQuotaMb = 0
+		}, want: "east: cluster memoryQuota changed from 1024 to 2048\n" +
+			"east: cluster ftsMemoryQuota changed from 256 to 0"},
+		{name: "upgrade", change: func(c *stats.ClusterStats) {
+			c.Nodes = []stats.Node{node("n1", "7.6.0", "kv", "index"), node("n2", "7.2.0", "kv", "query")}
+		}, want: "east: cluster versions changed from 7.2.0 to 7.2.0,7.6.0\n" +
+			"east: node n1 version changed from 7.2.0 to 7.6.0\n" +
+			"east: node n2 services changed from kv to kv,query"},
+		{name: "swap", change: func(c *stats.ClusterStats) {
+			c.Nodes = []stats.Node{node("n1", "7.2.0", "kv", "index"), node("n3", "7.2.0", "kv")}
+		}, want: "east: node n3 added\neast: node n2 removed"},
+	}
+	for _, test := range tests {
+		after := base
+		after.Nodes = append([]stats.Node{}, base.Nodes...)
+		test.change(&after)
+		if got := describe(Clusters("east", base, after)); got != test.want {
+			t.Errorf("%s: Clusters() =\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestClustersIgnoresBuckets(t *testing.T) {
+	before, after := stats.ClusterStats{}, stats.ClusterStats{}
+	after.Buckets = []stats.Bucket{{Name: "orders"}}
+	if changes := Clusters("east", before, after); len(changes) > 0 {
+		t.Errorf("Clusters() = %v, want the buckets left to Buckets", changes)
+	}
+}
+
+func TestBuckets(t *testing.T) {
+	before := []stats.Bucket{
+		{Name: "orders", BucketType: "membase", ReplicaNumber: 1, RAMQuotaMb: 512},
+		{Name: "sessions", BucketType: "ephemeral", ReplicaNumber: 0, RAMQuotaMb: 128},
+	}
+	tests := []struct {
+		name  string
+		after []stats.Bucket
+		want  string
+	}{
+		{name: "identical", after: before},
+		{name: "statistics only", after: []stats.Bucket{
+			{Name: "sessions", BucketType: "ephemeral", RAMQuotaMb: 128, ItemCount: 42},
+			{Name: "orders", BucketType: "membase", ReplicaNumber: 1, RAMQuotaMb: 512, OpsPerSec: 300},
+		}},
+		{name: "settings", after: []stats.Bucket{
+			{Name: "orders", BucketType: "membase", ReplicaNumber: 2, RAMQuotaMb: 1024},
+			{Name: "sessions", BucketType: "membase", ReplicaNumber: 0, RAMQuotaMb: 128},
+		}, want: "east: bucket orders replicaNumber changed from 1 to 2\n" +
+			"east: bucket orders ramQuotaMb changed from 512 to 1024\n" +
+			"east: bucket sessions bucketType changed from ephemeral to membase"},
+		{name: "added and removed", after: []stats.Bucket{before[0], {Name: "users", BucketType: "membase"}},
+			want: "east: bucket users added\neast: bucket sessions removed"},
+		{name: "all removed", after: nil, want: "east: bucket orders removed\neast: bucket sessions removed"},
+	}
+	for _, test := range tests {
+		if got := describe(Buckets("east", before, test.after)); got != test.want {
+			t.Errorf("%s: Buckets() =\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestVersions(t *testing.T) {
+	tests := []struct {
+		versions []string
+		want     string
+	}{
+		{versions: nil, want: ""},
+		{versions: []string{"7.2.0", "7.2.0"}, want: "7.2.0"},
+		{versions: []string{"7.6.0", "7.2.0", "7.6.0"}, want: "7.2.0,7.6.0"},
+	}
+	for _, test := range tests {
+		nodes := make([]stats.Node, len(test.versions))
+		for i, version := range test.versions {
+			nodes[i].Version = version
+		}
+		if got := Versions(nodes); got != test.want {
+			t.Errorf("Versions(%v) = %q, want %q", test.versions, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package election
+
+import (
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/metrics"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// lease content of the lock file: the replica holding it, the URL of its API and until when
+type lease struct {
+	Holder  string    `json:"holder"`
+	URL     string    `json:"url,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// Status leadership of the replica, reported by the self status endpoint
+type Status struct {
+	ID        string     `json:"id"`
+	Leader    bool       `json:"leader"`
+	LeaderID  string     `json:"leaderId,omitempty"`
+	LeaderURL string     `json:"leaderUrl,omitempty"`
+	Expires   *time.Time `json:"expires,omitempty"`
+	LastError string     `json:"lastError,omitempty"`
+}
+
+// Elector elects the leader among the replicas sharing a lock file. The leader renews its lease every
+// third of the lease period, the others take the lock over once the lease expires. Without lock file the
+// replica is always the leader. Replicas compare the lease expiration with their own clock, their clocks
+// must be synchronized well within the lease period
+type Elector struct {
+	config config.HA
+	status Status
+	mu     sync.Mutex
+}
+
+// NewElector creates an elector for the given (normalized) settings, leadership is exposed by the
+// cbmonitor_leader self metric
+func NewElector(settings config.HA) *Elector {
+	e := &Elector{config: settings, status: Status{ID: settings.ID, Leader: !settings.Enabled()}}
+	if settings.Enabled() {
+		metrics.NewGaugeFunc("cbmonitor_leader", "Whether this replica is the leader (1) or a standby (0)",
+			[]string{"id"}, func() []metrics.GaugeValue {
+				value := 0.0
+				if e.IsLeader() {
+					value = 1
+				}
+				return []metrics.GaugeValue{{LabelValues: []string{settings.ID}, Value: value}}
+			})
+	}
+	return e
+}
+
+// Enabled tells whether leader election is configured
+func (e *Elector) Enabled() bool {
+	return e.config.Enabled()
+}
+
+// IsLeader tells whether the replica currently leads
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.status.Leader
+}
+
+// Status returns the leadership of the replica
+func (e *Elector) Status() Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	status := e.status
+	if status.Expires != nil {
+		expires := *status.Expires
+		status.Expires = &expires
+	}
+	return status
+}
+
+// Run campaigns until stop is closed, the leader then releases the lock so that another replica takes
+// over without waiting for the lease to expire
+func (e *Elector) Run(stop <-chan struct{}) {
+	if !e.Enabled() {
+		return
+	}
+	e.campaign(time.Now())
+	ticker := time.NewTicker(e.config.Lease.Duration / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			e.release()
+			return
+		case now := <-ticker.C:
+			e.campaign(now)
+		}
+	}
+}
+
+// campaign renews the lease of the leader or acquires the lock when it is free or expired
+func (e *Elector) campaign(now time.Time) {
+	current, err := e.read()
+	switch {
+	case err == nil && current.Holder == e.config.ID && now.Before(current.Expires):
+		err = e.renew(now)
+	case err == nil && current.Holder != e.config.ID && now.Before(current.Expires):
+		e.update(false, current, nil)
+		return
+	case err == nil:
+		err = e.takeOver(now, current)
+	case os.IsNotExist(err):
+		err = e.acquire(now)
+	}
+	if err == nil {
+		return
+	}
+	e.mu.Lock()
+	leader := e.status.Leader && e.status.Expires != nil && now.Before(*e.status.Expires)
+	e.mu.Unlock()
+	e.update(leader, current, err)
+}
+
+// update records the outcome of a campaign and logs the leadership changes
+func (e *Elector) update(leader bool, current lease, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if leader != e.status.Leader {
+		if leader {
+			slog.Info("Became the leader", "id", e.config.ID, "lease", e.config.Lease)
+		} else {
+			slog.Warn("Not the leader anymore", "id", e.config.ID, "leader", current.Holder)
+		}
+	}
+	e.status.Leader = leader
+	if !leader {
+		e.status.LeaderID = current.Holder
+		e.status.LeaderURL = current.URL
+		e.status.Expires = nil
+		if !current.Expires.IsZero() {
+			expires := current.Expires
+			e.status.Expires = &expires
+		}
+	}
+	e.status.LastError = ""
+	if err != nil {
+		e.status.LastError = err.Error()
+		slog.Error("Leader election failed", "id", e.config.ID, "lock", e.config.LockFile, "error", err)
+	}
+}
+
+// lead records the lease held by the replica
+func (e *Elector) lead(held lease) {
+	e.update(true, held, nil)
+	e.mu.Lock()
+	e.status.LeaderID = held.Holder
+	e.status.LeaderURL = held.URL
+	e.status.Expires = &held.Expires
+	e.mu.Unlock()
+}
+
+// acquire creates the lock file, the replica is a standby when another one created it first
+func (e *Elector) acquire(now time.Time) error {
+	held := lease{Holder: e.config.ID, URL: e.config.URL, Expires: now.Add(e.config.Lease.Duration)}
+	content, _ := json.Marshal(held)
+	file, err := os.OpenFile(e.config.LockFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		current, _ := e.read()
+		e.update(false, current, nil)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot create lock file: %w", err)
+	}
+	_, err = file.Write(content)
+	if syncErr := file.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(e.config.LockFile)
+		return fmt.Errorf("cannot write lock file: %w", err)
+	}
+	e.lead(held)
+	return nil
+}
+
+// takeOver moves the expired lock aside and acquires it. Renaming is atomic: when several replicas take
+// over at once only one moves the expired lock, the others find it gone. A lock acquired by another
+// replica in the meantime is put back
+func (e *Elector) takeOver(now time.Time, expired lease) error {
+	aside := e.config.LockFile + "." + e.config.ID + ".expired"
+	if err := os.Rename(e.config.LockFile, aside); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("cannot take the expired lock over: %w", err)
+	}
+	defer os.Remove(aside)
+	if moved, err := readLease(aside, e.config.Lease.Duration); err != nil || moved.Holder != expired.Holder || !moved.Expires.Equal(expired.Expires) {
+		if err := os.Link(aside, e.config.LockFile); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("cannot put the lock back: %w", err)
+		}
+		return nil
+	}
+	slog.Info("Taking the expired lock over", "id", e.config.ID, "previous", expired.Holder,
+		"expired", expired.Expires)
+	return e.acquire(now)
+}
+
+// renew extends the lease of the leader. The lock is moved aside first, as for a take over, so that the
+// replica proves it still holds it: a lock taken over by another replica is put back and the replica
+// steps down. The lease is then written to a new lock file, which another replica may create first
+func (e *Elector) renew(now time.Time) error {
+	aside := e.config.LockFile + "." + e.config.ID + ".renewing"
+	if err := os.Rename(e.config.LockFile, aside); err != nil {
+		if os.IsNotExist(err) {
+			e.update(false, lease{}, nil)
+			return nil
+		}
+		return fmt.Errorf("cannot renew lease: %w", err)
+	}
+	defer os.Remove(aside)
+	if moved, err := readLease(aside, e.config.Lease.Duration); err != nil || moved.Holder != e.config.ID {
+		if err := os.Link(aside, e.config.LockFile); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("cannot put the lock back: %w", err)
+		}
+		e.update(false, moved, nil)
+		return nil
+	}
+	return e.acquire(now)
+}
+
+// release removes the lock file when the replica still holds it
+func (e *Elector) release() {
+	if !e.IsLeader() {
+		return
+	}
+	current, err := e.read()
+	if err == nil && current.Holder == e.config.ID {
+		if err := os.Remove(e.config.LockFile); err != nil {
+			slog.Error("Cannot release the lock", "lock", e.config.LockFile, "error", err)
+		} else {
+			slog.Info("Lock released", "id", e.config.ID, "lock", e.config.LockFile)
+		}
+	}
+	e.mu.Lock()
+	e.status = Status{ID: e.config.ID}
+	e.mu.Unlock()
+}
+
+// read decodes the lock file
+func (e *Elector) read() (lease, error) {
+	return readLease(e.config.LockFile, e.config.Lease.Duration)
+}
+
+// readLease decodes a lock file. A lock file that cannot be decoded, being written or left empty by a
+// crash, is held by nobody and expires a lease period after its last modification
+func readLease(file string, period time.Duration) (lease, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return lease{}, err
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return lease{}, err
+	}
+	var current lease
+	if err := json.Unmarshal(content, &current); err != nil || current.Holder == "" {
+		return lease{Expires: info.ModTime().Add(period)}, nil
+	}
+	return current, nil
+}
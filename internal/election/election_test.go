@@ -0,0 +1,226 @@
+package election
+
+import (
+	"cbmonitor/internal/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testLease = 9 * time.Second
+
+var start = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+func newTestElector(lockFile, id string) *Elector {
+	return NewElector(config.HA{
+		LockFile: lockFile,
+		ID:       id,
+		URL:      "http://" + id + ":3000",
+		Lease:    config.Duration{Duration: testLease},
+	})
+}
+
+// lockHolder returns the lease of the lock file, an empty one without lock file
+func lockHolder(t *testing.T, lockFile string) lease {
+	t.Helper()
+	current, err := readLease(lockFile, testLease)
+	if os.IsNotExist(err) {
+		return lease{}
+	}
+	if err != nil {
+		t.Fatalf("readLease() = %v", err)
+	}
+	return current
+}
+
+// leftovers returns the files of the lock directory besides the lock file
+func leftovers(t *testing.T, lockFile string) []string {
+	t.Helper()
+	files, err := ioutil.ReadDir(filepath.Dir(lockFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, file := range files {
+		if file.Name() != filepath.Base(lockFile) {
+			names = append(names, file.Name())
+		}
+	}
+	return names
+}
+
+func TestAcquire(t *testing.T) {
+	lockFile := filepath.Join(t.TempDir(), "leader.lock")
+	a := newTestElector(lockFile, "a")
+	a.campaign(start)
+	if !a.IsLeader() {
+		t.Fatalf("a is not the leader of a free lock")
+	}
+	held := lockHolder(t, lockFile)
+	if held.Holder != "a" || held.URL != "http://a:3000" || !held.Expires.Equal(start.Add(testLease)) {
+		t.Errorf("lock holds %+v, want a until %s", held, start.Add(testLease))
+	}
+	status := a.Status()
+	if status.LeaderID != "a" || status.LeaderURL != "http://a:3000" || status.LastError != "" {
+		t.Errorf("Status() = %+v, want a leading", status)
+	}
+}
+
+func TestStandbyWaitsForLease(t *testing.T) {
+	lockFile := filepath.Join(t.TempDir(), "leader.lock")
+	a, b := newTestElector(lockFile, "a"), newTestElector(lockFile, "b")
+	a.campaign(start)
+	b.campaign(start.Add(time.Second))
+	if b.IsLeader() {
+		t.Fatalf("b leads while the lease of a is valid")
+	}
+	status := b.Status()
+	if status.LeaderID != "a" || status.LeaderURL != "http://a:3000" {
+		t.Errorf("b Status() = %+v, want a as leader", status)
+	}
+	if holder := lockHolder(t, lockFile).Holder; holder != "a" {
+		t.Errorf("lock held by %q, want a", holder)
+	}
+}
+
+func TestRenew(t *testing.T) {
+	lockFile := filepath.Join(t.TempDir(), "leader.lock")
+	a := newTestElector(lockFile, "a")
+	for i := 0; i < 4; i++ {
+		a.campaign(start.Add(time.Duration(i) * testLease / 3))
+	}
+	renewed := start.Add(testLease + testLease)
+	if held := lockHolder(t, lockFile); held.Holder != "a" || !held.Expires.Equal(renewed) {
+		t.Errorf("lock holds %+v, want a until %s", held, renewed)
+	}
+	if !a.IsLeader() {
+		t.Errorf("a stopped leading while renewing")
+	}
+	if files := leftovers(t, lockFile); len(files) > 0 {
+		t.Errorf("renewals left %v", files)
+	}
+}
+
+func TestTakeOverExpiredLease(t *testing.T) {
+	lockFile := filepath.Join(t.TempDir(), "leader.lock")
+	a, b := newTestElector(lockFile, "a"), newTestElector(lockFile, "b")
+	a.campaign(start)
+	expired := start.Add(testLease + time.Second)
+	b.campaign(expired)
+	if !b.IsLeader() {
+		t.Fatalf("b did not take the expired lock over")
+	}
+	if holder := lockHolder(t, lockFile).Holder; holder != "b" {
+		t.Errorf("lock held by %q, want b", holder)
+	}
+	a.campaign(expired.Add(time.Second))
+	if a.IsLeader() {
+		t.Errorf("a still leads after b took over")
+	}
+	if files := leftovers(t, lockFile); len(files) > 0 {
+		t.Errorf("take over left %v", files)
+	}
+}
+
+func TestRenewAfterTakeOver(t *testing.T) {
+	lockFile := filepath.Join(t.TempDir(), "leader.lock")
+	a, b := newTestElector(lockFile, "a"), newTestElector(lockFile, "b")
+	a.campaign(start)
+	// a read its lease before pausing past its expiration, b took the lock over in the meantime
+	b.campaign(start.Add(testLease + time.Second))
+	if err := a.renew(start.Add(testLease + 2*time.Second)); err != nil {
+		t.Fatalf("renew() = %v", err)
+	}
+	if a.IsLeader() {
+		t.Errorf("a renewed the lock taken over by b")
+	}
+	if holder := lockHolder(t, lockFile).Holder; holder != "b" {
+		t.Errorf("lock held by %q, want b", holder)
+	}
+	if status := a.Status(); status.LeaderID != "b" {
+		t.Errorf("a Status() = %+v, want b as leader", status)
+	}
+}
+
+func TestRacingReplicas(t *testing.T) {
+	lockFile := filepath.Join(t.TempDir(), "leader.lock")
+	newTestElector(lockFile, "previous").campaign(start)
+	replicas := make([]*Elector, 8)
+	for i := range replicas {
+		replicas[i] = newTestElector(lockFile, string(rune('a'+i)))
+	}
+	expired := start.Add(testLease + time.Second)
+	for round := 0; round < 3; round++ {
+		var campaigns sync.WaitGroup
+		for _, replica := range replicas {
+			campaigns.Add(1)
+			go func(replica *Elector) {
+				defer campaigns.Done()
+				replica.campaign(expired.Add(time.Duration(round) * testLease / 3))
+			}(replica)
+		}
+		campaigns.Wait()
+		leaders := []string{}
+		for _, replica := range replicas {
+			if replica.IsLeader() {
+				leaders = append(leaders, replica.config.ID)
+			}
+		}
+		if len(leaders) != 1 {
+			t.Fatalf("round %d: leaders %v, want exactly one", round, leaders)
+		}
+		if holder := lockHolder(t, lockFile).Holder; holder != leaders[0] {
+			t.Fatalf("round %d: lock held by %q, leader is %q", round, holder, leaders[0])
+		}
+	}
+}
+
+func TestRelease(t *testing.T) {
+	lockFile := filepath.Join(t.TempDir(), "leader.lock")
+	a, b := newTestElector(lockFile, "a"), newTestElector(lockFile, "b")
+	a.campaign(start)
+	b.campaign(start)
+	b.release()
+	if holder := lockHolder(t, lockFile).Holder; holder != "a" {
+		t.Fatalf("standby release removed the lock of a, held by %q", holder)
+	}
+	a.release()
+	if a.IsLeader() {
+		t.Errorf("a still leads after releasing the lock")
+	}
+	if _, err := os.Stat(lockFile); !os.IsNotExist(err) {
+		t.Fatalf("lock file still exists after release: %v", err)
+	}
+	b.campaign(start.Add(time.Second))
+	if !b.IsLeader() {
+		t.Errorf("b did not acquire the released lock before the lease expired")
+	}
+}
+
+func TestUnreadableLock(t *testing.T) {
+	lockFile := filepath.Join(t.TempDir(), "leader.lock")
+	if err := ioutil.WriteFile(lockFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	written := time.Now()
+	a := newTestElector(lockFile, "a")
+	a.campaign(written)
+	if a.IsLeader() {
+		t.Fatalf("a took an empty lock over before it expired")
+	}
+	a.campaign(written.Add(testLease + time.Second))
+	if !a.IsLeader() {
+		t.Errorf("a did not take the expired empty lock over")
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	e := NewElector(config.HA{})
+	if e.Enabled() || !e.IsLeader() {
+		t.Errorf("without lock file Enabled() = %v and IsLeader() = %v, want false and true", e.Enabled(),
+			e.IsLeader())
+	}
+}
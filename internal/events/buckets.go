@@ -0,0 +1,85 @@
+package events
+
+import (
+	"cbmonitor/internal/alerts"
+	"cbmonitor/internal/diff"
+	"cbmonitor/internal/monitor/stats"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// bucketState settings of a bucket compared between scrapes
+type bucketState struct {
+	BucketType    string `json:"bucketType"`
+	ReplicaNumber int    `json:"replicaNumber"`
+	RAMQuotaMb    int64  `json:"ramQuotaMb"`
+}
+
+func (b bucketState) bucket(name string) stats.Bucket {
+	return stats.Bucket{Name: name, BucketType: b.BucketType, ReplicaNumber: b.ReplicaNumber, RAMQuotaMb: b.RAMQuotaMb}
+}
+
+// bucketChanges returns the events of the buckets created, deleted or whose settings changed since the
+// previous complete scrape of the cluster. The first scrape of a cluster only records its buckets
+func (j *Journal) bucketChanges(cluster string, collected time.Time, buckets []stats.Bucket) []Event {
+	current := make(map[string]bucketState, len(buckets))
+	for _, bucket := range buckets {
+		current[bucket.Name] = bucketState{
+			BucketType:    bucket.BucketType,
+			ReplicaNumber: bucket.ReplicaNumber,
+			RAMQuotaMb:    bucket.RAMQuotaMb,
+		}
+	}
+	previous, known := j.buckets[cluster]
+	j.buckets[cluster] = current
+	detected := []Event{}
+	if !known {
+		j.dirty = true
+		return detected
+	}
+	before := make([]stats.Bucket, 0, len(previous))
+	for name, state := range previous {
+		before = append(before, state.bucket(name))
+	}
+	after := make([]stats.Bucket, 0, len(current))
+	for name, state := range current {
+		after = append(after, state.bucket(name))
+	}
+	changed := map[string][]Change{}
+	for _, change := range diff.Buckets(cluster, before, after) {
+		switch change.Kind {
+		case diff.KindAdded:
+			detected = append(detected, bucketEvent(KindBucketCreated, alerts.SeverityOK, change.Subject, collected,
+				fmt.Sprintf("Bucket %s was created", change.Subject)))
+		case diff.KindRemoved:
+			detected = append(detected, bucketEvent(KindBucketDeleted, alerts.SeverityWarning, change.Subject,
+				collected, fmt.Sprintf("Bucket %s was deleted", change.Subject)))
+		case diff.KindChanged:
+			changed[change.Subject] = append(changed[change.Subject],
+				Change{Field: change.Field, Before: change.Before, After: change.After})
+		}
+	}
+	for name, changes := range changed {
+		descriptions := make([]string, len(changes))
+		for i, change := range changes {
+			descriptions[i] = fmt.Sprintf("%s from %s to %s", change.Field, change.Before, change.After)
+		}
+		event := bucketEvent(KindBucketChanged, alerts.SeverityWarning, name, collected,
+			fmt.Sprintf("Bucket %s settings changed: %s", name, strings.Join(descriptions, ", ")))
+		event.Changes = changes
+		detected = append(detected, event)
+	}
+	sort.SliceStable(detected, func(i, k int) bool {
+		return detected[i].Bucket < detected[k].Bucket
+	})
+	if len(detected) > 0 {
+		j.dirty = true
+	}
+	return detected
+}
+
+func bucketEvent(kind string, severity alerts.Severity, bucket string, collected time.Time, message string) Event {
+	return Event{Kind: kind, Severity: severity, Message: message, Time: collected, Bucket: bucket}
+}
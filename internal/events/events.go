@@ -0,0 +1,299 @@
+package events
+
+import (
+	"cbmonitor/internal/alerts"
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/monitor"
+	"cbmonitor/internal/storage"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Event kinds
+const (
+	// KindServerAlert alert raised by Couchbase itself
+	KindServerAlert = "server_alert"
+	// KindNodeJoined node added to the cluster
+	KindNodeJoined = "node_joined"
+	// KindNodeLeft node removed from the cluster
+	KindNodeLeft = "node_left"
+	// KindNodeFailedOver node failed over, its membership became inactiveFailed
+	KindNodeFailedOver = "node_failed_over"
+	// KindNodeStatus node status changed, from or back to healthy
+	KindNodeStatus = "node_status_changed"
+	// KindNodeRestarted node uptime decreased
+	KindNodeRestarted = "node_restarted"
+	// KindNodeUpgraded node version changed
+	KindNodeUpgraded = "node_upgraded"
+	// KindBucketCreated bucket added to the cluster
+	KindBucketCreated = "bucket_created"
+	// KindBucketDeleted bucket removed from the cluster
+	KindBucketDeleted = "bucket_deleted"
+	// KindBucketChanged bucket type, replica number or RAM quota changed
+	KindBucketChanged = "bucket_changed"
+)
+
+const (
+	documentName = "events"
+	stateVersion = 1
+)
+
+// Event change detected on a cluster between scrapes. Time is when it happened, the server time when
+// Couchbase tells it, Detected the time of the scrape it was detected in
+type Event struct {
+	ID       int64           `json:"id"`
+	Cluster  string          `json:"cluster"`
+	Kind     string          `json:"kind"`
+	Severity alerts.Severity `json:"severity"`
+	Message  string          `json:"message"`
+	Time     time.Time       `json:"time"`
+	Detected time.Time       `json:"detected"`
+	Node     string          `json:"node,omitempty"`
+	Bucket   string          `json:"bucket,omitempty"`
+	Changes  []Change        `json:"changes,omitempty"`
+}
+
+// Change value of a setting or attribute before and after an event
+type Change struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// Alert converts the event into the alert announcing it, named after its kind
+func (e Event) Alert() alerts.Alert {
+	return alerts.Alert{
+		Cluster:  e.Cluster,
+		Type:     alerts.TypeEvent,
+		Name:     e.Kind,
+		Severity: e.Severity,
+		Message:  e.Message,
+		Bucket:   e.Bucket,
+		Node:     e.Node,
+	}
+}
+
+// Query selects events: those of Clusters (all without), of Kinds, Nodes and Buckets (any without) and that
+// happened between From and To (zero times leave the range open)
+type Query struct {
+	Clusters []string
+	Kinds    []string
+	Nodes    []string
+	Buckets  []string
+	From     time.Time
+	To       time.Time
+}
+
+func (q Query) matches(event Event) bool {
+	if !q.From.IsZero() && event.Time.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && event.Time.After(q.To) {
+		return false
+	}
+	return (len(q.Clusters) == 0 || contains(q.Clusters, event.Cluster)) &&
+		(len(q.Kinds) == 0 || contains(q.Kinds, event.Kind)) &&
+		(len(q.Nodes) == 0 || contains(q.Nodes, event.Node)) &&
+		(len(q.Buckets) == 0 || contains(q.Buckets, event.Bucket))
+}
+
+// cursor server alerts of a cluster already turned into events: the latest server time and the alerts
+// listed by the previous scrape
+type cursor struct {
+	Time time.Time `json:"time"`
+	Seen []string  `json:"seen"`
+}
+
+// state persisted events, cursors, and nodes and buckets of the previous scrape of every cluster
+type state struct {
+	Version int                               `json:"version"`
+	LastID  int64                             `json:"lastId"`
+	Events  []Event                           `json:"events"`
+	Cursors map[string]*cursor                `json:"cursors"`
+	Nodes   map[string]map[string]nodeState   `json:"nodes,omitempty"`
+	Buckets map[string]map[string]bucketState `json:"buckets,omitempty"`
+}
+
+// Journal detects the events of every scrape and keeps them for the retention. New events are kept
+// pending until they are taken to be announced
+type Journal struct {
+	config  config.Events
+	store   *storage.Store
+	events  []Event
+	pending []Event
+	cursors map[string]*cursor
+	nodes   map[string]map[string]nodeState
+	buckets map[string]map[string]bucketState
+	lastID  int64
+	dirty   bool
+	mu      sync.Mutex
+}
+
+// NewJournal creates a journal for the given (normalized) settings, the events and cursors saved in the
+// store are loaded back so that restarts do not detect the same events again
+func NewJournal(settings config.Events, store *storage.Store) (*Journal, error) {
+	j := &Journal{
+		config:  settings,
+		store:   store,
+		events:  []Event{},
+		cursors: map[string]*cursor{},
+		nodes:   map[string]map[string]nodeState{},
+		buckets: map[string]map[string]bucketState{},
+	}
+	var saved state
+	found, err := store.Load(documentName, &saved)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load events: %w", err)
+	}
+	if found && saved.Version == stateVersion {
+		if saved.Events != nil {
+			j.events = saved.Events
+		}
+		if saved.Cursors != nil {
+			j.cursors = saved.Cursors
+		}
+		if saved.Nodes != nil {
+			j.nodes = saved.Nodes
+		}
+		if saved.Buckets != nil {
+			j.buckets = saved.Buckets
+		}
+		j.lastID = saved.LastID
+		slog.Info("Events loaded", "file", store.Path(documentName), "events", len(j.events))
+	}
+	return j, nil
+}
+
+// Add detects the events of a scrape result, failed scrapes are skipped. The buckets are only compared
+// after complete scrapes, they may be missing from partial ones
+func (j *Journal) Add(info monitor.ClusterInfo) error {
+	if info.Err != nil && !info.Partial() {
+		return nil
+	}
+	cluster, collected := info.Name, info.Time
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	detected := j.serverAlerts(cluster, collected, info.Stats.Alerts.Cluster)
+	detected = append(detected, j.nodeChanges(cluster, collected, info.Stats.Nodes)...)
+	if info.Err == nil {
+		detected = append(detected, j.bucketChanges(cluster, collected, info.Stats.Buckets)...)
+	}
+	for _, event := range detected {
+		j.lastID++
+		event.ID = j.lastID
+		event.Cluster = cluster
+		event.Detected = collected
+		j.events = append(j.events, event)
+		j.pending = append(j.pending, event)
+		j.dirty = true
+	}
+	j.prune(collected)
+	return nil
+}
+
+// Forget drops what was last seen of the clusters, when they are not monitored by this instance anymore:
+// a cluster owned again starts over as on its first scrape. Their events are kept
+func (j *Journal) Forget(clusters []string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, cluster := range clusters {
+		delete(j.cursors, cluster)
+		delete(j.nodes, cluster)
+		delete(j.buckets, cluster)
+		j.dirty = true
+	}
+}
+
+// prune forgets the events detected before the retention
+func (j *Journal) prune(now time.Time) {
+	limit := now.Add(-j.config.Retention.Duration)
+	kept := j.events[:0]
+	for _, event := range j.events {
+		if event.Detected.After(limit) {
+			kept = append(kept, event)
+		}
+	}
+	if len(kept) != len(j.events) {
+		j.events = kept
+		j.dirty = true
+	}
+}
+
+// Take returns the events detected since the previous call
+func (j *Journal) Take() []Event {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	taken := j.pending
+	j.pending = nil
+	return taken
+}
+
+// Events returns the events selected by the query, the latest first
+func (j *Journal) Events(query Query) []Event {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	selected := []Event{}
+	for _, event := range j.events {
+		if query.matches(event) {
+			selected = append(selected, event)
+		}
+	}
+	sort.SliceStable(selected, func(i, k int) bool {
+		if !selected[i].Time.Equal(selected[k].Time) {
+			return selected[i].Time.After(selected[k].Time)
+		}
+		return selected[i].ID > selected[k].ID
+	})
+	return selected
+}
+
+// Save persists the events and cursors when they changed since the last save
+func (j *Journal) Save() error {
+	if !j.store.Enabled() {
+		return nil
+	}
+	j.mu.Lock()
+	if !j.dirty {
+		j.mu.Unlock()
+		return nil
+	}
+	saved := state{
+		Version: stateVersion,
+		LastID:  j.lastID,
+		Events:  append([]Event{}, j.events...),
+		Cursors: make(map[string]*cursor, len(j.cursors)),
+		Nodes:   make(map[string]map[string]nodeState, len(j.nodes)),
+		Buckets: make(map[string]map[string]bucketState, len(j.buckets)),
+	}
+	for cluster, position := range j.cursors {
+		copied := *position
+		saved.Cursors[cluster] = &copied
+	}
+	for cluster, nodes := range j.nodes {
+		saved.Nodes[cluster] = nodes
+	}
+	for cluster, buckets := range j.buckets {
+		saved.Buckets[cluster] = buckets
+	}
+	j.dirty = false
+	j.mu.Unlock()
+	if err := j.store.Save(documentName, saved); err != nil {
+		j.mu.Lock()
+		j.dirty = true
+		j.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package events
+
+import (
+	"cbmonitor/internal/alerts"
+	"cbmonitor/internal/monitor/stats"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	healthyStatus    = "healthy"
+	failedMembership = "inactiveFailed"
+)
+
+// nodeState attributes of a node compared between scrapes
+type nodeState struct {
+	Status     string `json:"status"`
+	Membership string `json:"membership"`
+	Version    string `json:"version"`
+	Uptime     int64  `json:"uptime"`
+}
+
+// nodeChanges returns the events of the nodes that joined, left or changed since the previous scrape of
+// the cluster. The first scrape of a cluster only records its nodes
+func (j *Journal) nodeChanges(cluster string, collected time.Time, nodes []stats.Node) []Event {
+	current := make(map[string]nodeState, len(nodes))
+	for _, node := range nodes {
+		current[node.Hostname] = nodeState{
+			Status:     node.Status,
+			Membership: node.ClusterMembership,
+			Version:    node.Version,
+			Uptime:     node.UptimeSeconds,
+		}
+	}
+	previous, known := j.nodes[cluster]
+	j.nodes[cluster] = current
+	detected := []Event{}
+	if !known {
+		j.dirty = true
+		return detected
+	}
+	hostnames := make([]string, 0, len(current)+len(previous))
+	for hostname := range current {
+		hostnames = append(hostnames, hostname)
+	}
+	for hostname := range previous {
+		if _, found := current[hostname]; !found {
+			hostnames = append(hostnames, hostname)
+		}
+	}
+	sort.Strings(hostnames)
+	for _, hostname := range hostnames {
+		before, existed := previous[hostname]
+		after, exists := current[hostname]
+		switch {
+		case !existed:
+			detected = append(detected, nodeEvent(KindNodeJoined, alerts.SeverityOK, hostname, collected,
+				fmt.Sprintf("Node %s joined the cluster (%s)", hostname, after.Membership)))
+		case !exists:
+			detected = append(detected, nodeEvent(KindNodeLeft, alerts.SeverityWarning, hostname, collected,
+				fmt.Sprintf("Node %s left the cluster", hostname)))
+		default:
+			detected = append(detected, compareNodes(hostname, collected, before, after)...)
+		}
+	}
+	if len(detected) > 0 || len(current) != len(previous) {
+		j.dirty = true
+	}
+	return detected
+}
+
+// compareNodes returns the events of the changes of a node between two scrapes
+func compareNodes(hostname string, collected time.Time, before, after nodeState) []Event {
+	detected := []Event{}
+	if after.Membership != before.Membership && after.Membership == failedMembership {
+		event := nodeEvent(KindNodeFailedOver, alerts.SeverityCritical, hostname, collected,
+			fmt.Sprintf("Node %s was failed over", hostname))
+		event.Changes = []Change{{Field: "clusterMembership", Before: before.Membership, After: after.Membership}}
+		detected = append(detected, event)
+	}
+	if after.Status != before.Status && (before.Status == healthyStatus || after.Status == healthyStatus) {
+		severity := alerts.SeverityWarning
+		if after.Status == healthyStatus {
+			severity = alerts.SeverityOK
+		}
+		event := nodeEvent(KindNodeStatus, severity, hostname, collected,
+			fmt.Sprintf("Node %s status changed from %s to %s", hostname, before.Status, after.Status))
+		event.Changes = []Change{{Field: "status", Before: before.Status, After: after.Status}}
+		detected = append(detected, event)
+	}
+	if after.Uptime < before.Uptime {
+		event := nodeEvent(KindNodeRestarted, alerts.SeverityWarning, hostname, collected,
+			fmt.Sprintf("Node %s restarted, up for %s", hostname, time.Duration(after.Uptime)*time.Second))
+		event.Changes = []Change{{Field: "uptime", Before: fmt.Sprint(before.Uptime), After: fmt.Sprint(after.Uptime)}}
+		detected = append(detected, event)
+	}
+	if after.Version != before.Version {
+		event := nodeEvent(KindNodeUpgraded, alerts.SeverityOK, hostname, collected,
+			fmt.Sprintf("Node %s version changed from %s to %s", hostname, before.Version, after.Version))
+		event.Changes = []Change{{Field: "version", Before: before.Version, After: after.Version}}
+		detected = append(detected, event)
+	}
+	return detected
+}
+
+func nodeEvent(kind string, severity alerts.Severity, hostname string, collected time.Time, message string) Event {
+	return Event{Kind: kind, Severity: severity, Message: message, Time: collected, Node: hostname}
+}
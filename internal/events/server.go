@@ -0,0 +1,66 @@
+package events
+
+import (
+	"cbmonitor/internal/alerts"
+	"cbmonitor/internal/monitor/stats"
+	"time"
+)
+
+// serverAlerts returns the events of the server alerts that appeared since the previous scrape. Couchbase
+// lists an alert until it is dismissed, an alert is new when the previous scrape did not list it and it is
+// not older than the latest one already seen. Alerts without a valid server time are dated by the scrape.
+// The first scrape of a cluster only records its alerts
+func (j *Journal) serverAlerts(cluster string, collected time.Time, listed []stats.ServerAlert) []Event {
+	position, found := j.cursors[cluster]
+	if !found {
+		position = &cursor{Seen: []string{}}
+		for _, alert := range listed {
+			position.Seen = append(position.Seen, alertKey(alert))
+			if serverTime, ok := alert.Time(); ok && serverTime.After(position.Time) {
+				position.Time = serverTime
+			}
+		}
+		j.cursors[cluster] = position
+		j.dirty = true
+		return []Event{}
+	}
+	previous := make(map[string]bool, len(position.Seen))
+	for _, key := range position.Seen {
+		previous[key] = true
+	}
+	detected := []Event{}
+	seen := make([]string, 0, len(listed))
+	latest := position.Time
+	for _, alert := range listed {
+		key := alertKey(alert)
+		seen = append(seen, key)
+		serverTime, ok := alert.Time()
+		if !ok {
+			serverTime = collected
+		}
+		if previous[key] || (ok && serverTime.Before(position.Time)) {
+			continue
+		}
+		previous[key] = true
+		if ok && serverTime.After(latest) {
+			latest = serverTime
+		}
+		detected = append(detected, Event{
+			Kind:     KindServerAlert,
+			Severity: alerts.SeverityWarning,
+			Message:  alert.Message,
+			Time:     serverTime,
+		})
+	}
+	if len(detected) > 0 || len(seen) != len(position.Seen) {
+		j.dirty = true
+	}
+	position.Seen = seen
+	position.Time = latest
+	return detected
+}
+
+// alertKey identifies a server alert among those listed by the previous scrape
+func alertKey(alert stats.ServerAlert) string {
+	return alert.ServerTime + "|" + alert.Message
+}
@@ -0,0 +1,283 @@
+package fleet
+
+import (
+	"cbmonitor/internal/diff"
+	"cbmonitor/internal/monitor/stats"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	ScopeCluster = "cluster"
+	ScopeBucket  = "bucket"
+	ScopeIndex   = "index"
+
+	// present and missing values of the buckets and indexes only some clusters of a group have
+	present = "present"
+	missing = "missing"
+)
+
+// Cluster latest statistics of a configured cluster, clusters that are not Available (down, never
+// scraped or missing part of their statistics) are listed but not compared
+type Cluster struct {
+	Name      string
+	Labels    map[string]string
+	Stats     stats.ClusterStats
+	Available bool
+}
+
+// Difference setting that is not the same in every cluster of a group, Values are keyed by cluster name
+type Difference struct {
+	Scope   string            `json:"scope"`
+	Subject string            `json:"subject,omitempty"`
+	Field   string            `json:"field,omitempty"`
+	Values  map[string]string `json:"values"`
+}
+
+func (d Difference) String() string {
+	subject := d.Scope
+	if d.Subject != "" {
+		subject = fmt.Sprintf("%s %s", d.Scope, d.Subject)
+	}
+	if d.Field != "" {
+		subject = fmt.Sprintf("%s %s", subject, d.Field)
+	}
+	clusters := make([]string, 0, len(d.Values))
+	for cluster := range d.Values {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+	values := make([]string, len(clusters))
+	for i, cluster := range clusters {
+		values[i] = fmt.Sprintf("%s=%s", cluster, d.Values[cluster])
+	}
+	return fmt.Sprintf("%s: %s", subject, strings.Join(values, ", "))
+}
+
+// Group clusters sharing the same value of the label, which are expected to be configured identically.
+// The indexes of the clusters listed in IndexesUnavailable could not be read and are not compared
+type Group struct {
+	Value              string       `json:"value"`
+	Clusters           []string     `json:"clusters"`
+	Unavailable        []string     `json:"unavailable,omitempty"`
+	IndexesUnavailable []string     `json:"indexesUnavailable,omitempty"`
+	Differences        []Difference `json:"differences"`
+}
+
+// Report differences found in every group of clusters
+type Report struct {
+	Label     string   `json:"label,omitempty"`
+	Groups    []Group  `json:"groups"`
+	Unlabeled []string `json:"unlabeled,omitempty"`
+}
+
+// Differences returns the number of differences found in all groups
+func (r Report) Differences() int {
+	count := 0
+	for _, group := range r.Groups {
+		count += len(group.Differences)
+	}
+	return count
+}
+
+// Compare groups the clusters by the value of the label and lists the differences between the clusters
+// of each group. Without label every cluster is compared with the others, clusters missing the label are
+// reported as unlabeled
+func Compare(label string, clusters []Cluster) Report {
+	report := Report{Label: label, Groups: []Group{}}
+	byValue := map[string][]Cluster{}
+	values := []string{}
+	for _, cluster := range clusters {
+		value, found := cluster.Labels[label]
+		if label == "" {
+			value, found = "", true
+		}
+		if !found {
+			report.Unlabeled = append(report.Unlabeled, cluster.Name)
+			continue
+		}
+		if _, known := byValue[value]; !known {
+			values = append(values, value)
+		}
+		byValue[value] = append(byValue[value], cluster)
+	}
+	sort.Strings(values)
+	for _, value := range values {
+		report.Groups = append(report.Groups, compareGroup(value, byValue[value]))
+	}
+	return report
+}
+
+func compareGroup(value string, clusters []Cluster) Group {
+	group := Group{Value: value, Clusters: []string{}}
+	available := []Cluster{}
+	for _, cluster := range clusters {
+		group.Clusters = append(group.Clusters, cluster.Name)
+		if cluster.Available {
+			available = append(available, cluster)
+			if cluster.Stats.IndexesError != "" {
+				group.IndexesUnavailable = append(group.IndexesUnavailable, cluster.Name)
+			}
+		} else {
+			group.Unavailable = append(group.Unavailable, cluster.Name)
+		}
+	}
+	found := &comparison{clusters: available, list: []Difference{}}
+	found.compare(ScopeCluster, "", "versions", func(s stats.ClusterStats) (string, bool) {
+		return diff.Versions(s.Nodes), true
+	})
+	found.compare(ScopeCluster, "", "memoryQuota", func(s stats.ClusterStats) (string, bool) {
+		return fmt.Sprint(s.MemoryQuotaMb), true
+	})
+	found.compare(ScopeCluster, "", "indexMemoryQuota", func(s stats.ClusterStats) (string, bool) {
+		return fmt.Sprint(s.IndexMemoryQuotaMb), true
+	})
+	found.compare(ScopeCluster, "", "ftsMemoryQuota", func(s stats.ClusterStats) (string, bool) {
+		return fmt.Sprint(s.FTSMemoryQuotaMb), true
+	})
+	found.compare(ScopeCluster, "", "services", func(s stats.ClusterStats) (string, bool) {
+		return layout(s.Nodes), true
+	})
+
+	for _, name := range found.names(bucketNames) {
+		found.compare(ScopeBucket, name, "", func(s stats.ClusterStats) (string, bool) {
+			if _, ok := bucket(s, name); ok {
+				return present, true
+			}
+			return missing, true
+		})
+		found.compare(ScopeBucket, name, "bucketType", func(s stats.ClusterStats) (string, bool) {
+			b, ok := bucket(s, name)
+			return b.BucketType, ok
+		})
+		found.compare(ScopeBucket, name, "replicaNumber", func(s stats.ClusterStats) (string, bool) {
+			b, ok := bucket(s, name)
+			return fmt.Sprint(b.ReplicaNumber), ok
+		})
+		found.compare(ScopeBucket, name, "ramQuotaMb", func(s stats.ClusterStats) (string, bool) {
+			b, ok := bucket(s, name)
+			return fmt.Sprint(b.RAMQuotaMb), ok
+		})
+	}
+
+	for _, name := range found.names(indexNames) {
+		found.compare(ScopeIndex, name, "", func(s stats.ClusterStats) (string, bool) {
+			if s.IndexesError != "" {
+				return "", false
+			}
+			if _, ok := index(s, name); ok {
+				return present, true
+			}
+			return missing, true
+		})
+		found.compare(ScopeIndex, name, "definition", func(s stats.ClusterStats) (string, bool) {
+			i, ok := index(s, name)
+			return definition(i.Definition), ok
+		})
+	}
+	group.Differences = found.list
+	return group
+}
+
+type comparison struct {
+	clusters []Cluster
+	list     []Difference
+}
+
+// compare adds a difference when the clusters the value applies to do not all have the same value
+func (c *comparison) compare(scope, subject, field string, value func(stats.ClusterStats) (string, bool)) {
+	values := map[string]string{}
+	distinct := map[string]bool{}
+	for _, cluster := range c.clusters {
+		if text, ok := value(cluster.Stats); ok {
+			values[cluster.Name] = text
+			distinct[text] = true
+		}
+	}
+	if len(distinct) > 1 {
+		c.list = append(c.list, Difference{Scope: scope, Subject: subject, Field: field, Values: values})
+	}
+}
+
+// names returns the sorted union of the names listed by every cluster
+func (c *comparison) names(list func(stats.ClusterStats) []string) []string {
+	unique := map[string]bool{}
+	for _, cluster := range c.clusters {
+		for _, name := range list(cluster.Stats) {
+			unique[name] = true
+		}
+	}
+	names := make([]string, 0, len(unique))
+	for name := range unique {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func bucketNames(clusterStats stats.ClusterStats) []string {
+	names := make([]string, len(clusterStats.Buckets))
+	for i, bucket := range clusterStats.Buckets {
+		names[i] = bucket.Name
+	}
+	return names
+}
+
+func bucket(clusterStats stats.ClusterStats, name string) (stats.Bucket, bool) {
+	for _, bucket := range clusterStats.Buckets {
+		if bucket.Name == name {
+			return bucket, true
+		}
+	}
+	return stats.Bucket{}, false
+}
+
+// indexName identifies an index by its bucket and name
+func indexName(index stats.Index) string {
+	return fmt.Sprintf("%s/%s", index.Bucket, index.Name)
+}
+
+func indexNames(clusterStats stats.ClusterStats) []string {
+	names := make([]string, len(clusterStats.Indexes))
+	for i, index := range clusterStats.Indexes {
+		names[i] = indexName(index)
+	}
+	return names
+}
+
+func index(clusterStats stats.ClusterStats, name string) (stats.Index, bool) {
+	for _, index := range clusterStats.Indexes {
+		if indexName(index) == name {
+			return index, true
+		}
+	}
+	return stats.Index{}, false
+}
+
+var withClause = regexp.MustCompile(`(?is)\s+WITH\s+\{.*\}\s*$`)
+
+// definition drops the WITH clause of an index definition, it holds the node placement and build
+// options which differ between clusters that are otherwise identical
+func definition(statement string) string {
+	return withClause.ReplaceAllString(strings.TrimSpace(statement), "")
+}
+
+// layout describes the service sets of the nodes, e.g. "2x[kv] 1x[index,n1ql]"
+func layout(nodes []stats.Node) string {
+	counts := map[string]int{}
+	for _, node := range nodes {
+		counts[diff.Services(node)]++
+	}
+	sets := make([]string, 0, len(counts))
+	for set := range counts {
+		sets = append(sets, set)
+	}
+	sort.Strings(sets)
+	described := make([]string, len(sets))
+	for i, set := range sets {
+		described[i] = fmt.Sprintf("%dx[%s]", counts[set], set)
+	}
+	return strings.Join(described, " ")
+}
@@ -0,0 +1,143 @@
+package fleet
+
+import (
+	"cbmonitor/internal/monitor/stats"
+	"reflect"
+	"testing"
+)
+
+// newTestCluster returns an available prod cluster, identical to the other test clusters but for its node
+// names
+func newTestCluster(name string) Cluster {
+	clusterStats := stats.ClusterStats{MemoryQuotaMb: 4096, IndexMemoryQuotaMb: 512}
+	clusterStats.Nodes = []stats.Node{
+		{Hostname: name + "1", Version: "7.6.2", Services: []string{"kv"}},
+		{Hostname: name + "2", Version: "7.6.2", Services: []string{"n1ql", "index"}},
+	}
+	clusterStats.Buckets = []stats.Bucket{{Name: "orders", BucketType: "membase", ReplicaNumber: 1, RAMQuotaMb: 1024}}
+	clusterStats.Indexes = []stats.Index{{Name: "by_date", Bucket: "orders",
+		Definition: "CREATE INDEX `by_date` ON `orders`(`date`) WITH {\"nodes\": [\"" + name + "2:8091\"]}"}}
+	return Cluster{Name: name, Labels: map[string]string{"env": "prod"}, Stats: clusterStats, Available: true}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*Cluster)
+		want   []Difference
+	}{
+		{name: "identical", change: func(*Cluster) {}, want: []Difference{}},
+		{name: "version", change: func(c *Cluster) { c.Stats.Nodes[0].Version = "7.2.4" },
+			want: []Difference{{Scope: ScopeCluster, Field: "versions",
+				Values: map[string]string{"east": "7.6.2", "west": "7.2.4,7.6.2"}}}},
+		{name: "memory quota", change: func(c *Cluster) { c.Stats.MemoryQuotaMb = 8192 },
+			want: []Difference{{Scope: ScopeCluster, Field: "memoryQuota",
+				Values: map[string]string{"east": "4096", "west": "8192"}}}},
+		{name: "services", change: func(c *Cluster) { c.Stats.Nodes[1].Services = []string{"kv"} },
+			want: []Difference{{Scope: ScopeCluster, Field: "services",
+				Values: map[string]string{"east": "1x[index,n1ql] 1x[kv]", "west": "2x[kv]"}}}},
+		{name: "missing bucket", change: func(c *Cluster) { c.Stats.Buckets = nil },
+			want: []Difference{{Scope: ScopeBucket, Subject: "orders",
+				Values: map[string]string{"east": present, "west": missing}}}},
+		{name: "bucket settings", change: func(c *Cluster) {
+			c.Stats.Buckets[0].BucketType = "ephemeral"
+			c.Stats.Buckets[0].ReplicaNumber = 2
+			c.Stats.Buckets[0].RAMQuotaMb = 2048
+		}, want: []Difference{
+			{Scope: ScopeBucket, Subject: "orders", Field: "bucketType",
+				Values: map[string]string{"east": "membase", "west": "ephemeral"}},
+			{Scope: ScopeBucket, Subject: "orders", Field: "replicaNumber",
+				Values: map[string]string{"east": "1", "west": "2"}},
+			{Scope: ScopeBucket, Subject: "orders", Field: "ramQuotaMb",
+				Values: map[string]string{"east": "1024", "west": "2048"}},
+		}},
+		{name: "extra index", change: func(c *Cluster) {
+			c.Stats.Indexes = append(c.Stats.Indexes, stats.Index{Name: "by_user", Bucket: "orders"})
+		}, want: []Difference{{Scope: ScopeIndex, Subject: "orders/by_user",
+			Values: map[string]string{"east": missing, "west": present}}}},
+		{name: "index definition", change: func(c *Cluster) {
+			c.Stats.Indexes[0].Definition = "CREATE INDEX `by_date` ON `orders`(`date`, `user`)"
+		}, want: []Difference{{Scope: ScopeIndex, Subject: "orders/by_date", Field: "definition",
+			Values: map[string]string{"east": "CREATE INDEX `by_date` ON `orders`(`date`)",
+				"west": "CREATE INDEX `by_date` ON `orders`(`date`, `user`)"}}}},
+		{name: "unreadable indexes", change: func(c *Cluster) {
+			c.Stats.Indexes = nil
+			c.Stats.IndexesError = "forbidden"
+		}, want: []Difference{}},
+		{name: "unavailable", change: func(c *Cluster) {
+			c.Stats.MemoryQuotaMb = 8192
+			c.Available = false
+		}, want: []Difference{}},
+	}
+	for _, test := range tests {
+		west := newTestCluster("west")
+		test.change(&west)
+		report := Compare("env", []Cluster{newTestCluster("east"), west})
+		if len(report.Groups) != 1 {
+			t.Fatalf("%s: Compare() = %+v, want one group", test.name, report)
+		}
+		if differences := report.Groups[0].Differences; !reflect.DeepEqual(differences, test.want) {
+			t.Errorf("%s: differences %+v, want %+v", test.name, differences, test.want)
+		}
+	}
+}
+
+func TestCompareGroups(t *testing.T) {
+	east, west, dev, lab := newTestCluster("east"), newTestCluster("west"), newTestCluster("dev"),
+		newTestCluster("lab")
+	dev.Labels = map[string]string{"env": "dev"}
+	dev.Stats.MemoryQuotaMb = 1024
+	west.Available = false
+	east.Stats.IndexesError = "forbidden"
+	lab.Labels = nil
+
+	report := Compare("env", []Cluster{west, east, dev, lab})
+	want := Report{Label: "env", Groups: []Group{
+		{Value: "dev", Clusters: []string{"dev"}, Differences: []Difference{}},
+		{Value: "prod", Clusters: []string{"west", "east"}, Unavailable: []string{"west"},
+			IndexesUnavailable: []string{"east"}, Differences: []Difference{}},
+	}, Unlabeled: []string{"lab"}}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("Compare() = %+v, want %+v", report, want)
+	}
+
+	// without label every cluster is in the same group
+	report = Compare("", []Cluster{east, dev, lab})
+	if len(report.Groups) != 1 || len(report.Groups[0].Clusters) != 3 || len(report.Unlabeled) != 0 ||
+		report.Differences() != 1 {
+		t.Errorf("Compare() without label = %+v, want one group with the memory quota difference", report)
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	tests := map[string]string{
+		"CREATE INDEX `a` ON `b`(`c`)":                                       "CREATE INDEX `a` ON `b`(`c`)",
+		"  CREATE INDEX `a` ON `b`(`c`) WITH { \"defer_build\":true }  ":     "CREATE INDEX `a` ON `b`(`c`)",
+		"CREATE INDEX `a` ON `b`(`c`)\nwith {\"nodes\": [\"n1:8091\"]}":      "CREATE INDEX `a` ON `b`(`c`)",
+		"CREATE INDEX `a` ON `b`(`c`) WHERE `with` = \"{x}\" WITH {\"n\":1}": "CREATE INDEX `a` ON `b`(`c`) WHERE `with` = \"{x}\"",
+	}
+	for statement, want := range tests {
+		if got := definition(statement); got != want {
+			t.Errorf("definition(%q) = %q, want %q", statement, got, want)
+		}
+	}
+}
+
+func TestDifferenceString(t *testing.T) {
+	tests := []struct {
+		difference Difference
+		want       string
+	}{
+		{Difference{Scope: ScopeCluster, Field: "memoryQuota", Values: map[string]string{"west": "8192", "east": "4096"}},
+			"cluster memoryQuota: east=4096, west=8192"},
+		{Difference{Scope: ScopeBucket, Subject: "orders", Values: map[string]string{"east": present, "west": missing}},
+			"bucket orders: east=present, west=missing"},
+		{Difference{Scope: ScopeIndex, Subject: "orders/by_date", Field: "definition",
+			Values: map[string]string{"east": "a"}}, "index orders/by_date definition: east=a"},
+	}
+	for _, test := range tests {
+		if got := test.difference.String(); got != test.want {
+			t.Errorf("String() = %q, want %q", got, test.want)
+		}
+	}
+}
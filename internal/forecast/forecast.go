@@ -0,0 +1,270 @@
+package forecast
+
+import (
+	"cbmonitor/internal/alerts"
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/history"
+	"cbmonitor/internal/monitor/stats"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resources forecasts are computed for
+const (
+	ResourceRAM         = "ram"
+	ResourceDisk        = "disk"
+	ResourceBucketQuota = "bucket_quota"
+)
+
+// Forecast states
+const (
+	// StateCollecting not enough samples were collected yet
+	StateCollecting = "collecting"
+	// StateStable the usage is not growing
+	StateStable = "stable"
+	// StateGrowing the usage grows and will reach the threshold at ThresholdAt
+	StateGrowing = "growing"
+	// StateReached the usage already reached the threshold
+	StateReached = "reached"
+)
+
+// AlertName name of the alerts raised by forecasts
+const AlertName = "capacity_forecast"
+
+// horizon usage growing so slowly that the threshold is reached later is considered stable
+const horizon = 10 * 365 * 24 * time.Hour
+
+// Forecast growth trend of the usage of a resource (in percent) and the time it reaches its threshold
+type Forecast struct {
+	Resource        string     `json:"resource"`
+	Bucket          string     `json:"bucket,omitempty"`
+	Metric          string     `json:"metric"`
+	State           string     `json:"state"`
+	Current         float64    `json:"current"`
+	Threshold       float64    `json:"threshold"`
+	GrowthPerDay    float64    `json:"growthPerDay"`
+	Samples         int        `json:"samples"`
+	ThresholdAt     *time.Time `json:"thresholdAt,omitempty"`
+	TimeToThreshold string     `json:"timeToThreshold,omitempty"`
+	timeToThreshold time.Duration
+}
+
+// Forecaster keeps a downsampled history of the usage of every cluster and fits its growth
+type Forecaster struct {
+	config config.Forecast
+	series map[string]map[string][]history.Point
+	mu     sync.RWMutex
+}
+
+// NewForecaster creates a forecaster for the given (normalized) settings
+func NewForecaster(settings config.Forecast) *Forecaster {
+	return &Forecaster{
+		config: settings,
+		series: map[string]map[string][]history.Point{},
+	}
+}
+
+// usage returns the usage percentages tracked for the cluster, keyed by metric name
+func usage(clusterStats stats.ClusterStats) map[string]float64 {
+	values := map[string]float64{
+		"ram_pct_used": clusterStats.RAMPctUsed * 100,
+		"hd_pct_used":  clusterStats.HdPctUsed * 100,
+	}
+	for _, bucket := range clusterStats.Buckets {
+		values[stats.BucketMetric(bucket.Name, "quota_pct_used")] = bucket.QuotaPctUsed
+	}
+	return values
+}
+
+// Add records the usage of a cluster, at most one sample per resolution is kept over the window
+func (f *Forecaster) Add(cluster string, collected time.Time, clusterStats stats.ClusterStats) {
+	values := usage(clusterStats)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	series, found := f.series[cluster]
+	if !found {
+		series = map[string][]history.Point{}
+		f.series[cluster] = series
+	}
+	// buckets are missing from partial scrapes, their series are only dropped once buckets are seen again
+	if len(clusterStats.Buckets) > 0 {
+		for metric := range series {
+			if _, found := values[metric]; !found {
+				delete(series, metric)
+			}
+		}
+	}
+	oldest := collected.Add(-f.config.Window.Duration)
+	for metric, value := range values {
+		points := series[metric]
+		if len(points) > 0 && collected.Sub(points[len(points)-1].Time) < f.config.Resolution.Duration {
+			continue
+		}
+		points = append(points, history.Point{Time: collected, Value: value})
+		first := 0
+		for first < len(points) && points[first].Time.Before(oldest) {
+			first++
+		}
+		series[metric] = append([]history.Point{}, points[first:]...)
+	}
+}
+
+// Forget drops the usage series of the clusters, when they are not monitored by this instance anymore
+func (f *Forecaster) Forget(clusters []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, cluster := range clusters {
+		delete(f.series, cluster)
+	}
+}
+
+// Cluster returns the forecasts of a cluster: RAM, disk, then the bucket quotas by bucket name
+func (f *Forecaster) Cluster(cluster string, now time.Time) []Forecast {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	series := f.series[cluster]
+	metrics := make([]string, 0, len(series))
+	for metric := range series {
+		metrics = append(metrics, metric)
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		if rank(metrics[i]) != rank(metrics[j]) {
+			return rank(metrics[i]) < rank(metrics[j])
+		}
+		return metrics[i] < metrics[j]
+	})
+	forecasts := make([]Forecast, 0, len(metrics))
+	for _, metric := range metrics {
+		forecasts = append(forecasts, f.forecast(metric, series[metric], now))
+	}
+	return forecasts
+}
+
+// rank orders the cluster RAM before the cluster disk and the bucket quotas
+func rank(metric string) int {
+	switch metric {
+	case "ram_pct_used":
+		return 0
+	case "hd_pct_used":
+		return 1
+	}
+	return 2
+}
+
+func (f *Forecaster) forecast(metric string, points []history.Point, now time.Time) Forecast {
+	forecast := Forecast{Metric: metric, Samples: len(points), State: StateCollecting}
+	switch {
+	case metric == "ram_pct_used":
+		forecast.Resource, forecast.Threshold = ResourceRAM, f.config.Thresholds.RAM
+	case metric == "hd_pct_used":
+		forecast.Resource, forecast.Threshold = ResourceDisk, f.config.Thresholds.Disk
+	default:
+		forecast.Resource, forecast.Threshold = ResourceBucketQuota, f.config.Thresholds.BucketQuota
+		forecast.Bucket = strings.TrimSuffix(strings.TrimPrefix(metric, "buckets/"), "/quota_pct_used")
+	}
+	if len(points) == 0 {
+		return forecast
+	}
+	forecast.Current = points[len(points)-1].Value
+	if forecast.Current >= forecast.Threshold {
+		forecast.State = StateReached
+		return forecast
+	}
+	if len(points) < f.config.MinSamples {
+		return forecast
+	}
+	slope, intercept := fit(points)
+	forecast.GrowthPerDay = slope * (24 * time.Hour).Seconds()
+	seconds := (forecast.Threshold - intercept) / slope
+	if slope <= 0 || seconds > horizon.Seconds() {
+		forecast.State = StateStable
+		return forecast
+	}
+	forecast.State = StateGrowing
+	reached := points[0].Time.Add(time.Duration(seconds * float64(time.Second)))
+	forecast.ThresholdAt = &reached
+	forecast.timeToThreshold = reached.Sub(now)
+	if forecast.timeToThreshold < 0 {
+		forecast.timeToThreshold = 0
+	}
+	forecast.TimeToThreshold = formatDuration(forecast.timeToThreshold)
+	return forecast
+}
+
+// fit returns the least squares line of the points, in value per second since the first point
+func fit(points []history.Point) (slope, intercept float64) {
+	n := float64(len(points))
+	var sumX, sumY, sumXY, sumXX float64
+	for _, point := range points {
+		x := point.Time.Sub(points[0].Time).Seconds()
+		sumX += x
+		sumY += point.Value
+		sumXY += x * point.Value
+		sumXX += x * x
+	}
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0, sumY / n
+	}
+	slope = (n*sumXY - sumX*sumY) / denominator
+	return slope, (sumY - slope*sumX) / n
+}
+
+// formatDuration rounds durations to the hour, or to the minute below an hour
+func formatDuration(duration time.Duration) string {
+	if duration < time.Hour {
+		return duration.Round(time.Minute).String()
+	}
+	hours := int(duration.Round(time.Hour).Hours())
+	if hours < 24 {
+		return fmt.Sprintf("%dh", hours)
+	}
+	return fmt.Sprintf("%dd%dh", hours/24, hours%24)
+}
+
+func (forecast Forecast) subject() string {
+	switch forecast.Resource {
+	case ResourceRAM:
+		return "Cluster RAM"
+	case ResourceDisk:
+		return "Cluster disk"
+	}
+	return fmt.Sprintf("Bucket %s quota", forecast.Bucket)
+}
+
+// Alerts returns the alerts raised by forecasts reaching their threshold within the warning or critical
+// limits, thresholds already reached are critical
+func (f *Forecaster) Alerts(cluster string, forecasts []Forecast) []alerts.Alert {
+	raised := []alerts.Alert{}
+	for _, forecast := range forecasts {
+		alert := alerts.Alert{
+			Cluster: cluster,
+			Type:    alerts.TypeCalculated,
+			Name:    AlertName,
+			Bucket:  forecast.Bucket,
+			Metric:  forecast.Metric,
+			Value:   forecast.Current,
+		}
+		switch {
+		case forecast.State == StateReached:
+			alert.Severity = alerts.SeverityCritical
+			alert.Message = fmt.Sprintf("%s usage %.1f%% reached the %g%% threshold", forecast.subject(),
+				forecast.Current, forecast.Threshold)
+		case forecast.State != StateGrowing || forecast.timeToThreshold > f.config.Warning.Duration:
+			continue
+		default:
+			alert.Severity = alerts.SeverityWarning
+			if forecast.timeToThreshold <= f.config.Critical.Duration {
+				alert.Severity = alerts.SeverityCritical
+			}
+			alert.Message = fmt.Sprintf("%s usage %.1f%% is forecast to reach %g%% in %s (%s)", forecast.subject(),
+				forecast.Current, forecast.Threshold, forecast.TimeToThreshold,
+				forecast.ThresholdAt.UTC().Format(time.RFC3339))
+		}
+		raised = append(raised, alert)
+	}
+	return raised
+}
@@ -0,0 +1,206 @@
+package forecast
+
+import (
+	"cbmonitor/internal/alerts"
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/history"
+	"cbmonitor/internal/monitor/stats"
+	"math"
+	"testing"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+var start = time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+func newTestForecaster() *Forecaster {
+	return NewForecaster(config.Forecast{
+		Resolution: config.Duration{Duration: time.Hour},
+		Window:     config.Duration{Duration: 30 * day},
+		MinSamples: 3,
+		Thresholds: config.ForecastThresholds{RAM: 90, Disk: 85, BucketQuota: 95},
+		Warning:    config.Duration{Duration: 7 * day},
+		Critical:   config.Duration{Duration: 2 * day},
+	})
+}
+
+// bucketStats returns statistics with RAM and disk usage of 50% and the quota usage of the orders bucket
+func bucketStats(quota float64) stats.ClusterStats {
+	clusterStats := stats.ClusterStats{RAMPctUsed: 0.5, HdPctUsed: 0.5}
+	clusterStats.Buckets = []stats.Bucket{{Name: "orders", QuotaPctUsed: quota}}
+	return clusterStats
+}
+
+// find returns the forecast of the resource
+func find(forecasts []Forecast, resource string) Forecast {
+	for _, forecast := range forecasts {
+		if forecast.Resource == resource {
+			return forecast
+		}
+	}
+	return Forecast{}
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		name      string
+		values    []float64
+		slope     float64
+		intercept float64
+	}{
+		{name: "single point", values: []float64{42}, slope: 0, intercept: 42},
+		{name: "flat", values: []float64{10, 10, 10}, slope: 0, intercept: 10},
+		{name: "growing", values: []float64{80, 81, 82, 83}, slope: 1, intercept: 80},
+		{name: "shrinking", values: []float64{50, 48, 46}, slope: -2, intercept: 50},
+		{name: "noisy", values: []float64{1, 3, 2, 4}, slope: 0.8, intercept: 1.3},
+	}
+	for _, test := range tests {
+		points := make([]history.Point, len(test.values))
+		for i, value := range test.values {
+			points[i] = history.Point{Time: start.Add(time.Duration(i) * day), Value: value}
+		}
+		slope, intercept := fit(points)
+		slopePerDay := slope * day.Seconds()
+		if math.Abs(slopePerDay-test.slope) > 1e-9 || math.Abs(intercept-test.intercept) > 1e-9 {
+			t.Errorf("%s: fit() = %v per day from %v, want %v from %v", test.name, slopePerDay, intercept,
+				test.slope, test.intercept)
+		}
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := map[time.Duration]string{
+		0:                               "0s",
+		90 * time.Second:                "2m0s",
+		59*time.Minute + 50*time.Second: "1h0m0s",
+		90 * time.Minute:                "2h",
+		23 * time.Hour:                  "23h",
+		3*day + 12*time.Hour:            "3d12h",
+		40 * day:                        "40d0h",
+	}
+	for duration, want := range tests {
+		if got := formatDuration(duration); got != want {
+			t.Errorf("formatDuration(%s) = %q, want %q", duration, got, want)
+		}
+	}
+}
+
+func TestForecast(t *testing.T) {
+	tests := []struct {
+		name            string
+		quotas          []float64
+		state           string
+		growthPerDay    float64
+		timeToThreshold string
+		severity        alerts.Severity
+	}{
+		{name: "collecting", quotas: []float64{80, 81}, state: StateCollecting},
+		{name: "stable", quotas: []float64{80, 80, 80, 80}, state: StateStable},
+		{name: "shrinking", quotas: []float64{84, 83, 82, 81}, state: StateStable, growthPerDay: -1},
+		{name: "reached", quotas: []float64{90, 96}, state: StateReached, severity: alerts.SeverityCritical},
+		{name: "growing slowly", quotas: []float64{80, 81, 82, 83, 84}, state: StateGrowing, growthPerDay: 1,
+			timeToThreshold: "11d0h"},
+		{name: "growing", quotas: []float64{80, 82, 84, 86, 88}, state: StateGrowing, growthPerDay: 2,
+			timeToThreshold: "3d12h", severity: alerts.SeverityWarning},
+		{name: "growing fast", quotas: []float64{80, 83, 86, 89, 92}, state: StateGrowing, growthPerDay: 3,
+			timeToThreshold: "1d0h", severity: alerts.SeverityCritical},
+	}
+	for _, test := range tests {
+		f := newTestForecaster()
+		now := start
+		for i, quota := range test.quotas {
+			now = start.Add(time.Duration(i) * day)
+			f.Add("east", now, bucketStats(quota))
+		}
+		forecasts := f.Cluster("east", now)
+		if len(forecasts) != 3 || forecasts[0].Resource != ResourceRAM || forecasts[1].Resource != ResourceDisk {
+			t.Fatalf("%s: Cluster() = %+v, want RAM, disk and the bucket quota", test.name, forecasts)
+		}
+		forecast := forecasts[2]
+		if forecast.Bucket != "orders" || forecast.State != test.state || forecast.Samples != len(test.quotas) ||
+			forecast.Current != test.quotas[len(test.quotas)-1] ||
+			math.Abs(forecast.GrowthPerDay-test.growthPerDay) > 1e-9 ||
+			forecast.TimeToThreshold != test.timeToThreshold {
+			t.Errorf("%s: forecast = %+v", test.name, forecast)
+		}
+		raised := f.Alerts("east", forecasts)
+		if test.severity == alerts.SeverityOK {
+			if len(raised) > 0 {
+				t.Errorf("%s: Alerts() = %+v, want none", test.name, raised)
+			}
+			continue
+		}
+		if len(raised) != 1 || raised[0].Severity != test.severity || raised[0].Bucket != "orders" ||
+			raised[0].Name != AlertName {
+			t.Errorf("%s: Alerts() = %+v, want one %s alert", test.name, raised, test.severity)
+		}
+	}
+}
+
+func TestAlertMessages(t *testing.T) {
+	f := newTestForecaster()
+	for i := 0; i < 4; i++ {
+		clusterStats := bucketStats(80)
+		clusterStats.RAMPctUsed = 0.8 + 0.02*float64(i)
+		clusterStats.HdPctUsed = 0.9
+		f.Add("east", start.Add(time.Duration(i)*day), clusterStats)
+	}
+	raised := f.Alerts("east", f.Cluster("east", start.Add(3*day)))
+	want := []string{
+		"Cluster RAM usage 86.0% is forecast to reach 90% in 2d0h (2026-10-06T00:00:00Z)",
+		"Cluster disk usage 90.0% reached the 85% threshold",
+	}
+	if len(raised) != len(want) {
+		t.Fatalf("Alerts() = %+v, want %d alerts", raised, len(want))
+	}
+	for i, alert := range raised {
+		if alert.Message != want[i] || alert.Severity != alerts.SeverityCritical {
+			t.Errorf("alert %d = %s %q, want critical %q", i, alert.Severity, alert.Message, want[i])
+		}
+	}
+}
+
+func TestAddDownsamplesAndTrims(t *testing.T) {
+	f := newTestForecaster()
+	for minutes := 0; minutes < 150; minutes += 10 {
+		f.Add("east", start.Add(time.Duration(minutes)*time.Minute), bucketStats(80))
+	}
+	if samples := find(f.Cluster("east", start), ResourceRAM).Samples; samples != 3 {
+		t.Errorf("%d samples in 2h30, want one per hour", samples)
+	}
+	f.Add("east", start.Add(31*day), bucketStats(80))
+	if samples := find(f.Cluster("east", start), ResourceRAM).Samples; samples != 1 {
+		t.Errorf("%d samples after the window, want the older ones dropped", samples)
+	}
+}
+
+func TestAddPrunesBuckets(t *testing.T) {
+	f := newTestForecaster()
+	f.Add("east", start, bucketStats(80))
+	// partial scrapes have no bucket
+	f.Add("east", start.Add(time.Hour), stats.ClusterStats{RAMPctUsed: 0.5})
+	if forecasts := f.Cluster("east", start); len(forecasts) != 3 {
+		t.Errorf("Cluster() = %d forecasts after a partial scrape, want the bucket kept", len(forecasts))
+	}
+	renamed := bucketStats(80)
+	renamed.Buckets[0].Name = "invoices"
+	f.Add("east", start.Add(2*time.Hour), renamed)
+	forecasts := f.Cluster("east", start)
+	if len(forecasts) != 3 || forecasts[2].Bucket != "invoices" {
+		t.Errorf("Cluster() = %+v, want the removed bucket dropped", forecasts)
+	}
+}
+
+func TestForget(t *testing.T) {
+	f := newTestForecaster()
+	f.Add("east", start, bucketStats(80))
+	f.Add("west", start, bucketStats(80))
+	f.Forget([]string{"east"})
+	if forecasts := f.Cluster("east", start); len(forecasts) != 0 {
+		t.Errorf("Cluster(east) = %+v after Forget, want none", forecasts)
+	}
+	if forecasts := f.Cluster("west", start); len(forecasts) != 3 {
+		t.Errorf("Cluster(west) = %d forecasts, want 3", len(forecasts))
+	}
+}
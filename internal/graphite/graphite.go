@@ -0,0 +1,110 @@
+package graphite
+
+import (
+	"bufio"
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/logging"
+	"cbmonitor/internal/metricpath"
+	"cbmonitor/internal/monitor/stats"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+)
+
+const queueSize = 64
+
+type sample struct {
+	collected time.Time
+	metrics   []metricpath.Metric
+}
+
+// Writer sends the statistics to Graphite using the plaintext protocol over TCP
+type Writer struct {
+	config config.Graphite
+	queue  chan sample
+	conn   net.Conn
+}
+
+// NewWriter creates a writer for the given (normalized) output configuration
+func NewWriter(output config.Graphite) *Writer {
+	return &Writer{
+		config: output,
+		queue:  make(chan sample, queueSize),
+	}
+}
+
+// Add queues the statistics of a cluster, they are dropped when the queue is full
+func (w *Writer) Add(cluster string, collected time.Time, clusterStats stats.ClusterStats) {
+	select {
+	case w.queue <- sample{collected: collected, metrics: metricpath.Metrics(w.config.Paths, cluster, clusterStats)}:
+	default:
+		slog.Warn("Graphite output queue full, dropping statistics", logging.ClusterKey, cluster)
+	}
+}
+
+// Run sends the queued statistics until stop is closed, the statistics still queued are then sent
+func (w *Writer) Run(stop <-chan struct{}) {
+	defer w.close()
+	for {
+		select {
+		case <-stop:
+			for {
+				select {
+				case queued := <-w.queue:
+					w.deliver(queued)
+				default:
+					return
+				}
+			}
+		case queued := <-w.queue:
+			w.deliver(queued)
+		}
+	}
+}
+
+// deliver sends the queued statistics, failures are logged
+func (w *Writer) deliver(queued sample) {
+	if err := w.send(queued); err != nil {
+		slog.Error("Cannot send metrics to Graphite", "address", w.config.Address, "error", err)
+	}
+}
+
+func (w *Writer) close() {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+}
+
+// send writes the metrics, reconnecting once when the connection was closed by the server
+func (w *Writer) send(queued sample) error {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if w.conn == nil {
+			w.conn, err = net.DialTimeout("tcp", w.config.Address, w.config.Timeout.Duration)
+			if err != nil {
+				return err
+			}
+		}
+		if err = w.write(queued); err == nil {
+			return nil
+		}
+		w.close()
+	}
+	return err
+}
+
+func (w *Writer) write(queued sample) error {
+	w.conn.SetWriteDeadline(time.Now().Add(w.config.Timeout.Duration))
+	writer := bufio.NewWriter(w.conn)
+	timestamp := strconv.FormatInt(queued.collected.Unix(), 10)
+	for _, metric := range queued.metrics {
+		if _, err := fmt.Fprintf(writer, "%s %s %s\n", metric.Path,
+			strconv.FormatFloat(metric.Value, 'f', -1, 64), timestamp); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
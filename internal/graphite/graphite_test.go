@@ -0,0 +1,145 @@
+package graphite
+
+import (
+	"bufio"
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/monitor/stats"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver Graphite endpoint keeping the lines it receives
+type receiver struct {
+	listener net.Listener
+	lines    []string
+	mu       sync.Mutex
+	done     sync.WaitGroup
+}
+
+func newReceiver(t *testing.T) *receiver {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &receiver{listener: listener}
+	r.done.Add(1)
+	go r.accept()
+	return r
+}
+
+func (r *receiver) accept() {
+	defer r.done.Done()
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		r.done.Add(1)
+		go r.read(conn)
+	}
+}
+
+func (r *receiver) read(conn net.Conn) {
+	defer r.done.Done()
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		r.mu.Lock()
+		r.lines = append(r.lines, scanner.Text())
+		r.mu.Unlock()
+	}
+}
+
+// received stops the receiver and returns the lines it received
+func (r *receiver) received() []string {
+	r.listener.Close()
+	r.done.Wait()
+	return r.lines
+}
+
+func newTestWriter(address string) *Writer {
+	return NewWriter(config.Graphite{
+		Address: address,
+		Timeout: config.Duration{Duration: time.Second},
+		Paths: config.MetricPaths{Cluster: "cb.{cluster}.{metric}", Node: "cb.{cluster}.{node}.{metric}",
+			Bucket: "cb.{cluster}.{bucket}.{metric}"},
+	})
+}
+
+func TestRun(t *testing.T) {
+	r := newReceiver(t)
+	w := newTestWriter(r.listener.Addr().String())
+	collected := time.Unix(1760788800, 0)
+	w.Add("prod.east", collected, stats.ClusterStats{RAMPctUsed: 42.5, RAMTotal: 1 << 40})
+	stop := make(chan struct{})
+	close(stop)
+	w.Run(stop)
+
+	lines := r.received()
+	if len(lines) == 0 {
+		t.Fatalf("nothing received")
+	}
+	want := map[string]bool{
+		"cb.prod_east.ram_pct_used 42.5 1760788800":       false,
+		"cb.prod_east.ram_total 1099511627776 1760788800": false,
+	}
+	for _, line := range lines {
+		if fields := strings.Fields(line); len(fields) != 3 || fields[2] != "1760788800" {
+			t.Errorf("invalid line %q", line)
+		}
+		if _, found := want[line]; found {
+			want[line] = true
+		}
+	}
+	for line, found := range want {
+		if !found {
+			t.Errorf("line %q not received", line)
+		}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	r := newReceiver(t)
+	w := newTestWriter(r.listener.Addr().String())
+	broken, err := net.Dial("tcp", r.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken.Close()
+	// the connection failed since the previous send
+	w.conn = broken
+	clusterStats := stats.ClusterStats{RAMPctUsed: 42.5}
+	w.Add("east", time.Unix(1760788800, 0), clusterStats)
+	stop := make(chan struct{})
+	close(stop)
+	w.Run(stop)
+
+	if w.conn != nil {
+		t.Errorf("connection left open after Run")
+	}
+	lines := r.received()
+	if len(lines) != len(clusterStats.Metrics()) {
+		t.Errorf("received %d lines, want the %d metrics sent after reconnecting", len(lines),
+			len(clusterStats.Metrics()))
+	}
+}
+
+func TestUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	w := newTestWriter(address)
+	if err := w.send(sample{collected: time.Now()}); err == nil {
+		t.Errorf("send() to a closed port succeeded")
+	}
+	if w.conn != nil {
+		t.Errorf("connection kept after failing to dial")
+	}
+}
@@ -0,0 +1,93 @@
+package history
+
+import (
+	"cbmonitor/internal/monitor/stats"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultCapacity number of samples kept per cluster when no capacity is given
+const DefaultCapacity = 240
+
+// Point value of a metric at a given time
+type Point struct {
+	Time  time.Time `json:"t"`
+	Value float64   `json:"v"`
+}
+
+type sample struct {
+	time   time.Time
+	values map[string]float64
+}
+
+// Store keeps the latest samples of the statistics of every cluster in memory
+type Store struct {
+	capacity int
+	clusters map[string][]sample
+	mu       sync.RWMutex
+}
+
+// NewStore creates a store keeping up to capacity samples per cluster
+func NewStore(capacity int) *Store {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	return &Store{
+		capacity: capacity,
+		clusters: make(map[string][]sample),
+	}
+}
+
+// Add records the statistics of a cluster collected at the given time, series are named as in
+// stats.ClusterStats.AllMetrics
+func (s *Store) Add(cluster string, collected time.Time, clusterStats stats.ClusterStats) {
+	values := clusterStats.AllMetrics()
+	s.mu.Lock()
+	samples := append(s.clusters[cluster], sample{time: collected, values: values})
+	if len(samples) > s.capacity {
+		samples = append([]sample{}, samples[len(samples)-s.capacity:]...)
+	}
+	s.clusters[cluster] = samples
+	s.mu.Unlock()
+}
+
+// Forget drops the samples of the clusters, when they are not monitored by this instance anymore
+func (s *Store) Forget(clusters []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cluster := range clusters {
+		delete(s.clusters, cluster)
+	}
+}
+
+// Series returns the recorded values of a metric of a cluster, oldest first
+func (s *Store) Series(cluster, metric string) []Point {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	points := []Point{}
+	for _, sample := range s.clusters[cluster] {
+		if value, ok := sample.values[metric]; ok {
+			points = append(points, Point{Time: sample.time, Value: value})
+		}
+	}
+	return points
+}
+
+// Metrics returns the names of the metrics recorded for a cluster
+func (s *Store) Metrics(cluster string) []string {
+	s.mu.RLock()
+	names := make(map[string]bool)
+	for _, sample := range s.clusters[cluster] {
+		for name := range sample.values {
+			names[name] = true
+		}
+	}
+	s.mu.RUnlock()
+	metrics := make([]string, 0, len(names))
+	for name := range names {
+		metrics = append(metrics, name)
+	}
+	sort.Strings(metrics)
+	return metrics
+}
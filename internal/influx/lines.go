@@ -0,0 +1,80 @@
+package influx
+
+import (
+	"cbmonitor/internal/labels"
+	"cbmonitor/internal/monitor/stats"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	keyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+type tag struct {
+	key   string
+	value string
+}
+
+// line formats a line protocol point, tags with empty values are skipped
+func line(measurement string, tags []tag, fields map[string]float64, timestamp time.Time) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	var builder strings.Builder
+	builder.WriteString(measurementEscaper.Replace(measurement))
+	for _, t := range tags {
+		if t.value == "" {
+			continue
+		}
+		builder.WriteString(",")
+		builder.WriteString(keyEscaper.Replace(t.key))
+		builder.WriteString("=")
+		builder.WriteString(keyEscaper.Replace(t.value))
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		if i == 0 {
+			builder.WriteString(" ")
+		} else {
+			builder.WriteString(",")
+		}
+		builder.WriteString(keyEscaper.Replace(name))
+		builder.WriteString("=")
+		builder.WriteString(strconv.FormatFloat(fields[name], 'f', -1, 64))
+	}
+	builder.WriteString(" ")
+	builder.WriteString(strconv.FormatInt(timestamp.UnixNano(), 10))
+	return builder.String()
+}
+
+// Lines converts the statistics of a cluster into line protocol points: one for the cluster and one
+// per node and bucket, all tagged with the configured cluster name and labels
+func Lines(prefix, cluster string, collected time.Time, clusterStats stats.ClusterStats) []string {
+	clusterTags := []tag{{"cluster", cluster}}
+	for _, name := range labels.Sorted(clusterStats.Labels) {
+		clusterTags = append(clusterTags, tag{name, clusterStats.Labels[name]})
+	}
+	with := func(tags ...tag) []tag {
+		return append(append([]tag{}, clusterTags...), tags...)
+	}
+	lines := []string{
+		line(prefix+"_cluster", with(tag{"cluster_name", clusterStats.Name}), clusterStats.Metrics(), collected),
+	}
+	for _, node := range clusterStats.Nodes {
+		tags := with(tag{"node", node.Hostname}, tag{"version", node.Version}, tag{"status", node.Status})
+		lines = append(lines, line(prefix+"_node", tags, node.Metrics(), collected))
+	}
+	for _, bucket := range clusterStats.Buckets {
+		tags := with(tag{"bucket", bucket.Name}, tag{"bucket_type", bucket.BucketType})
+		lines = append(lines, line(prefix+"_bucket", tags, bucket.Metrics(), collected))
+	}
+	return lines
+}
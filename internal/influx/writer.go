@@ -0,0 +1,176 @@
+package influx
+
+import (
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/monitor/stats"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errPermanent failures that retrying cannot fix (rejected points, bad credentials)
+var errPermanent = errors.New("permanent failure")
+
+// Writer buffers line protocol points and pushes them in batches to an InfluxDB write endpoint
+type Writer struct {
+	config     config.Influx
+	client     *http.Client
+	lines      []string
+	dropped    int
+	retryDelay time.Duration
+	ready      chan struct{}
+	mu         sync.Mutex
+	flushMu    sync.Mutex
+}
+
+// NewWriter creates a writer for the given (normalized) output configuration
+func NewWriter(output config.Influx) *Writer {
+	return &Writer{
+		config:     output,
+		client:     &http.Client{Timeout: output.Timeout.Duration},
+		retryDelay: time.Second,
+		ready:      make(chan struct{}, 1),
+	}
+}
+
+// Add converts the statistics of a cluster into points and queues them. The oldest points are
+// dropped when the buffer is full
+func (w *Writer) Add(cluster string, collected time.Time, clusterStats stats.ClusterStats) {
+	lines := Lines(w.config.Measurement, cluster, collected, clusterStats)
+	w.mu.Lock()
+	for _, line := range lines {
+		if line != "" {
+			w.lines = append(w.lines, line)
+		}
+	}
+	w.trim()
+	full := len(w.lines) >= w.config.BatchSize
+	w.mu.Unlock()
+	if full {
+		select {
+		case w.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// trim drops the oldest points beyond the buffer size, mu must be held
+func (w *Writer) trim() {
+	if excess := len(w.lines) - w.config.BufferSize; excess > 0 {
+		w.dropped += excess
+		w.lines = append([]string{}, w.lines[excess:]...)
+		slog.Warn("InfluxDB output buffer full, dropped points", "dropped", excess, "total_dropped", w.dropped)
+	}
+}
+
+// Pending returns the number of queued points
+func (w *Writer) Pending() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.lines)
+}
+
+// Run pushes the queued points every flush interval, or as soon as a batch is full, until stop is closed
+func (w *Writer) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(w.config.FlushInterval.Duration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			if err := w.Flush(); err != nil {
+				slog.Error("Cannot push points to InfluxDB", "error", err)
+			}
+			return
+		case <-ticker.C:
+		case <-w.ready:
+		}
+		if err := w.Flush(); err != nil {
+			slog.Error("Cannot push points to InfluxDB", "error", err)
+		}
+	}
+}
+
+// Flush pushes the queued points in batches. Batches that keep failing are put back in the queue to
+// be sent on the next flush, batches rejected by InfluxDB are dropped
+func (w *Writer) Flush() error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	var lastErr error
+	for {
+		w.mu.Lock()
+		size := len(w.lines)
+		if size > w.config.BatchSize {
+			size = w.config.BatchSize
+		}
+		batch := w.lines[:size:size]
+		w.lines = w.lines[size:]
+		w.mu.Unlock()
+		if len(batch) == 0 {
+			return lastErr
+		}
+		err := w.sendWithRetries(batch)
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, errPermanent) {
+			slog.Error("InfluxDB rejected points", "points", len(batch), "error", err)
+			lastErr = err
+			continue
+		}
+		w.mu.Lock()
+		w.lines = append(batch, w.lines...)
+		w.trim()
+		w.mu.Unlock()
+		return err
+	}
+}
+
+func (w *Writer) sendWithRetries(batch []string) error {
+	body := strings.Join(batch, "\n")
+	delay := w.retryDelay
+	var err error
+	for attempt := 0; attempt <= w.config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		if err = w.send(body); err == nil || errors.Is(err, errPermanent) {
+			return err
+		}
+	}
+	return err
+}
+
+func (w *Writer) send(body string) error {
+	req, err := http.NewRequest(http.MethodPost, w.config.URL, strings.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %s", errPermanent, err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.config.Token != "" {
+		req.Header.Set("Authorization", "Token "+w.config.Token)
+	} else if w.config.Username != "" {
+		req.SetBasicAuth(w.config.Username, w.config.Password)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout ||
+		resp.StatusCode >= 500:
+		return fmt.Errorf("write endpoint answered %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return fmt.Errorf("%w: write endpoint answered %d: %s", errPermanent, resp.StatusCode,
+		strings.TrimSpace(string(message)))
+}
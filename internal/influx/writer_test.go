@@ -0,0 +1,196 @@
+package influx
+
+import (
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/monitor/stats"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder local write endpoint recording the bodies it receives, it answers the given statuses in
+// turn and then 204
+type recorder struct {
+	server   *httptest.Server
+	statuses []int
+	bodies   []string
+	requests int
+	mu       sync.Mutex
+}
+
+func newRecorder(statuses ...int) *recorder {
+	r := &recorder{statuses: statuses}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests++
+		status := http.StatusNoContent
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		if status < 300 {
+			r.bodies = append(r.bodies, string(body))
+		}
+		w.WriteHeader(status)
+	}))
+	return r
+}
+
+func (r *recorder) recorded() ([]string, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.bodies...), r.requests
+}
+
+func newTestWriter(url string, batchSize, bufferSize, maxRetries int) *Writer {
+	w := NewWriter(config.Influx{
+		URL:         url,
+		Measurement: "couchbase",
+		BatchSize:   batchSize,
+		BufferSize:  bufferSize,
+		MaxRetries:  maxRetries,
+		Timeout:     config.Duration{Duration: time.Second},
+	})
+	w.retryDelay = time.Millisecond
+	return w
+}
+
+// addPoints queues one cluster point per timestamp, from 1 to count
+func addPoints(w *Writer, count int) {
+	for i := 1; i <= count; i++ {
+		w.Add("prod", time.Unix(0, int64(i)), stats.ClusterStats{Name: "prod"})
+	}
+}
+
+func TestLineEscaping(t *testing.T) {
+	got := line("cpu load,total", []tag{{"host,name", "a b=c"}, {"empty", ""}},
+		map[string]float64{"b": 2, "a b": 1.5}, time.Unix(0, 42))
+	want := `cpu\ load\,total,host\,name=a\ b\=c a\ b=1.5,b=2 42`
+	if got != want {
+		t.Errorf("line() = %q, want %q", got, want)
+	}
+	if got := line("empty", nil, map[string]float64{}, time.Unix(0, 42)); got != "" {
+		t.Errorf("line() without fields = %q, want no line", got)
+	}
+}
+
+func TestLines(t *testing.T) {
+	clusterStats := stats.ClusterStats{
+		Name:    "east",
+		Labels:  map[string]string{"team": "pay,ments", "env": "prod"},
+		Nodes:   []stats.Node{{Hostname: "10.0.0.1:8091", Version: "7.2.0", Status: "healthy"}},
+		Buckets: []stats.Bucket{{Name: "travel sample", BucketType: "membase"}},
+	}
+	lines := Lines("couchbase", "prod east", time.Unix(0, 42), clusterStats)
+	prefixes := []string{
+		`couchbase_cluster,cluster=prod\ east,env=prod,team=pay\,ments,cluster_name=east `,
+		`couchbase_node,cluster=prod\ east,env=prod,team=pay\,ments,node=10.0.0.1:8091,version=7.2.0,status=healthy `,
+		`couchbase_bucket,cluster=prod\ east,env=prod,team=pay\,ments,bucket=travel\ sample,bucket_type=membase `,
+	}
+	if len(lines) != len(prefixes) {
+		t.Fatalf("Lines() returned %d lines, want %d", len(lines), len(prefixes))
+	}
+	for i, prefix := range prefixes {
+		if !strings.HasPrefix(lines[i], prefix) || !strings.HasSuffix(lines[i], " 42") {
+			t.Errorf("line %d = %q, want prefix %q and timestamp 42", i, lines[i], prefix)
+		}
+	}
+}
+
+func TestFlushBatches(t *testing.T) {
+	r := newRecorder()
+	defer r.server.Close()
+	w := newTestWriter(r.server.URL, 2, 100, 0)
+	addPoints(w, 5)
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() = %v", err)
+	}
+	bodies, _ := r.recorded()
+	if len(bodies) != 3 {
+		t.Fatalf("got %d batches, want 3", len(bodies))
+	}
+	for i, want := range []int{2, 2, 1} {
+		if lines := strings.Split(bodies[i], "\n"); len(lines) != want {
+			t.Errorf("batch %d has %d points, want %d", i, len(lines), want)
+		}
+	}
+	if pending := w.Pending(); pending != 0 {
+		t.Errorf("Pending() = %d after flush, want 0", pending)
+	}
+}
+
+func TestFlushRetriesServerErrors(t *testing.T) {
+	r := newRecorder(http.StatusServiceUnavailable, http.StatusInternalServerError)
+	defer r.server.Close()
+	w := newTestWriter(r.server.URL, 10, 100, 3)
+	addPoints(w, 2)
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() = %v", err)
+	}
+	bodies, requests := r.recorded()
+	if requests != 3 || len(bodies) != 1 {
+		t.Errorf("got %d requests and %d delivered batches, want 3 and 1", requests, len(bodies))
+	}
+}
+
+func TestFlushKeepsPointsAfterRetries(t *testing.T) {
+	r := newRecorder(http.StatusBadGateway, http.StatusBadGateway)
+	defer r.server.Close()
+	w := newTestWriter(r.server.URL, 10, 100, 1)
+	addPoints(w, 2)
+	if err := w.Flush(); err == nil || errors.Is(err, errPermanent) {
+		t.Fatalf("Flush() = %v, want a temporary error", err)
+	}
+	if pending := w.Pending(); pending != 2 {
+		t.Fatalf("Pending() = %d, want the 2 points kept", pending)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("second Flush() = %v", err)
+	}
+	if bodies, _ := r.recorded(); len(bodies) != 1 {
+		t.Errorf("got %d delivered batches, want 1", len(bodies))
+	}
+}
+
+func TestFlushDropsRejectedBatches(t *testing.T) {
+	r := newRecorder(http.StatusBadRequest)
+	defer r.server.Close()
+	w := newTestWriter(r.server.URL, 2, 100, 3)
+	addPoints(w, 3)
+	if err := w.Flush(); !errors.Is(err, errPermanent) {
+		t.Fatalf("Flush() = %v, want a permanent error", err)
+	}
+	bodies, requests := r.recorded()
+	if requests != 2 || len(bodies) != 1 {
+		t.Errorf("got %d requests and %d delivered batches, want 2 (no retry) and 1", requests, len(bodies))
+	}
+	if pending := w.Pending(); pending != 0 {
+		t.Errorf("Pending() = %d, want the rejected batch dropped", pending)
+	}
+}
+
+func TestBufferDropsOldestPoints(t *testing.T) {
+	r := newRecorder()
+	defer r.server.Close()
+	w := newTestWriter(r.server.URL, 10, 3, 0)
+	addPoints(w, 5)
+	if pending := w.Pending(); pending != 3 {
+		t.Fatalf("Pending() = %d, want the buffer size 3", pending)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() = %v", err)
+	}
+	bodies, _ := r.recorded()
+	lines := strings.Split(bodies[0], "\n")
+	for i, timestamp := range []string{" 3", " 4", " 5"} {
+		if !strings.HasSuffix(lines[i], timestamp) {
+			t.Errorf("point %d = %q, want timestamp%s", i, lines[i], timestamp)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package labels
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Selector required label values, a cluster matches when each of its labels named in the selector has
+// one of the listed values
+type Selector map[string][]string
+
+// FromQuery builds a selector from the query parameters, except the reserved ones: ?env=prod&team=payments
+// selects the clusters of the payments team in production, ?env=prod&env=staging those of both
+// environments
+func FromQuery(query url.Values, reserved ...string) Selector {
+	selector := Selector{}
+	for name, values := range query {
+		if !contains(reserved, name) {
+			selector[name] = values
+		}
+	}
+	return selector
+}
+
+// Parse reads a selector written as comma separated name=value pairs: env=prod,team=payments. Repeating a
+// name accepts any of its values
+func Parse(text string) (Selector, error) {
+	selector := Selector{}
+	for _, pair := range strings.Split(text, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" {
+			return nil, fmt.Errorf("invalid label selector %q, expected name=value", pair)
+		}
+		selector[name] = append(selector[name], strings.TrimSpace(parts[1]))
+	}
+	return selector, nil
+}
+
+// Matches tells whether the labels satisfy the selector, an empty selector matches every label set
+func (s Selector) Matches(labels map[string]string) bool {
+	for name, values := range s {
+		value, found := labels[name]
+		if !found || !contains(values, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Sorted returns the names of the labels in alphabetical order
+func Sorted(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,319 @@
+package lifecycle
+
+import (
+	"cbmonitor/internal/alerts"
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/storage"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Record states
+const (
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+const (
+	documentName = "alerts"
+	stateVersion = 1
+)
+
+var (
+	// ErrUnknownRecord no record has the given ID
+	ErrUnknownRecord = errors.New("unknown alert")
+	// ErrResolved the alert is resolved and cannot be acknowledged anymore
+	ErrResolved = errors.New("alert already resolved")
+)
+
+// Acknowledgement who acknowledged an alert and when
+type Acknowledgement struct {
+	By      string    `json:"by"`
+	Comment string    `json:"comment,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// Record lifecycle of an alert: Alert is its latest evaluation, Peak the worst value it reached, the
+// highest or, for the alerts raised by low values, the lowest
+type Record struct {
+	ID              int64            `json:"id"`
+	Alert           alerts.Alert     `json:"alert"`
+	State           string           `json:"state"`
+	FiredAt         time.Time        `json:"firedAt"`
+	ResolvedAt      *time.Time       `json:"resolvedAt,omitempty"`
+	Duration        string           `json:"duration"`
+	Peak            float64          `json:"peak,omitempty"`
+	Acknowledgement *Acknowledgement `json:"acknowledgement,omitempty"`
+}
+
+// active tells whether the alert was still firing at the given time
+func (r Record) active(at time.Time) bool {
+	return r.ResolvedAt == nil || r.ResolvedAt.After(at)
+}
+
+// Query selects records: those of Clusters (all without), in State (any without) and firing at some
+// point between From and To (zero times leave the range open)
+type Query struct {
+	Clusters []string
+	State    string
+	From     time.Time
+	To       time.Time
+}
+
+func (q Query) matches(record Record) bool {
+	if q.State != "" && record.State != q.State {
+		return false
+	}
+	if !q.To.IsZero() && record.FiredAt.After(q.To) {
+		return false
+	}
+	if !q.From.IsZero() && !record.active(q.From) {
+		return false
+	}
+	if len(q.Clusters) == 0 {
+		return true
+	}
+	for _, cluster := range q.Clusters {
+		if cluster == record.Alert.Cluster {
+			return true
+		}
+	}
+	return false
+}
+
+// state persisted records
+type state struct {
+	Version int      `json:"version"`
+	LastID  int64    `json:"lastId"`
+	Records []Record `json:"records"`
+}
+
+// Tracker records when the raised alerts fire and resolve, and their acknowledgements
+type Tracker struct {
+	config  config.AlertHistory
+	store   *storage.Store
+	records []Record
+	firing  map[string]int
+	lastID  int64
+	dirty   bool
+	mu      sync.Mutex
+}
+
+// NewTracker creates a tracker for the given (normalized) settings, records saved in the store are
+// loaded back
+func NewTracker(settings config.AlertHistory, store *storage.Store) (*Tracker, error) {
+	t := &Tracker{config: settings, store: store, records: []Record{}, firing: map[string]int{}}
+	var saved state
+	found, err := store.Load(documentName, &saved)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load alert history: %w", err)
+	}
+	if found && saved.Version == stateVersion && saved.Records != nil {
+		t.records = saved.Records
+		t.lastID = saved.LastID
+		t.index()
+		slog.Info("Alert history loaded", "file", store.Path(documentName), "records", len(saved.Records))
+	}
+	return t, nil
+}
+
+// index rebuilds the position of the firing records by alert fingerprint. Of the records firing for the
+// same alert, saved when severity told alerts apart, the earlier ones resolve when the latest fired
+func (t *Tracker) index() {
+	t.firing = map[string]int{}
+	for i, record := range t.records {
+		if record.State != StateFiring {
+			continue
+		}
+		id := alerts.Fingerprint(record.Alert)
+		if previous, found := t.firing[id]; found {
+			resolved := record.FiredAt
+			t.records[previous].State = StateResolved
+			t.records[previous].ResolvedAt = &resolved
+			t.dirty = true
+		}
+		t.firing[id] = i
+	}
+}
+
+// Observe records the alerts raised at the given time: new alerts fire, the firing alerts of the evaluated
+// clusters that are not raised anymore resolve. Alerts of the clusters that were not evaluated (not scraped
+// yet) are left as they are. It returns the raised alerts flagged when acknowledged
+func (t *Tracker) Observe(evaluated []string, raised []alerts.Alert, now time.Time) []alerts.Alert {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	marked := make([]alerts.Alert, len(raised))
+	seen := make(map[string]bool, len(raised))
+	for i, alert := range raised {
+		id := alerts.Fingerprint(alert)
+		seen[id] = true
+		position, found := t.firing[id]
+		if !found {
+			t.lastID++
+			t.records = append(t.records, Record{
+				ID:      t.lastID,
+				Alert:   alert,
+				State:   StateFiring,
+				FiredAt: now,
+				Peak:    alert.Value,
+			})
+			position = len(t.records) - 1
+			t.firing[id] = position
+			t.dirty = true
+		}
+		record := &t.records[position]
+		alert.Acknowledged = record.Acknowledgement != nil
+		record.Alert = alert
+		if alert.Below && alert.Value < record.Peak || !alert.Below && alert.Value > record.Peak {
+			record.Peak = alert.Value
+			t.dirty = true
+		}
+		marked[i] = alert
+	}
+	clusters := make(map[string]bool, len(evaluated))
+	for _, cluster := range evaluated {
+		clusters[cluster] = true
+	}
+	for id, position := range t.firing {
+		record := &t.records[position]
+		if seen[id] || !clusters[record.Alert.Cluster] {
+			continue
+		}
+		resolved := now
+		record.State = StateResolved
+		record.ResolvedAt = &resolved
+		delete(t.firing, id)
+		t.dirty = true
+	}
+	t.prune(now)
+	return marked
+}
+
+// prune forgets the records resolved before the retention
+func (t *Tracker) prune(now time.Time) {
+	limit := now.Add(-t.config.Retention.Duration)
+	kept := t.records[:0]
+	for _, record := range t.records {
+		if record.ResolvedAt == nil || record.ResolvedAt.After(limit) {
+			kept = append(kept, record)
+		}
+	}
+	if len(kept) != len(t.records) {
+		t.records = kept
+		t.index()
+		t.dirty = true
+	}
+}
+
+// Records returns the records selected by the query, the latest fired first
+func (t *Tracker) Records(query Query, now time.Time) []Record {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	selected := []Record{}
+	for _, record := range t.records {
+		if !query.matches(record) {
+			continue
+		}
+		end := now
+		if record.ResolvedAt != nil {
+			end = *record.ResolvedAt
+		}
+		record.Duration = end.Sub(record.FiredAt).Round(time.Second).String()
+		selected = append(selected, record)
+	}
+	sort.SliceStable(selected, func(i, j int) bool {
+		return selected[i].ID > selected[j].ID
+	})
+	return selected
+}
+
+// Acknowledge records who acknowledged the firing alert with the given ID, acknowledging it again
+// replaces the previous acknowledgement
+func (t *Tracker) Acknowledge(id int64, acknowledgement Acknowledgement) (Record, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.records {
+		record := &t.records[i]
+		if record.ID != id {
+			continue
+		}
+		if record.State != StateFiring {
+			return Record{}, ErrResolved
+		}
+		record.Acknowledgement = &acknowledgement
+		record.Alert.Acknowledged = true
+		t.dirty = true
+		acknowledged := *record
+		acknowledged.Duration = acknowledgement.Time.Sub(record.FiredAt).Round(time.Second).String()
+		return acknowledged, nil
+	}
+	return Record{}, ErrUnknownRecord
+}
+
+// Adopt copies the acknowledgements of the firing records of another replica, the leader, to the firing
+// records of the same alerts
+func (t *Tracker) Adopt(records []Record) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, adopted := range records {
+		if adopted.State != StateFiring || adopted.Acknowledgement == nil {
+			continue
+		}
+		position, found := t.firing[alerts.Fingerprint(adopted.Alert)]
+		if !found || t.records[position].Acknowledgement != nil {
+			continue
+		}
+		acknowledgement := *adopted.Acknowledgement
+		t.records[position].Acknowledgement = &acknowledgement
+		t.records[position].Alert.Acknowledged = true
+		t.dirty = true
+	}
+}
+
+// Forget resolves the firing records of the clusters at the given time, when the clusters are not
+// monitored by this instance anymore and their alerts cannot be observed to resolve
+func (t *Tracker) Forget(clusters []string, now time.Time) {
+	forgotten := make(map[string]bool, len(clusters))
+	for _, cluster := range clusters {
+		forgotten[cluster] = true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, position := range t.firing {
+		record := &t.records[position]
+		if !forgotten[record.Alert.Cluster] {
+			continue
+		}
+		resolved := now
+		record.State = StateResolved
+		record.ResolvedAt = &resolved
+		delete(t.firing, id)
+		t.dirty = true
+	}
+}
+
+// Save persists the records when they changed since the last save
+func (t *Tracker) Save() error {
+	if !t.store.Enabled() {
+		return nil
+	}
+	t.mu.Lock()
+	if !t.dirty {
+		t.mu.Unlock()
+		return nil
+	}
+	saved := state{Version: stateVersion, LastID: t.lastID, Records: append([]Record{}, t.records...)}
+	t.dirty = false
+	t.mu.Unlock()
+	if err := t.store.Save(documentName, saved); err != nil {
+		t.mu.Lock()
+		t.dirty = true
+		t.mu.Unlock()
+		return err
+	}
+	return nil
+}
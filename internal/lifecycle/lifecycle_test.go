@@ -0,0 +1,300 @@
+package lifecycle
+
+import (
+	"cbmonitor/internal/alerts"
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/storage"
+	"errors"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+// newTestTracker creates a tracker keeping the resolved records an hour, saved in dir (not saved without)
+func newTestTracker(t *testing.T, dir string) *Tracker {
+	t.Helper()
+	store, err := storage.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker, err := NewTracker(config.AlertHistory{Retention: config.Duration{Duration: time.Hour}}, store)
+	if err != nil {
+		t.Fatalf("NewTracker() = %v", err)
+	}
+	return tracker
+}
+
+func ruleAlert(cluster, name string, severity alerts.Severity, value float64) alerts.Alert {
+	return alerts.Alert{Cluster: cluster, Type: alerts.TypeRule, Name: name, Severity: severity, Metric: name,
+		Value: value}
+}
+
+// states returns the state of the records by ID
+func states(tracker *Tracker, now time.Time) map[int64]string {
+	states := map[int64]string{}
+	for _, record := range tracker.Records(Query{}, now) {
+		states[record.ID] = record.State
+	}
+	return states
+}
+
+func TestObserveFiresAndResolves(t *testing.T) {
+	tracker := newTestTracker(t, "")
+	cpu := ruleAlert("east", "cpu", alerts.SeverityWarning, 85)
+	memory := ruleAlert("east", "memory", alerts.SeverityCritical, 95)
+	tracker.Observe([]string{"east"}, []alerts.Alert{cpu, memory}, start)
+	tracker.Observe([]string{"east"}, []alerts.Alert{cpu}, start.Add(time.Minute))
+
+	records := tracker.Records(Query{}, start.Add(2*time.Minute))
+	if len(records) != 2 {
+		t.Fatalf("Records() = %d records, want 2", len(records))
+	}
+	if resolved := records[0]; resolved.Alert.Name != "memory" || resolved.State != StateResolved ||
+		resolved.ResolvedAt == nil || !resolved.ResolvedAt.Equal(start.Add(time.Minute)) || resolved.Duration != "1m0s" {
+		t.Errorf("memory record = %+v, want resolved after 1m0s", resolved)
+	}
+	if firing := records[1]; firing.Alert.Name != "cpu" || firing.State != StateFiring || firing.ID != 1 ||
+		!firing.FiredAt.Equal(start) || firing.Duration != "2m0s" {
+		t.Errorf("cpu record = %+v, want firing for 2m0s", firing)
+	}
+
+	tracker.Observe([]string{"east"}, []alerts.Alert{memory}, start.Add(3*time.Minute))
+	if got := states(tracker, start.Add(3*time.Minute)); len(got) != 3 || got[3] != StateFiring ||
+		got[1] != StateResolved {
+		t.Errorf("states after memory fired again = %v, want a new firing record", got)
+	}
+}
+
+func TestObserveLeavesClustersNotEvaluated(t *testing.T) {
+	tracker := newTestTracker(t, "")
+	tracker.Observe([]string{"east", "west"}, []alerts.Alert{
+		ruleAlert("east", "cpu", alerts.SeverityWarning, 85),
+		ruleAlert("west", "cpu", alerts.SeverityWarning, 85),
+	}, start)
+	tracker.Observe([]string{"east"}, nil, start.Add(time.Minute))
+	for _, record := range tracker.Records(Query{}, start.Add(time.Minute)) {
+		want := StateFiring
+		if record.Alert.Cluster == "east" {
+			want = StateResolved
+		}
+		if record.State != want {
+			t.Errorf("%s record is %s, want %s", record.Alert.Cluster, record.State, want)
+		}
+	}
+}
+
+func TestObserveKeepsIdentityAndPeak(t *testing.T) {
+	tests := []struct {
+		name   string
+		below  bool
+		values []float64
+		peak   float64
+	}{
+		{name: "above", values: []float64{85, 97, 90}, peak: 97},
+		{name: "below", below: true, values: []float64{15, 8, 12}, peak: 8},
+	}
+	for _, test := range tests {
+		tracker := newTestTracker(t, "")
+		for i, value := range test.values {
+			alert := ruleAlert("east", "free", alerts.SeverityWarning, value)
+			alert.Below = test.below
+			if i == 1 {
+				alert.Severity = alerts.SeverityCritical
+			}
+			tracker.Observe([]string{"east"}, []alerts.Alert{alert}, start.Add(time.Duration(i)*time.Minute))
+		}
+		records := tracker.Records(Query{}, start.Add(time.Hour))
+		if len(records) != 1 {
+			t.Fatalf("%s: %d records, want one across severity changes", test.name, len(records))
+		}
+		if records[0].Peak != test.peak || records[0].Alert.Value != test.values[2] {
+			t.Errorf("%s: peak %v and value %v, want %v and %v", test.name, records[0].Peak, records[0].Alert.Value,
+				test.peak, test.values[2])
+		}
+	}
+}
+
+func TestAcknowledge(t *testing.T) {
+	tracker := newTestTracker(t, "")
+	cpu := ruleAlert("east", "cpu", alerts.SeverityWarning, 85)
+	tracker.Observe([]string{"east"}, []alerts.Alert{cpu}, start)
+	acknowledged, err := tracker.Acknowledge(1, Acknowledgement{By: "ops", Time: start.Add(time.Minute)})
+	if err != nil {
+		t.Fatalf("Acknowledge() = %v", err)
+	}
+	if !acknowledged.Alert.Acknowledged || acknowledged.Acknowledgement.By != "ops" || acknowledged.Duration != "1m0s" {
+		t.Errorf("Acknowledge() = %+v, want acknowledged by ops", acknowledged)
+	}
+	marked := tracker.Observe([]string{"east"}, []alerts.Alert{cpu}, start.Add(2*time.Minute))
+	if !marked[0].Acknowledged {
+		t.Errorf("Observe() did not flag the acknowledged alert")
+	}
+
+	tracker.Observe([]string{"east"}, nil, start.Add(3*time.Minute))
+	tests := []struct {
+		id   int64
+		want error
+	}{
+		{id: 1, want: ErrResolved},
+		{id: 42, want: ErrUnknownRecord},
+	}
+	for _, test := range tests {
+		if _, err := tracker.Acknowledge(test.id, Acknowledgement{By: "ops"}); !errors.Is(err, test.want) {
+			t.Errorf("Acknowledge(%d) = %v, want %v", test.id, err, test.want)
+		}
+	}
+	marked = tracker.Observe([]string{"east"}, []alerts.Alert{cpu}, start.Add(4*time.Minute))
+	if marked[0].Acknowledged {
+		t.Errorf("the acknowledgement outlived the resolved record")
+	}
+}
+
+func TestAdopt(t *testing.T) {
+	leader, standby := newTestTracker(t, ""), newTestTracker(t, "")
+	cpu := ruleAlert("east", "cpu", alerts.SeverityWarning, 85)
+	memory := ruleAlert("east", "memory", alerts.SeverityWarning, 85)
+	disk := ruleAlert("east", "disk", alerts.SeverityWarning, 85)
+	leader.Observe([]string{"east"}, []alerts.Alert{memory, cpu, disk}, start)
+	standby.Observe([]string{"east"}, []alerts.Alert{cpu, disk}, start)
+	leader.Acknowledge(2, Acknowledgement{By: "leader", Time: start})
+	leader.Acknowledge(3, Acknowledgement{By: "leader", Time: start})
+	standby.Acknowledge(2, Acknowledgement{By: "standby", Time: start})
+	standby.Adopt(leader.Records(Query{}, start))
+
+	byName := map[string]Record{}
+	for _, record := range standby.Records(Query{}, start) {
+		byName[record.Alert.Name] = record
+	}
+	if len(byName) != 2 {
+		t.Fatalf("Adopt() created records: %v", byName)
+	}
+	if cpu := byName["cpu"]; cpu.Acknowledgement == nil || cpu.Acknowledgement.By != "leader" ||
+		!cpu.Alert.Acknowledged {
+		t.Errorf("cpu acknowledgement = %+v, want adopted from the leader", cpu.Acknowledgement)
+	}
+	if disk := byName["disk"]; disk.Acknowledgement == nil || disk.Acknowledgement.By != "standby" {
+		t.Errorf("disk acknowledgement = %+v, want the standby one kept", disk.Acknowledgement)
+	}
+}
+
+func TestForget(t *testing.T) {
+	tracker := newTestTracker(t, "")
+	tracker.Observe([]string{"east", "west"}, []alerts.Alert{
+		ruleAlert("east", "cpu", alerts.SeverityWarning, 85),
+		ruleAlert("west", "cpu", alerts.SeverityWarning, 85),
+	}, start)
+	tracker.Forget([]string{"west"}, start.Add(time.Minute))
+	for _, record := range tracker.Records(Query{}, start.Add(time.Minute)) {
+		want := StateFiring
+		if record.Alert.Cluster == "west" {
+			want = StateResolved
+		}
+		if record.State != want {
+			t.Errorf("%s record is %s, want %s", record.Alert.Cluster, record.State, want)
+		}
+	}
+	west := ruleAlert("west", "cpu", alerts.SeverityWarning, 85)
+	tracker.Observe([]string{"west"}, []alerts.Alert{west}, start.Add(2*time.Minute))
+	if got := states(tracker, start.Add(2*time.Minute)); len(got) != 3 || got[3] != StateFiring {
+		t.Errorf("states after west came back = %v, want a new firing record", got)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	tracker := newTestTracker(t, "")
+	cpu := ruleAlert("east", "cpu", alerts.SeverityWarning, 85)
+	memory := ruleAlert("east", "memory", alerts.SeverityWarning, 85)
+	tracker.Observe([]string{"east"}, []alerts.Alert{cpu, memory}, start)
+	tracker.Observe([]string{"east"}, []alerts.Alert{memory}, start.Add(time.Minute))
+	tracker.Observe([]string{"east"}, []alerts.Alert{memory}, start.Add(time.Hour+2*time.Minute))
+	records := tracker.Records(Query{}, start.Add(time.Hour+2*time.Minute))
+	if len(records) != 1 || records[0].Alert.Name != "memory" {
+		t.Fatalf("Records() after retention = %+v, want the firing memory record only", records)
+	}
+	tracker.Observe([]string{"east"}, nil, start.Add(time.Hour+3*time.Minute))
+	if got := states(tracker, start.Add(time.Hour+3*time.Minute)); got[2] != StateResolved {
+		t.Errorf("memory did not resolve after pruning: %v", got)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	tracker := newTestTracker(t, "")
+	tracker.Observe([]string{"east", "west"}, []alerts.Alert{
+		ruleAlert("east", "cpu", alerts.SeverityWarning, 85),
+		ruleAlert("west", "cpu", alerts.SeverityWarning, 85),
+	}, start)
+	tracker.Observe([]string{"east"}, nil, start.Add(10*time.Minute))
+	tests := []struct {
+		name  string
+		query Query
+		want  int
+	}{
+		{name: "all", query: Query{}, want: 2},
+		{name: "cluster", query: Query{Clusters: []string{"west"}}, want: 1},
+		{name: "state", query: Query{State: StateResolved}, want: 1},
+		{name: "after resolution", query: Query{From: start.Add(20 * time.Minute)}, want: 1},
+		{name: "before firing", query: Query{To: start.Add(-time.Minute)}, want: 0},
+	}
+	for _, test := range tests {
+		if got := tracker.Records(test.query, start.Add(30*time.Minute)); len(got) != test.want {
+			t.Errorf("%s: Records() = %d records, want %d", test.name, len(got), test.want)
+		}
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	dir := t.TempDir()
+	tracker := newTestTracker(t, dir)
+	cpu := ruleAlert("east", "cpu", alerts.SeverityWarning, 85)
+	memory := ruleAlert("east", "memory", alerts.SeverityWarning, 85)
+	tracker.Observe([]string{"east"}, []alerts.Alert{cpu, memory}, start)
+	tracker.Observe([]string{"east"}, []alerts.Alert{cpu}, start.Add(time.Minute))
+	tracker.Acknowledge(1, Acknowledgement{By: "ops", Comment: "looking", Time: start.Add(time.Minute)})
+	if err := tracker.Save(); err != nil {
+		t.Fatalf("Save() = %v", err)
+	}
+
+	loaded := newTestTracker(t, dir)
+	saved, got := tracker.Records(Query{}, start.Add(time.Minute)), loaded.Records(Query{}, start.Add(time.Minute))
+	if len(got) != len(saved) {
+		t.Fatalf("loaded %d records, want %d", len(got), len(saved))
+	}
+	for i := range saved {
+		if got[i].ID != saved[i].ID || got[i].State != saved[i].State || !got[i].FiredAt.Equal(saved[i].FiredAt) ||
+			got[i].Alert.Name != saved[i].Alert.Name {
+			t.Errorf("loaded record %+v, want %+v", got[i], saved[i])
+		}
+	}
+	if ack := got[1].Acknowledgement; ack == nil || ack.By != "ops" || ack.Comment != "looking" {
+		t.Errorf("loaded acknowledgement = %+v, want by ops", ack)
+	}
+	loaded.Observe([]string{"east"}, []alerts.Alert{cpu, memory}, start.Add(2*time.Minute))
+	if got := states(loaded, start.Add(2*time.Minute)); len(got) != 3 || got[1] != StateFiring || got[3] != StateFiring {
+		t.Errorf("states after loading = %v, want cpu still firing and memory firing as record 3", got)
+	}
+}
+
+func TestLoadResolvesDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := storage.Open(dir)
+	warning, critical := ruleAlert("east", "cpu", alerts.SeverityWarning, 85), ruleAlert("east", "cpu",
+		alerts.SeverityCritical, 95)
+	// saved when the severity was part of the fingerprint
+	saved := state{Version: stateVersion, LastID: 2, Records: []Record{
+		{ID: 1, Alert: warning, State: StateFiring, FiredAt: start},
+		{ID: 2, Alert: critical, State: StateFiring, FiredAt: start.Add(time.Minute)},
+	}}
+	if err := store.Save(documentName, saved); err != nil {
+		t.Fatal(err)
+	}
+	tracker := newTestTracker(t, dir)
+	got := states(tracker, start.Add(2*time.Minute))
+	if got[1] != StateResolved || got[2] != StateFiring {
+		t.Errorf("states = %v, want the earlier duplicate resolved", got)
+	}
+	tracker.Observe([]string{"east"}, []alerts.Alert{critical}, start.Add(2*time.Minute))
+	if got := states(tracker, start.Add(2*time.Minute)); len(got) != 2 || got[2] != StateFiring {
+		t.Errorf("states after observing = %v, want record 2 still firing", got)
+	}
+}
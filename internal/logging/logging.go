@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+const (
+	// FormatJSON one JSON object per entry
+	FormatJSON = "json"
+	// FormatLogfmt key=value pairs per entry
+	FormatLogfmt = "logfmt"
+)
+
+// Keys of the context attributes shared by every entry about a scrape
+const (
+	ClusterKey  = "cluster"
+	HostKey     = "host"
+	ScrapeIDKey = "scrape_id"
+)
+
+// ParseLevel reads a level name: debug, info, warn or error
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q, expected debug, info, warn or error", name)
+	}
+	return level, nil
+}
+
+// NewLogger creates a logger writing entries of the given level and above in the given format
+func NewLogger(writer io.Writer, level, format string) (*slog.Logger, error) {
+	minimum, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	options := &slog.HandlerOptions{Level: minimum}
+	switch strings.ToLower(format) {
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(writer, options)), nil
+	case FormatLogfmt, "text":
+		return slog.New(slog.NewTextHandler(writer, options)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q, expected %s or %s", format, FormatJSON, FormatLogfmt)
+}
+
+// Setup makes the logger the default one, entries of the standard log package go through it as well
+func Setup(writer io.Writer, level, format string) error {
+	logger, err := NewLogger(writer, level, format)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
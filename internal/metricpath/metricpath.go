@@ -0,0 +1,74 @@
+package metricpath
+
+import (
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/monitor/stats"
+	"sort"
+	"strings"
+)
+
+// Metric value of a dotted metric path
+type Metric struct {
+	Path  string
+	Value float64
+}
+
+// Sanitize turns a name (hostname, bucket or cluster name) into a valid path segment: every run of
+// characters other than letters, digits, dashes and underscores (dots included) becomes an underscore
+func Sanitize(segment string) string {
+	var builder strings.Builder
+	underscore := false
+	for _, r := range segment {
+		valid := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' ||
+			r == '_'
+		if !valid {
+			if !underscore && builder.Len() > 0 {
+				builder.WriteRune('_')
+			}
+			underscore = true
+			continue
+		}
+		builder.WriteRune(r)
+		underscore = false
+	}
+	sanitized := strings.TrimRight(builder.String(), "_")
+	if sanitized == "" {
+		return "unknown"
+	}
+	return sanitized
+}
+
+func render(template string, values map[string]string) string {
+	for placeholder, value := range values {
+		template = strings.Replace(template, "{"+placeholder+"}", Sanitize(value), -1)
+	}
+	return template
+}
+
+func appendMetrics(metrics []Metric, template string, values map[string]string, fields map[string]float64) []Metric {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values["metric"] = name
+		metrics = append(metrics, Metric{Path: render(template, values), Value: fields[name]})
+	}
+	return metrics
+}
+
+// Metrics returns every numeric value of the cluster, its nodes and its buckets with its path
+func Metrics(paths config.MetricPaths, cluster string, clusterStats stats.ClusterStats) []Metric {
+	metrics := appendMetrics([]Metric{}, paths.Cluster, map[string]string{"cluster": cluster},
+		clusterStats.Metrics())
+	for _, node := range clusterStats.Nodes {
+		metrics = appendMetrics(metrics, paths.Node, map[string]string{"cluster": cluster, "node": node.Hostname},
+			node.Metrics())
+	}
+	for _, bucket := range clusterStats.Buckets {
+		metrics = appendMetrics(metrics, paths.Bucket, map[string]string{"cluster": cluster, "bucket": bucket.Name},
+			bucket.Metrics())
+	}
+	return metrics
+}
@@ -0,0 +1,94 @@
+package metricpath
+
+import (
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/monitor/stats"
+	"strings"
+	"testing"
+)
+
+var testPaths = config.MetricPaths{
+	Cluster: "couchbase.{cluster}.{metric}",
+	Node:    "couchbase.{cluster}.nodes.{node}.{metric}",
+	Bucket:  "couchbase.{cluster}.buckets.{bucket}.{metric}",
+}
+
+func TestSanitize(t *testing.T) {
+	tests := map[string]string{
+		"orders":             "orders",
+		"prod-east_1":        "prod-east_1",
+		"prod.east":          "prod_east",
+		"10.0.0.1:8091":      "10_0_0_1_8091",
+		"a  .. b":            "a_b",
+		".leading":           "leading",
+		"trailing.":          "trailing",
+		"...":                "unknown",
+		"":                   "unknown",
+		"bücket":             "b_cket",
+		"{cluster}":          "cluster",
+		"travel-sample/beer": "travel-sample_beer",
+	}
+	for segment, want := range tests {
+		if got := Sanitize(segment); got != want {
+			t.Errorf("Sanitize(%q) = %q, want %q", segment, got, want)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	clusterStats := stats.ClusterStats{RAMPctUsed: 42.5}
+	clusterStats.AvailableServices.KV = 3
+	clusterStats.Nodes = []stats.Node{{Hostname: "10.0.0.1:8091", MemUsedPct: 61}}
+	clusterStats.Buckets = []stats.Bucket{{Name: "orders", ItemCount: 1200}, {Name: "users.v2", OpsPerSec: 7}}
+	metrics := Metrics(testPaths, "prod.east", clusterStats)
+
+	values := map[string]float64{}
+	scopes := map[string]int{}
+	for _, metric := range metrics {
+		if _, found := values[metric.Path]; found {
+			t.Errorf("path %s emitted twice", metric.Path)
+		}
+		values[metric.Path] = metric.Value
+		for _, scope := range []string{".nodes.", ".buckets."} {
+			if strings.Contains(metric.Path, scope) {
+				scopes[scope]++
+			}
+		}
+		if strings.Count(metric.Path, ".") < 2 || !strings.HasPrefix(metric.Path, "couchbase.prod_east.") {
+			t.Errorf("invalid path %s", metric.Path)
+		}
+	}
+	tests := map[string]float64{
+		"couchbase.prod_east.ram_pct_used":                     42.5,
+		"couchbase.prod_east.services_count_kv":                3,
+		"couchbase.prod_east.nodes.10_0_0_1_8091.mem_pct_used": 61,
+		"couchbase.prod_east.buckets.orders.item_count":        1200,
+		"couchbase.prod_east.buckets.users_v2.ops_per_sec":     7,
+		"couchbase.prod_east.buckets.users_v2.quota_pct_used":  0,
+	}
+	for path, want := range tests {
+		if got, found := values[path]; !found || got != want {
+			t.Errorf("%s = %v (found %v), want %v", path, got, found, want)
+		}
+	}
+	if scopes[".buckets."] != 2*len(clusterStats.Buckets[0].Metrics()) {
+		t.Errorf("%d bucket metrics, want every metric of both buckets", scopes[".buckets."])
+	}
+	if scopes[".nodes."] != len(clusterStats.Nodes[0].Metrics()) {
+		t.Errorf("%d node metrics, want every metric of the node", scopes[".nodes."])
+	}
+}
+
+func TestMetricsTemplates(t *testing.T) {
+	paths := config.MetricPaths{Cluster: "{metric}.{cluster}", Node: "{node}", Bucket: "db.{bucket}.{metric}"}
+	clusterStats := stats.ClusterStats{}
+	clusterStats.Buckets = []stats.Bucket{{Name: "orders"}}
+	for _, metric := range Metrics(paths, "east", clusterStats) {
+		if strings.ContainsAny(metric.Path, "{}") {
+			t.Errorf("placeholder left in %s", metric.Path)
+		}
+		if !strings.HasSuffix(metric.Path, ".east") && !strings.HasPrefix(metric.Path, "db.orders.") {
+			t.Errorf("path %s does not follow its template", metric.Path)
+		}
+	}
+}
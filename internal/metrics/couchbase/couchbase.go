@@ -0,0 +1,88 @@
+package couchbase
+
+import (
+	"cbmonitor/internal/labels"
+	"cbmonitor/internal/metrics"
+	"cbmonitor/internal/monitor"
+	"cbmonitor/internal/monitor/stats"
+)
+
+// Cluster latest statistics and scrape state of a configured cluster, Stats are only exposed when
+// HasStats is set
+type Cluster struct {
+	Name     string
+	State    monitor.State
+	Stats    stats.ClusterStats
+	HasStats bool
+}
+
+var states = []monitor.State{monitor.StateUnknown, monitor.StateUp, monitor.StateDegraded, monitor.StateDown}
+
+// families groups the samples by family name, keeping the help and type of the first sample
+type families struct {
+	byName map[string]*metrics.Family
+}
+
+func (f *families) add(name, help, metricType string, value float64, labels ...metrics.Label) {
+	family, found := f.byName[name]
+	if !found {
+		family = &metrics.Family{Name: name, Help: help, Type: metricType}
+		f.byName[name] = family
+	}
+	family.Samples = append(family.Samples, metrics.Sample{Name: name, Labels: labels, Value: value})
+}
+
+func (f *families) addFields(prefix, help string, fields map[string]float64, labels ...metrics.Label) {
+	for field, value := range fields {
+		f.add(metrics.Name(prefix+field), help, metrics.TypeGauge, value, labels...)
+	}
+}
+
+// with returns a copy of the cluster labels followed by the given label
+func with(clusterLabels []metrics.Label, label metrics.Label) []metrics.Label {
+	return append(append([]metrics.Label{}, clusterLabels...), label)
+}
+
+// Families returns the statistics of the clusters, their nodes and their buckets as gauges named
+// couchbase_cluster_<metric>, couchbase_node_<metric> and couchbase_bucket_<metric> labeled with the
+// configured cluster name and labels, the node hostname and the bucket name
+func Families(clusters []Cluster) []metrics.Family {
+	f := &families{byName: map[string]*metrics.Family{}}
+	for _, cluster := range clusters {
+		clusterLabels := []metrics.Label{{Name: "cluster", Value: cluster.Name}}
+		for _, name := range labels.Sorted(cluster.Stats.Labels) {
+			clusterLabels = append(clusterLabels, metrics.Label{Name: name, Value: cluster.Stats.Labels[name]})
+		}
+		up := 0.0
+		if cluster.State == monitor.StateUp || cluster.State == monitor.StateDegraded {
+			up = 1
+		}
+		f.add("couchbase_cluster_up", "Whether the latest scrape of the cluster collected statistics",
+			metrics.TypeGauge, up, clusterLabels...)
+		for _, state := range states {
+			value := 0.0
+			if cluster.State == state {
+				value = 1
+			}
+			f.add("couchbase_cluster_state", "Scrape state of the cluster", metrics.TypeGauge, value,
+				with(clusterLabels, metrics.Label{Name: "state", Value: string(state)})...)
+		}
+		if !cluster.HasStats {
+			continue
+		}
+		f.addFields("couchbase_cluster_", "Couchbase cluster statistic", cluster.Stats.Metrics(), clusterLabels...)
+		for _, node := range cluster.Stats.Nodes {
+			f.addFields("couchbase_node_", "Couchbase node statistic", node.Metrics(),
+				with(clusterLabels, metrics.Label{Name: "node", Value: node.Hostname})...)
+		}
+		for _, bucket := range cluster.Stats.Buckets {
+			f.addFields("couchbase_bucket_", "Couchbase bucket statistic", bucket.Metrics(),
+				with(clusterLabels, metrics.Label{Name: "bucket", Value: bucket.Name})...)
+		}
+	}
+	all := make([]metrics.Family, 0, len(f.byName))
+	for _, family := range f.byName {
+		all = append(all, *family)
+	}
+	return all
+}
@@ -0,0 +1,236 @@
+package metrics
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets upper bounds in seconds of the duration histograms
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry metrics of cbmonitor itself
+type Registry struct {
+	collectors map[string]collector
+	mu         sync.Mutex
+}
+
+type collector interface {
+	family() Family
+}
+
+// Default registry the package functions register to
+var Default = NewRegistry()
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{collectors: map[string]collector{}}
+}
+
+// register adds the collector, replacing any collector of the same name
+func (r *Registry) register(name string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors[name] = c
+}
+
+// Families returns the current values of every registered metric
+func (r *Registry) Families() []Family {
+	r.mu.Lock()
+	collectors := make([]collector, 0, len(r.collectors))
+	for _, c := range r.collectors {
+		collectors = append(collectors, c)
+	}
+	r.mu.Unlock()
+	families := make([]Family, 0, len(collectors))
+	for _, c := range collectors {
+		families = append(families, c.family())
+	}
+	return families
+}
+
+// vector series of a metric keyed by their label values
+type vector struct {
+	name   string
+	help   string
+	labels []string
+	series map[string][]string
+	mu     sync.Mutex
+}
+
+func newVector(name, help string, labels []string) vector {
+	return vector{name: name, help: help, labels: labels, series: map[string][]string{}}
+}
+
+// key returns the key of the label values, mu must be held
+func (v *vector) key(labelValues []string) string {
+	if len(labelValues) != len(v.labels) {
+		panic("metric " + v.name + " expects " + strconv.Itoa(len(v.labels)) + " label values")
+	}
+	key := strings.Join(labelValues, "\xff")
+	if _, found := v.series[key]; !found {
+		v.series[key] = append([]string{}, labelValues...)
+	}
+	return key
+}
+
+// sortedKeys returns the keys of the series in label order, mu must be held
+func (v *vector) sortedKeys() []string {
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func labelPairs(names, values []string, extra ...Label) []Label {
+	labels := make([]Label, 0, len(names)+len(extra))
+	for i, name := range names {
+		labels = append(labels, Label{Name: name, Value: values[i]})
+	}
+	return append(labels, extra...)
+}
+
+// Counter monotonically increasing values
+type Counter struct {
+	vector
+	values map[string]float64
+}
+
+// NewCounter creates and registers a counter in the registry
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{vector: newVector(name, help, labels), values: map[string]float64{}}
+	r.register(name, c)
+	return c
+}
+
+// NewCounter creates and registers a counter in the default registry
+func NewCounter(name, help string, labels ...string) *Counter {
+	return Default.NewCounter(name, help, labels...)
+}
+
+// Add increases the counter of the label values
+func (c *Counter) Add(value float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[c.key(labelValues)] += value
+}
+
+// Inc increases the counter of the label values by one
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) family() Family {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	family := Family{Name: c.name, Help: c.help, Type: TypeCounter}
+	for _, key := range c.sortedKeys() {
+		family.Samples = append(family.Samples, Sample{Name: c.name, Labels: labelPairs(c.labels, c.series[key]),
+			Value: c.values[key]})
+	}
+	return family
+}
+
+// Histogram distribution of observed values
+type Histogram struct {
+	vector
+	buckets []float64
+	counts  map[string][]uint64
+	sums    map[string]float64
+	totals  map[string]uint64
+}
+
+// NewHistogram creates and registers a histogram in the registry
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		vector:  newVector(name, help, labels),
+		buckets: buckets,
+		counts:  map[string][]uint64{},
+		sums:    map[string]float64{},
+		totals:  map[string]uint64{},
+	}
+	r.register(name, h)
+	return h
+}
+
+// NewHistogram creates and registers a histogram in the default registry
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+// Observe records a value for the label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := h.key(labelValues)
+	counts, found := h.counts[key]
+	if !found {
+		counts = make([]uint64, len(h.buckets))
+		h.counts[key] = counts
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			counts[i]++
+		}
+	}
+	h.sums[key] += value
+	h.totals[key]++
+}
+
+func (h *Histogram) family() Family {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	family := Family{Name: h.name, Help: h.help, Type: TypeHistogram}
+	for _, key := range h.sortedKeys() {
+		values := h.series[key]
+		for i, bound := range h.buckets {
+			family.Samples = append(family.Samples, Sample{
+				Name:   h.name + "_bucket",
+				Labels: labelPairs(h.labels, values, Label{Name: "le", Value: formatValue(bound)}),
+				Value:  float64(h.counts[key][i]),
+			})
+		}
+		family.Samples = append(family.Samples,
+			Sample{Name: h.name + "_bucket", Labels: labelPairs(h.labels, values, Label{Name: "le", Value: "+Inf"}),
+				Value: float64(h.totals[key])},
+			Sample{Name: h.name + "_sum", Labels: labelPairs(h.labels, values), Value: h.sums[key]},
+			Sample{Name: h.name + "_count", Labels: labelPairs(h.labels, values), Value: float64(h.totals[key])})
+	}
+	return family
+}
+
+// GaugeValue value of a gauge for the given label values
+type GaugeValue struct {
+	LabelValues []string
+	Value       float64
+}
+
+type gaugeFunc struct {
+	name    string
+	help    string
+	labels  []string
+	collect func() []GaugeValue
+}
+
+// NewGaugeFunc registers a gauge whose values are read from collect on every exposition, it replaces
+// any metric of the same name
+func (r *Registry) NewGaugeFunc(name, help string, labels []string, collect func() []GaugeValue) {
+	r.register(name, gaugeFunc{name: name, help: help, labels: labels, collect: collect})
+}
+
+// NewGaugeFunc registers a gauge function in the default registry
+func NewGaugeFunc(name, help string, labels []string, collect func() []GaugeValue) {
+	Default.NewGaugeFunc(name, help, labels, collect)
+}
+
+func (g gaugeFunc) family() Family {
+	family := Family{Name: g.name, Help: g.help, Type: TypeGauge}
+	for _, value := range g.collect() {
+		family.Samples = append(family.Samples, Sample{Name: g.name, Labels: labelPairs(g.labels, value.LabelValues),
+			Value: value.Value})
+	}
+	return family
+}
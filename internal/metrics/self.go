@@ -0,0 +1,37 @@
+package metrics
+
+import "runtime"
+
+// Scrape results recorded by ScrapeDuration
+const (
+	ResultSuccess = "success"
+	ResultPartial = "partial"
+	ResultFailure = "failure"
+)
+
+// Metrics of cbmonitor itself, exposed along with the cluster metrics
+var (
+	ScrapeDuration = NewHistogram("cbmonitor_scrape_duration_seconds",
+		"Duration of the scrapes of a cluster by result", DefaultBuckets, "cluster", "result")
+	APIRequestDuration = NewHistogram("cbmonitor_api_request_duration_seconds",
+		"Duration of the calls to the Couchbase APIs", DefaultBuckets, "cluster", "api")
+	APIResponses = NewCounter("cbmonitor_api_responses_total",
+		"Responses of the Couchbase APIs by HTTP status code, code is error when no response was received",
+		"cluster", "api", "code")
+	APIReceivedBytes = NewCounter("cbmonitor_api_received_bytes_total",
+		"Bytes of the responses received from the Couchbase APIs", "cluster", "api")
+	APIDecodeErrors = NewCounter("cbmonitor_api_decode_errors_total",
+		"Responses of the Couchbase APIs that could not be decoded", "cluster", "api")
+	SinkWrites = NewCounter("cbmonitor_sink_writes_total",
+		"Scrape results written by the sinks by result", "sink", "result")
+	SinkDropped = NewCounter("cbmonitor_sink_dropped_total",
+		"Scrape results dropped because a sink could not keep up", "sink")
+	Notifications = NewCounter("cbmonitor_notifications_total",
+		"Notifications sent by notifier and result", "notifier", "result")
+)
+
+func init() {
+	NewGaugeFunc("cbmonitor_goroutines", "Number of goroutines", nil, func() []GaugeValue {
+		return []GaugeValue{{Value: float64(runtime.NumGoroutine())}}
+	})
+}
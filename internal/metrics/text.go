@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Metric types of the Prometheus text format
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
+
+// Label name and value of a sample
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample single value of a family, Name is the family name or a histogram series name (_bucket, _sum, _count)
+type Sample struct {
+	Name   string
+	Labels []Label
+	Value  float64
+}
+
+// Family samples sharing a name, a help text and a type
+type Family struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []Sample
+}
+
+// WriteText writes the families in the Prometheus text exposition format, families are sorted by name
+func WriteText(writer io.Writer, families []Family) error {
+	sorted := append([]Family{}, families...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	buffered := bufio.NewWriter(writer)
+	for _, family := range sorted {
+		if len(family.Samples) == 0 {
+			continue
+		}
+		buffered.WriteString("# HELP " + family.Name + " " + escapeHelp(family.Help) + "\n")
+		buffered.WriteString("# TYPE " + family.Name + " " + family.Type + "\n")
+		for _, sample := range family.Samples {
+			buffered.WriteString(sample.Name)
+			if len(sample.Labels) > 0 {
+				buffered.WriteByte('{')
+				for i, label := range sample.Labels {
+					if i > 0 {
+						buffered.WriteByte(',')
+					}
+					buffered.WriteString(label.Name + "=\"" + escapeLabel(label.Value) + "\"")
+				}
+				buffered.WriteByte('}')
+			}
+			buffered.WriteString(" " + formatValue(sample.Value) + "\n")
+		}
+	}
+	return buffered.Flush()
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer("\\", "\\\\", "\n", "\\n")
+	labelEscaper = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\"", "\\\"")
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+// Name turns a name into a valid metric or label name, invalid characters become underscores
+func Name(name string) string {
+	var builder strings.Builder
+	for i, r := range name {
+		valid := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r == '_' || (i > 0 && r >= '0' && r <= '9')
+		if !valid {
+			r = '_'
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
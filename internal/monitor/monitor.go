@@ -1,10 +1,15 @@
 package monitor
 
 import (
+	"cbmonitor/internal/logging"
+	"cbmonitor/internal/metrics"
 	"cbmonitor/internal/monitor/stats"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"time"
 )
@@ -23,6 +28,7 @@ type Monitor struct {
 	username    string
 	password    string
 	timeout     time.Duration
+	labels      map[string]string
 	client      http.Client
 }
 
@@ -31,9 +37,14 @@ type MonitorBuilder struct {
 	monitor Monitor
 }
 
+// ClusterInfo result of a single scrape, Name is the cluster name from the configuration.
+// Stats are also filled when Err is a stats.ErrPartialData error
 type ClusterInfo struct {
-	Stats stats.ClusterStats
-	Err   error
+	Name     string
+	ScrapeID string
+	Time     time.Time
+	Stats    stats.ClusterStats
+	Err      error
 }
 
 // NewMonitor creates a new stats scrapper
@@ -59,6 +70,11 @@ func (b *MonitorBuilder) SetTimeout(timeout time.Duration) {
 	b.monitor.timeout = timeout
 }
 
+// SetLabels defines the configured labels copied into the statistics of every scrape
+func (b *MonitorBuilder) SetLabels(labels map[string]string) {
+	b.monitor.labels = labels
+}
+
 func (b *MonitorBuilder) initializeClient() {
 	transport := &http.Transport{
 		DialTLS: nil,
@@ -85,21 +101,64 @@ func (b *MonitorBuilder) Build() *Monitor {
 	return &b.monitor
 }
 
+// Name returns the configured name of the monitored cluster
+func (m *Monitor) Name() string {
+	return m.clustername
+}
+
+// Labels returns the configured labels of the monitored cluster
+func (m *Monitor) Labels() map[string]string {
+	return m.labels
+}
+
+// Partial reports whether the scrape collected statistics despite failing
+func (ci ClusterInfo) Partial() bool {
+	return ci.Err != nil && errors.Is(ci.Err, stats.ErrPartialData)
+}
+
+// newScrapeID returns a random identifier correlating the log entries of a scrape
+func newScrapeID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
+
+// Logger returns the default logger with the cluster and host context
+func (m *Monitor) Logger() *slog.Logger {
+	return slog.Default().With(logging.ClusterKey, m.clustername, logging.HostKey, m.hosts[0])
+}
+
 func (m *Monitor) Check(responseChannel chan ClusterInfo) {
+	scrapeID := newScrapeID()
+	logger := m.Logger().With(logging.ScrapeIDKey, scrapeID)
+	started := time.Now()
 	baseUrl := fmt.Sprintf("%s://%s", m.protocol, m.hosts[0])
-	auth := stats.Auth{m.username, m.password}
-	cluster, err := stats.GetPoolInfo(baseUrl, m.port, auth)
-	if err == nil {
-		fmt.Println(cluster)
-		responseChannel <- ClusterInfo{
-			Stats: cluster,
-			Err:   nil,
-		}
-	} else {
-		responseChannel <- ClusterInfo{
-			Stats: stats.ClusterStats{},
-			Err:   err,
-		}
+	auth := stats.Auth{Username: m.username, Password: m.password}
+	cluster, err := stats.GetPoolInfo(baseUrl, m.port, auth, stats.Scrape{Cluster: m.clustername, Client: &m.client,
+		Logger: logger})
+	duration := time.Since(started)
+	result := metrics.ResultSuccess
+	switch {
+	case err == nil:
+		logger.Debug("Scrape completed", "duration", duration, "nodes", len(cluster.Nodes),
+			"buckets", len(cluster.Buckets))
+	case errors.Is(err, stats.ErrPartialData):
+		result = metrics.ResultPartial
+		logger.Warn("Scrape collected partial data", "duration", duration, "error", err)
+	default:
+		result = metrics.ResultFailure
+		logger.Error("Scrape failed", "duration", duration, "error", err)
+		cluster = stats.ClusterStats{}
+	}
+	cluster.Labels = m.labels
+	metrics.ScrapeDuration.Observe(duration.Seconds(), m.clustername, result)
+	responseChannel <- ClusterInfo{
+		Name:     m.clustername,
+		ScrapeID: scrapeID,
+		Time:     time.Now(),
+		Stats:    cluster,
+		Err:      err,
 	}
-
 }
@@ -1,16 +1,18 @@
 package stats
 
 import (
-	"encoding/json"
 	"fmt"
-	"net/http"
 )
 
 type bucketRaw struct {
 	Name          string `json:"name"`
 	BucketType    string `json:"bucketType"`
 	ReplicaNumber int    `json:"replicaNumber"`
-	BasicStats    struct {
+	Quota         struct {
+		RAM    int64 `json:"ram"`
+		RawRAM int64 `json:"rawRAM"`
+	} `json:"quota"`
+	BasicStats struct {
 		QuotaPercentUsed float64 `json:"quotaPercentUsed"`
 		OpsPerSec        int     `json:"opsPerSec"`
 		DiskFetches      int     `json:"diskFetches"`
@@ -40,6 +42,7 @@ type Bucket struct {
 	Name          string  `json:"name"`
 	BucketType    string  `json:"bucketType"`
 	ReplicaNumber int     `json:"replicaNumber"`
+	RAMQuotaMb    int64   `json:"ramQuotaMb"`
 	OpsPerSec     int     `json:"opsPerSec"`
 	DiskFetches   int     `json:"diskFetches"`
 	ItemCount     int     `json:"itemCount"`
@@ -67,6 +70,7 @@ func (br bucketRaw) toBucketSumamry() Bucket {
 		Name:          br.Name,
 		BucketType:    br.BucketType,
 		ReplicaNumber: br.ReplicaNumber,
+		RAMQuotaMb:    br.Quota.RawRAM / mbFromBytes,
 		OpsPerSec:     br.BasicStats.OpsPerSec,
 		DiskFetches:   br.BasicStats.DiskFetches,
 		ItemCount:     br.BasicStats.ItemCount,
@@ -84,31 +88,16 @@ func (br bucketRaw) toBucketSumamry() Bucket {
 	}
 }
 
-func getBuckets(baseUrl, port string, auth Auth, responseChannel chan bucketsChanResponse) {
+func getBuckets(scrape Scrape, baseUrl, port string, auth Auth, responseChannel chan bucketsChanResponse) {
 	url := fmt.Sprintf("%s:%s/pools/default/buckets?basic_stats=true&skipMap=true", baseUrl, port)
-	req, _ := http.NewRequest("GET", url, nil)
-	req.SetBasicAuth(auth.Username, auth.Password)
-	resp, err := client.Do(req)
-	if err != nil {
+	var bucketsRaw []bucketRaw
+	if err := getJSON(scrape, "buckets", url, auth, &bucketsRaw); err != nil {
 		responseChannel <- bucketsChanResponse{
 			buckets: []Bucket{},
 			err:     err,
 		}
 		return
 	}
-	if resp.StatusCode != 200 {
-		resp.Body.Close()
-		responseChannel <- bucketsChanResponse{
-			buckets: []Bucket{},
-			err: fmt.Errorf("%s invalid status buckets API response code: %d", errCodeHTTPStatus,
-				resp.StatusCode),
-		}
-		return
-	}
-	var bucketsRaw []bucketRaw
-	decoder := json.NewDecoder(resp.Body)
-	decoder.Decode(&bucketsRaw)
-	resp.Body.Close()
 	buckets := make([]Bucket, len(bucketsRaw))
 	for i, bucket := range bucketsRaw {
 		buckets[i] = bucket.toBucketSumamry()
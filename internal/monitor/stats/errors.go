@@ -0,0 +1,135 @@
+package stats
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrAuthentication the credentials were rejected by Couchbase
+	ErrAuthentication = errors.New("authentication failed")
+	// ErrPermissionDenied the user is not allowed to read the requested statistics
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrNotFound the requested API or resource does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrTimeout the API did not answer in time
+	ErrTimeout = errors.New("timeout")
+	// ErrTLS the TLS connection could not be established
+	ErrTLS = errors.New("tls failure")
+	// ErrMalformedPayload the API answered with a body that cannot be decoded
+	ErrMalformedPayload = errors.New("malformed payload")
+	// ErrPartialData some of the statistics could not be collected
+	ErrPartialData = errors.New("partial data")
+)
+
+// StatusError is returned when a Couchbase API answers with an unexpected HTTP status code
+type StatusError struct {
+	API  string
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s invalid status %s API response code: %d", errCodeHTTPStatus, e.API, e.Code)
+}
+
+// APIError failure calling a Couchbase API. Kind is one of the Err* values (nil when the cause is not
+// classified) so callers can use errors.Is, Err is the underlying error
+type APIError struct {
+	API  string
+	URL  string
+	Kind error
+	Err  error
+}
+
+func (e *APIError) Error() string {
+	if e.Kind == nil {
+		return fmt.Sprintf("%s API: %s", e.API, e.Err)
+	}
+	return fmt.Sprintf("%s API: %s: %s", e.API, e.Kind, e.Err)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the error belongs to the given category
+func (e *APIError) Is(target error) bool {
+	return e.Kind != nil && e.Kind == target
+}
+
+// PartialDataError is returned along with the statistics that could be collected when one of the
+// secondary APIs fails
+type PartialDataError struct {
+	Err error
+}
+
+func (e *PartialDataError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrPartialData, e.Err)
+}
+
+func (e *PartialDataError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the error belongs to the given category
+func (e *PartialDataError) Is(target error) bool {
+	return target == ErrPartialData
+}
+
+func newAPIError(api, url string, err error) *APIError {
+	return &APIError{
+		API:  api,
+		URL:  url,
+		Kind: classify(err),
+		Err:  err,
+	}
+}
+
+func classify(err error) error {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.Code {
+		case http.StatusUnauthorized:
+			return ErrAuthentication
+		case http.StatusForbidden:
+			return ErrPermissionDenied
+		case http.StatusNotFound:
+			return ErrNotFound
+		}
+		return nil
+	}
+	if isTLSError(err) {
+		return ErrTLS
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return ErrTimeout
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF) {
+		return ErrMalformedPayload
+	}
+	return nil
+}
+
+func isTLSError(err error) bool {
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certErr x509.CertificateInvalidError
+	if errors.As(err, &recordErr) || errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) ||
+		errors.As(err, &certErr) {
+		return true
+	}
+	return strings.Contains(err.Error(), "tls: ")
+}
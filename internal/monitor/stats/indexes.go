@@ -0,0 +1,54 @@
+package stats
+
+import (
+	"fmt"
+)
+
+type indexStatusRaw struct {
+	Indexes []struct {
+		Index      string   `json:"index"`
+		Bucket     string   `json:"bucket"`
+		Definition string   `json:"definition"`
+		Status     string   `json:"status"`
+		Hosts      []string `json:"hosts"`
+	} `json:"indexes"`
+}
+
+// Index GSI index definition and status
+type Index struct {
+	Name       string   `json:"name"`
+	Bucket     string   `json:"bucket"`
+	Definition string   `json:"definition"`
+	Status     string   `json:"status"`
+	Hosts      []string `json:"hosts"`
+}
+
+type indexesChanResponse struct {
+	indexes []Index
+	err     error
+}
+
+func getIndexes(scrape Scrape, baseUrl, port string, auth Auth, responseChannel chan indexesChanResponse) {
+	url := fmt.Sprintf("%s:%s/indexStatus", baseUrl, port)
+	var indexStatus indexStatusRaw
+	if err := getJSON(scrape, "indexes", url, auth, &indexStatus); err != nil {
+		responseChannel <- indexesChanResponse{
+			indexes: []Index{},
+			err:     err,
+		}
+		return
+	}
+	indexes := make([]Index, len(indexStatus.Indexes))
+	for i, index := range indexStatus.Indexes {
+		indexes[i] = Index{
+			Name:       index.Index,
+			Bucket:     index.Bucket,
+			Definition: index.Definition,
+			Status:     index.Status,
+			Hosts:      index.Hosts,
+		}
+	}
+	responseChannel <- indexesChanResponse{
+		indexes: indexes,
+	}
+}
@@ -0,0 +1,109 @@
+package stats
+
+import (
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// Metrics returns the numeric values of the cluster statistics keyed by their snake_case JSON name,
+// fields of nested structures are prefixed with the structure name. Buckets and nodes are not included
+func (c ClusterStats) Metrics() map[string]float64 {
+	return numericFields(reflect.ValueOf(c))
+}
+
+// Metrics returns the numeric values of the node statistics keyed by their snake_case JSON name
+func (n Node) Metrics() map[string]float64 {
+	return numericFields(reflect.ValueOf(n))
+}
+
+// Metrics returns the numeric values of the bucket statistics keyed by their snake_case JSON name
+func (b Bucket) Metrics() map[string]float64 {
+	return numericFields(reflect.ValueOf(b))
+}
+
+// BucketMetric name of a bucket metric among the values returned by AllMetrics
+func BucketMetric(bucket, metric string) string {
+	return "buckets/" + bucket + "/" + metric
+}
+
+// NodeMetric name of a node metric among the values returned by AllMetrics
+func NodeMetric(hostname, metric string) string {
+	return "nodes/" + hostname + "/" + metric
+}
+
+// AllMetrics returns the numeric values of the cluster, its buckets and its nodes, bucket and node
+// values are named with BucketMetric and NodeMetric
+func (c ClusterStats) AllMetrics() map[string]float64 {
+	values := c.Metrics()
+	for _, bucket := range c.Buckets {
+		for metric, value := range bucket.Metrics() {
+			values[BucketMetric(bucket.Name, metric)] = value
+		}
+	}
+	for _, node := range c.Nodes {
+		for metric, value := range node.Metrics() {
+			values[NodeMetric(node.Hostname, metric)] = value
+		}
+	}
+	return values
+}
+
+func numericFields(value reflect.Value) map[string]float64 {
+	fields := make(map[string]float64)
+	collectNumericFields(value, "", fields)
+	return fields
+}
+
+func collectNumericFields(value reflect.Value, prefix string, fields map[string]float64) {
+	valueType := value.Type()
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		name = prefix + SnakeCase(name)
+		fieldValue := value.Field(i)
+		switch fieldValue.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			fields[name] = float64(fieldValue.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			fields[name] = float64(fieldValue.Uint())
+		case reflect.Float32, reflect.Float64:
+			fields[name] = fieldValue.Float()
+		case reflect.Bool:
+			fields[name] = 0
+			if fieldValue.Bool() {
+				fields[name] = 1
+			}
+		case reflect.Struct:
+			collectNumericFields(fieldValue, name+"_", fields)
+		}
+	}
+}
+
+// SnakeCase converts a camelCase name (as used by the JSON API) to snake_case
+func SnakeCase(name string) string {
+	runes := []rune(name)
+	var builder strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			previousLower := i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]))
+			nextLower := i > 0 && i+1 < len(runes) && unicode.IsUpper(runes[i-1]) && unicode.IsLower(runes[i+1])
+			if previousLower || nextLower {
+				builder.WriteRune('_')
+			}
+			builder.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
@@ -1,11 +1,11 @@
 package stats
 
 import (
-	"encoding/json"
 	"fmt"
-	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -66,20 +66,29 @@ type poolsRawResponse struct {
 			Free       int64 `json:"free"`
 		} `json:"hdd"`
 	} `json:"storageTotals"`
-	FTSMemoryQuotaMb   int64  `json:"ftsMemoryQuota,omitempty"`
-	IndexMemoryQuotaMb int64  `json:"indexMemoryQuota,omitempty"`
-	MemoryQuotaMb      int64  `json:"memoryQuota"`
-	Name               string `json:"name"`
-	Alerts             []struct {
-		Message    string `json:"msg"`
-		ServerTime string `json:"serverTime"`
-	} `json:"alerts"`
-	Nodes           []poolRawNode `json:"nodes"`
-	RebalanceStatus string        `json:"rebalanceStatus"`
-	MaxBucketCount  int64         `json:"maxBucketCount"`
-	IndexStatusURL  string        `json:"indexStatusURI"`
-	ClusterName     string        `json:"clusterName"`
-	Balanced        bool          `json:"balanced"`
+	FTSMemoryQuotaMb   int64         `json:"ftsMemoryQuota,omitempty"`
+	IndexMemoryQuotaMb int64         `json:"indexMemoryQuota,omitempty"`
+	MemoryQuotaMb      int64         `json:"memoryQuota"`
+	Name               string        `json:"name"`
+	Alerts             []ServerAlert `json:"alerts"`
+	Nodes              []poolRawNode `json:"nodes"`
+	RebalanceStatus    string        `json:"rebalanceStatus"`
+	MaxBucketCount     int64         `json:"maxBucketCount"`
+	IndexStatusURL     string        `json:"indexStatusURI"`
+	ClusterName        string        `json:"clusterName"`
+	Balanced           bool          `json:"balanced"`
+}
+
+// ServerAlert alert raised by Couchbase itself, listed until it is dismissed in the web console
+type ServerAlert struct {
+	Message    string `json:"msg"`
+	ServerTime string `json:"serverTime"`
+}
+
+// Time parses the server time of the alert, ok is false when it is missing or not RFC 3339
+func (a ServerAlert) Time() (serverTime time.Time, ok bool) {
+	serverTime, err := time.Parse(time.RFC3339, a.ServerTime)
+	return serverTime, err == nil
 }
 
 type ClusterStats struct {
@@ -104,14 +113,17 @@ type ClusterStats struct {
 		Analytics int `json:"analytics"`
 	} `json:"servicesCount"`
 	Alerts struct {
-		Cluster []struct {
-			Message    string `json:"msg"`
-			ServerTime string `json:"serverTime"`
-		} `json:"cluster"`
-		Calculated []string `json:"calculated"`
+		Cluster    []ServerAlert `json:"cluster"`
+		Calculated []string      `json:"calculated"`
 	} `json:"alerts"`
 	Buckets []Bucket `json:"buckets"`
 	Nodes   []Node   `json:"node"`
+	Indexes []Index  `json:"indexes"`
+	// IndexesError why the indexes could not be read (often a missing permission), the scrape is not
+	// partial for it
+	IndexesError string `json:"indexesError,omitempty"`
+	// Labels configured labels of the cluster
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 type Node struct {
@@ -119,6 +131,8 @@ type Node struct {
 	MemTotalMb        int64    `json:"memTotalMb"`
 	MemFreeMb         int64    `json:"memFreeMb"`
 	MemUsedPct        float64  `json:"memPctUsed"`
+	SwapTotalMb       int64    `json:"swapTotalMb"`
+	SwapUsedMb        int64    `json:"swapUsedMb"`
 	ClusterMembership string   `json:"clusterMembership"`
 	Status            string   `json:"status"`
 	Version           string   `json:"version"`
@@ -132,7 +146,8 @@ type Node struct {
 		DocsSize  int64 `json:"docsSize"`
 		TotalDocs int64 `json:"totalDocs"`
 	} `json:"kvStats,omitempty"`
-	CPURate float64 `json:"cpuRate"`
+	CPURate       float64 `json:"cpuRate"`
+	UptimeSeconds int64   `json:"uptimeSeconds"`
 }
 
 type nodesSummary struct {
@@ -171,6 +186,8 @@ func summarizeNodes(sourceNodes []poolRawNode) nodesSummary {
 			MemTotalMb:        node.MemoryTotalBytes / mbFromBytes,
 			MemFreeMb:         node.MemoryFree / mbFromBytes,
 			MemUsedPct:        1 - (float64(node.MemoryFree) / float64(node.MemoryTotalBytes)),
+			SwapTotalMb:       node.SystemStats.SwapTotal / mbFromBytes,
+			SwapUsedMb:        node.SystemStats.SwapUsed / mbFromBytes,
 			ClusterMembership: node.ClusterMembership,
 			Status:            node.Status,
 			Version:           node.Version,
@@ -190,6 +207,7 @@ func summarizeNodes(sourceNodes []poolRawNode) nodesSummary {
 			},
 			CPURate: node.SystemStats.CPUUtilizationRate,
 		}
+		nodes[i].UptimeSeconds, _ = strconv.ParseInt(node.Uptime, 10, 64)
 		gets += nodes[i].KVStats.GetOps
 		hits += nodes[i].KVStats.GetHits
 	}
@@ -202,6 +220,7 @@ func summarizeNodes(sourceNodes []poolRawNode) nodesSummary {
 		for version, _ := range versions {
 			versionList = append(versionList, version)
 		}
+		sort.Strings(versionList)
 		alert := fmt.Sprintf("Multiple Couchbase versions (%d) in cluster: %s", len(versions), strings.Join(versionList, ","))
 		calculatedAlerts = append(calculatedAlerts, alert)
 	}
@@ -210,6 +229,7 @@ func summarizeNodes(sourceNodes []poolRawNode) nodesSummary {
 		for compatibility, _ := range compatibility {
 			compatibilityList = append(compatibilityList, strconv.Itoa(int(compatibility)))
 		}
+		sort.Strings(compatibilityList)
 		alert := fmt.Sprintf("Multiple Couchbase compatibility modes (%d) in cluster: %s", len(compatibility), strings.Join(compatibilityList, ","))
 		calculatedAlerts = append(calculatedAlerts, alert)
 	}
@@ -227,7 +247,11 @@ func (p poolsRawResponse) toClusterStats() ClusterStats {
 	calculatedAlerts = append(calculatedAlerts, summarizedNodes.alerts...)
 	ramPctUsed := 0.0
 	if p.StorageTotals.RAM.Total > 0 {
-		ramPctUsed = float64(p.StorageTotals.RAM.Used / p.StorageTotals.RAM.Total)
+		ramPctUsed = float64(p.StorageTotals.RAM.Used) / float64(p.StorageTotals.RAM.Total)
+	}
+	hdPctUsed := 0.0
+	if p.StorageTotals.HDD.Total > 0 {
+		hdPctUsed = float64(p.StorageTotals.HDD.Used) / float64(p.StorageTotals.HDD.Total)
 	}
 	return ClusterStats{
 		Name:               p.ClusterName,
@@ -241,14 +265,11 @@ func (p poolsRawResponse) toClusterStats() ClusterStats {
 		RAMPctUsed:         ramPctUsed,
 		HDTotal:            p.StorageTotals.HDD.Total,
 		HDUsed:             p.StorageTotals.HDD.Used,
-		HdPctUsed:          float64(p.StorageTotals.HDD.Used / p.StorageTotals.HDD.Total),
+		HdPctUsed:          hdPctUsed,
 		GetHitRatio:        summarizedNodes.getHitRatio,
 		Alerts: struct {
-			Cluster []struct {
-				Message    string `json:"msg"`
-				ServerTime string `json:"serverTime"`
-			} `json:"cluster"`
-			Calculated []string `json:"calculated"`
+			Cluster    []ServerAlert `json:"cluster"`
+			Calculated []string      `json:"calculated"`
 		}{
 			p.Alerts, calculatedAlerts,
 		},
@@ -272,14 +293,15 @@ func (c ClusterStats) String() string {
 	version := c.Nodes[0].Version
 	maxCPU := 0.0
 	maxMem := 0.0
-	strtingifiedAlerts := make([]string, len(c.Alerts.Cluster))
-	for key, _ := range strtingifiedAlerts {
-		strtingifiedAlerts[key] = fmt.Sprintf("%s: %s", c.Alerts.Cluster[key].ServerTime, c.Alerts.Cluster[key].Message)
+	totalAlerts := append([]string{}, c.Alerts.Calculated...)
+	for _, alert := range c.Alerts.Cluster {
+		totalAlerts = append(totalAlerts, fmt.Sprintf("%s: %s", alert.ServerTime, alert.Message))
+	}
+	alertsCount := len(totalAlerts)
+	alerts := ""
+	for _, alert := range totalAlerts {
+		alerts += fmt.Sprintf("- %s\n", alert)
 	}
-	totalAlerts := c.Alerts.Calculated
-	totalAlerts = append(totalAlerts, strtingifiedAlerts...)
-	alertsCount := len(c.Alerts.Cluster) + len(c.Alerts.Calculated)
-	alerts := strings.Join(totalAlerts, "- %s\n")
 	for i, _ := range c.Nodes {
 		if c.Nodes[i].CPURate > maxCPU {
 			maxCPU = c.Nodes[i].CPURate
@@ -300,28 +322,40 @@ func (c ClusterStats) String() string {
 		c.AvailableServices.KV, alertsCount, alerts)
 }
 
-func GetPoolInfo(baseUrl string, port string, auth Auth) (ClusterStats, error) {
+// UnhealthyNodes returns the hostnames of the nodes that are not reporting a healthy status
+func (c ClusterStats) UnhealthyNodes() []string {
+	unhealthy := []string{}
+	for _, node := range c.Nodes {
+		if node.Status != nodeExpectedHealth {
+			unhealthy = append(unhealthy, node.Hostname)
+		}
+	}
+	return unhealthy
+}
+
+// GetPoolInfo collects the cluster statistics. When only the buckets cannot be read the collected
+// statistics are returned along with a PartialDataError, indexes that cannot be read are reported by
+// IndexesError. API calls are logged and measured within the scrape context
+func GetPoolInfo(baseUrl string, port string, auth Auth, scrape Scrape) (ClusterStats, error) {
 	url := fmt.Sprintf("%s:%s/pools/default", baseUrl, port)
-	req, _ := http.NewRequest("GET", url, nil)
-	req.SetBasicAuth(auth.Username, auth.Password)
-	resp, err := client.Do(req)
-	if err != nil {
+	var poolsResponse poolsRawResponse
+	if err := getJSON(scrape, "pools", url, auth, &poolsResponse); err != nil {
 		return ClusterStats{}, err
 	}
-	if resp.StatusCode != 200 {
-		resp.Body.Close()
-		return ClusterStats{}, fmt.Errorf("%s invalid status pools API response code: %d", errCodeHTTPStatus,
-			resp.StatusCode)
-	}
-	var poolsResponse poolsRawResponse
-	decoder := json.NewDecoder(resp.Body)
-	decoder.Decode(&poolsResponse)
-	resp.Body.Close()
 	clusterStats := poolsResponse.toClusterStats()
 	bucketsChannel := make(chan bucketsChanResponse)
-	go getBuckets(baseUrl, port, auth, bucketsChannel)
-	// todo: fetch indices from remote url
+	go getBuckets(scrape, baseUrl, port, auth, bucketsChannel)
+	indexesChannel := make(chan indexesChanResponse)
+	go getIndexes(scrape, baseUrl, port, auth, indexesChannel)
 	bucketsResponse := <-bucketsChannel
+	indexesResponse := <-indexesChannel
 	clusterStats.Buckets = bucketsResponse.buckets
+	clusterStats.Indexes = indexesResponse.indexes
+	if indexesResponse.err != nil {
+		clusterStats.IndexesError = indexesResponse.err.Error()
+	}
+	if bucketsResponse.err != nil {
+		return clusterStats, &PartialDataError{Err: bucketsResponse.err}
+	}
 	return clusterStats, nil
 }
@@ -1,9 +1,14 @@
 package stats
 
-import "net/http"
-
-var (
-	client = http.Client{}
+import (
+	"cbmonitor/internal/metrics"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
 )
 
 const (
@@ -16,7 +21,73 @@ type Auth struct {
 	Password string
 }
 
-// SetGlobalClient defines the default HTTP client that will be used to call the monitoring APIs
-func SetGlobalClient(apiClient http.Client) {
-	client = apiClient
+// Scrape context of a collection: Cluster labels the self metrics, Client calls the APIs and Logger
+// receives the debug entries (the default client and logger are used when they are nil)
+type Scrape struct {
+	Cluster string
+	Client  *http.Client
+	Logger  *slog.Logger
+}
+
+func (s Scrape) client() *http.Client {
+	if s.Client == nil {
+		return http.DefaultClient
+	}
+	return s.Client
+}
+
+func (s Scrape) logger() *slog.Logger {
+	if s.Logger == nil {
+		return slog.Default()
+	}
+	return s.Logger
+}
+
+// countingReader counts the bytes read from a response body
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// getJSON calls a Couchbase API and decodes its response into target
+func getJSON(scrape Scrape, api, url string, auth Auth, target interface{}) error {
+	logger := scrape.logger()
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return newAPIError(api, url, err)
+	}
+	req.SetBasicAuth(auth.Username, auth.Password)
+	started := time.Now()
+	defer func() {
+		metrics.APIRequestDuration.Observe(time.Since(started).Seconds(), scrape.Cluster, api)
+	}()
+	resp, err := scrape.client().Do(req)
+	if err != nil {
+		metrics.APIResponses.Inc(scrape.Cluster, api, "error")
+		logger.Debug("Couchbase API call failed", "api", api, "url", url, "duration", time.Since(started), "error", err)
+		return newAPIError(api, url, err)
+	}
+	defer resp.Body.Close()
+	metrics.APIResponses.Inc(scrape.Cluster, api, strconv.Itoa(resp.StatusCode))
+	body := &countingReader{reader: resp.Body}
+	defer func() {
+		metrics.APIReceivedBytes.Add(float64(body.count), scrape.Cluster, api)
+	}()
+	logger.Debug("Couchbase API called", "api", api, "url", url, "status", resp.StatusCode,
+		"duration", time.Since(started))
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, body)
+		return newAPIError(api, url, &StatusError{API: api, Code: resp.StatusCode})
+	}
+	if err := json.NewDecoder(body).Decode(target); err != nil {
+		metrics.APIDecodeErrors.Inc(scrape.Cluster, api)
+		return newAPIError(api, url, err)
+	}
+	return nil
 }
@@ -0,0 +1,122 @@
+package monitor
+
+import (
+	"cbmonitor/internal/monitor/stats"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// State summarizes the outcome of the latest scrape of a cluster
+type State string
+
+const (
+	// StateUnknown the cluster has not been scraped yet
+	StateUnknown State = "unknown"
+	// StateUp the latest scrape succeeded and every node is healthy
+	StateUp State = "up"
+	// StateDegraded the latest scrape only collected part of the statistics or the cluster is not fully healthy
+	StateDegraded State = "degraded"
+	// StateDown the latest scrape failed
+	StateDown State = "down"
+)
+
+// ErrorCategory groups scrape errors by their cause
+type ErrorCategory string
+
+const (
+	CategoryNone       ErrorCategory = ""
+	CategoryAuth       ErrorCategory = "auth"
+	CategoryTLS        ErrorCategory = "tls"
+	CategoryTimeout    ErrorCategory = "timeout"
+	CategoryHTTPStatus ErrorCategory = "http_status"
+	CategoryDecode     ErrorCategory = "decode"
+	CategoryNetwork    ErrorCategory = "network"
+	CategoryPartial    ErrorCategory = "partial_data"
+	CategoryUnknown    ErrorCategory = "unknown"
+)
+
+// ClusterStatus keeps track of the scrape health of a configured cluster
+type ClusterStatus struct {
+	Name                string        `json:"name"`
+	State               State         `json:"state"`
+	Reason              string        `json:"reason,omitempty"`
+	LastError           string        `json:"lastError,omitempty"`
+	ErrorCategory       ErrorCategory `json:"errorCategory,omitempty"`
+	LastCheck           *time.Time    `json:"lastCheck,omitempty"`
+	LastSuccess         *time.Time    `json:"lastSuccess,omitempty"`
+	ConsecutiveFailures int           `json:"consecutiveFailures"`
+	Stale               bool          `json:"stale"`
+}
+
+// NewClusterStatus creates the status of a cluster that has not been scraped yet
+func NewClusterStatus(name string) ClusterStatus {
+	return ClusterStatus{
+		Name:  name,
+		State: StateUnknown,
+	}
+}
+
+// Update records the result of a scrape, hasData tells whether previous statistics are still available
+func (s *ClusterStatus) Update(info ClusterInfo, hasData bool) {
+	checked := info.Time
+	s.LastCheck = &checked
+	if info.Partial() {
+		s.LastSuccess = &checked
+		s.State = StateDegraded
+		s.Reason = "partial data"
+		s.LastError = info.Err.Error()
+		s.ErrorCategory = Categorize(info.Err)
+		s.ConsecutiveFailures = 0
+		s.Stale = false
+		return
+	}
+	if info.Err != nil {
+		s.State = StateDown
+		s.Reason = ""
+		s.LastError = info.Err.Error()
+		s.ErrorCategory = Categorize(info.Err)
+		s.ConsecutiveFailures++
+		s.Stale = hasData
+		return
+	}
+	s.LastSuccess = &checked
+	s.LastError = ""
+	s.ErrorCategory = CategoryNone
+	s.ConsecutiveFailures = 0
+	s.Stale = false
+	s.State = StateUp
+	s.Reason = ""
+	if unhealthy := info.Stats.UnhealthyNodes(); len(unhealthy) > 0 {
+		s.State = StateDegraded
+		s.Reason = fmt.Sprintf("%d node(s) not healthy: %s", len(unhealthy), strings.Join(unhealthy, ","))
+	}
+}
+
+// Categorize classifies a scrape error, partial scrapes are partial data whatever made the secondary API
+// fail, their error tells the cause
+func Categorize(err error) ErrorCategory {
+	var statusErr *stats.StatusError
+	var opErr *net.OpError
+	switch {
+	case err == nil:
+		return CategoryNone
+	case errors.Is(err, stats.ErrPartialData):
+		return CategoryPartial
+	case errors.Is(err, stats.ErrAuthentication), errors.Is(err, stats.ErrPermissionDenied):
+		return CategoryAuth
+	case errors.Is(err, stats.ErrTLS):
+		return CategoryTLS
+	case errors.Is(err, stats.ErrTimeout):
+		return CategoryTimeout
+	case errors.Is(err, stats.ErrMalformedPayload):
+		return CategoryDecode
+	case errors.As(err, &statusErr):
+		return CategoryHTTPStatus
+	case errors.As(err, &opErr):
+		return CategoryNetwork
+	}
+	return CategoryUnknown
+}
@@ -0,0 +1,392 @@
+package notify
+
+import (
+	"cbmonitor/internal/alerts"
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/metrics"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notification statuses, events are announced once and never resolve
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+	StatusEvent    = "event"
+)
+
+// Notification alerts of a group sent to a receiver. Resolved lists the alerts notified before that are
+// not raised anymore
+type Notification struct {
+	Receiver    string            `json:"receiver"`
+	Route       string            `json:"route"`
+	GroupKey    string            `json:"groupKey"`
+	GroupLabels map[string]string `json:"groupLabels"`
+	Status      string            `json:"status"`
+	Alerts      []alerts.Alert    `json:"alerts"`
+	Resolved    []alerts.Alert    `json:"resolved,omitempty"`
+	Time        time.Time         `json:"time"`
+}
+
+// Subject summarizes the notification on a line, like [FIRING:2] cluster=prod-east
+func (n Notification) Subject() string {
+	names := make([]string, 0, len(n.GroupLabels))
+	for name := range n.GroupLabels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=%s", name, n.GroupLabels[name])
+	}
+	switch n.Status {
+	case StatusResolved:
+		return fmt.Sprintf("[RESOLVED] %s", strings.Join(pairs, " "))
+	case StatusEvent:
+		return fmt.Sprintf("[EVENT:%d] %s", len(n.Alerts), strings.Join(pairs, " "))
+	}
+	return fmt.Sprintf("[FIRING:%d] %s", len(n.Alerts), strings.Join(pairs, " "))
+}
+
+// Text lists the firing (or announced) and resolved alerts of the notification
+func (n Notification) Text() string {
+	var text strings.Builder
+	if len(n.Alerts) > 0 {
+		if n.Status == StatusEvent {
+			text.WriteString("Events:\n")
+		} else {
+			text.WriteString("Firing:\n")
+		}
+		for _, alert := range n.Alerts {
+			fmt.Fprintf(&text, "- [%s] %s: %s\n", alert.Severity, alert.Cluster, alert.Message)
+		}
+	}
+	if len(n.Resolved) > 0 {
+		text.WriteString("Resolved:\n")
+		for _, alert := range n.Resolved {
+			fmt.Fprintf(&text, "- %s: %s\n", alert.Cluster, alert.Message)
+		}
+	}
+	return text.String()
+}
+
+// ReceiverStatus delivery counters of a receiver
+type ReceiverStatus struct {
+	Name      string     `json:"name"`
+	Sent      int64      `json:"sent"`
+	Failures  int64      `json:"failures"`
+	LastError string     `json:"lastError,omitempty"`
+	LastSent  *time.Time `json:"lastSent,omitempty"`
+}
+
+type receiver struct {
+	integrations []integration
+	status       ReceiverStatus
+}
+
+// group alerts of a route sharing the same group labels, notified holds the alerts of the latest
+// successful notification
+type group struct {
+	key          string
+	route        *route
+	labels       map[string]string
+	notified     map[string]alerts.Alert
+	lastNotified time.Time
+}
+
+// Dispatcher routes the alerts to the receivers, grouped by route and group labels. A group is notified
+// when its alerts change, at most every group interval, and again every repeat interval while alerts
+// that are not acknowledged are still firing
+type Dispatcher struct {
+	root      *route
+	receivers map[string]*receiver
+	groups    map[string]*group
+	mu        sync.Mutex
+}
+
+// NewDispatcher creates a dispatcher for the given (normalized) settings, it routes nothing without route
+func NewDispatcher(settings config.Notifications) (*Dispatcher, error) {
+	d := &Dispatcher{receivers: map[string]*receiver{}, groups: map[string]*group{}}
+	for _, settings := range settings.Receivers {
+		r := &receiver{status: ReceiverStatus{Name: settings.Name}}
+		if settings.Webhook != nil {
+			r.integrations = append(r.integrations, newWebhook(*settings.Webhook))
+		}
+		if settings.Email != nil {
+			r.integrations = append(r.integrations, &email{config: *settings.Email})
+		}
+		d.receivers[settings.Name] = r
+	}
+	if settings.Route != nil {
+		root, err := newRoute(*settings.Route, "root")
+		if err != nil {
+			return nil, err
+		}
+		d.root = root
+	}
+	return d, nil
+}
+
+// Enabled tells whether a routing tree is configured
+func (d *Dispatcher) Enabled() bool {
+	return d.root != nil
+}
+
+// Route returns the routing decisions of an alert
+func (d *Dispatcher) Route(alert alerts.Alert) []Decision {
+	if d.root == nil {
+		return []Decision{}
+	}
+	attributes := Attributes(alert)
+	handlers := d.root.handlers(attributes)
+	decisions := make([]Decision, len(handlers))
+	for i, handler := range handlers {
+		decisions[i] = Decision{
+			Route:    handler.path,
+			Receiver: handler.config.Receiver,
+			GroupKey: handler.groupKey(handler.groupLabels(attributes)),
+			Continue: handler.config.Continue,
+		}
+	}
+	return decisions
+}
+
+// pending notification of a group and the alerts it notifies
+type pending struct {
+	group        *group
+	notification Notification
+	firing       map[string]alerts.Alert
+}
+
+// Dispatch routes the currently raised alerts and sends the notifications that are due
+func (d *Dispatcher) Dispatch(raised []alerts.Alert, now time.Time) {
+	d.dispatch(raised, now, d.send)
+}
+
+// Track routes the currently raised alerts like Dispatch and records the notifications that are due as
+// sent without sending them, so that a standby replica taking over does not notify them again
+func (d *Dispatcher) Track(raised []alerts.Alert, now time.Time) {
+	d.dispatch(raised, now, func(Notification) error { return nil })
+}
+
+func (d *Dispatcher) dispatch(raised []alerts.Alert, now time.Time, send func(Notification) error) {
+	if d.root == nil {
+		return
+	}
+	firing := map[string]map[string]alerts.Alert{}
+	d.mu.Lock()
+	for _, alert := range raised {
+		attributes := Attributes(alert)
+		for _, handler := range d.root.handlers(attributes) {
+			labels := handler.groupLabels(attributes)
+			key := handler.groupKey(labels)
+			if _, found := d.groups[key]; !found {
+				d.groups[key] = &group{key: key, route: handler, labels: labels, notified: map[string]alerts.Alert{}}
+			}
+			if firing[key] == nil {
+				firing[key] = map[string]alerts.Alert{}
+			}
+			firing[key][alerts.Fingerprint(alert)] = alert
+		}
+	}
+	due := []pending{}
+	for key, g := range d.groups {
+		if notification, ok := g.due(firing[key], now); ok {
+			due = append(due, pending{group: g, notification: notification, firing: firing[key]})
+		} else if len(firing[key]) == 0 && len(g.notified) == 0 {
+			delete(d.groups, key)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, p := range due {
+		if err := send(p.notification); err != nil {
+			continue
+		}
+		d.mu.Lock()
+		p.group.notified = map[string]alerts.Alert{}
+		for id, alert := range p.firing {
+			p.group.notified[id] = alert
+		}
+		p.group.lastNotified = now
+		if len(p.firing) == 0 {
+			delete(d.groups, p.group.key)
+		}
+		d.mu.Unlock()
+	}
+}
+
+// Forget drops the alerts of the clusters from the notified groups without notifying their resolution,
+// when the clusters are not monitored by this instance anymore
+func (d *Dispatcher) Forget(clusters []string) {
+	forgotten := make(map[string]bool, len(clusters))
+	for _, cluster := range clusters {
+		forgotten[cluster] = true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, g := range d.groups {
+		for id, alert := range g.notified {
+			if forgotten[alert.Cluster] {
+				delete(g.notified, id)
+			}
+		}
+	}
+}
+
+// Announce sends the alerts of events at once to the receivers of their routes, grouped by route and group
+// labels. Announcements are neither repeated nor retried
+func (d *Dispatcher) Announce(announced []alerts.Alert, now time.Time) {
+	if d.root == nil || len(announced) == 0 {
+		return
+	}
+	notifications := map[string]*Notification{}
+	keys := []string{}
+	for _, alert := range announced {
+		attributes := Attributes(alert)
+		for _, handler := range d.root.handlers(attributes) {
+			labels := handler.groupLabels(attributes)
+			key := handler.groupKey(labels)
+			notification, found := notifications[key]
+			if !found {
+				notification = &Notification{
+					Receiver:    handler.config.Receiver,
+					Route:       handler.path,
+					GroupKey:    key,
+					GroupLabels: labels,
+					Status:      StatusEvent,
+					Time:        now,
+				}
+				notifications[key] = notification
+				keys = append(keys, key)
+			}
+			notification.Alerts = append(notification.Alerts, alert)
+		}
+	}
+	for _, key := range keys {
+		d.send(*notifications[key])
+	}
+}
+
+// due returns the notification of the group when one must be sent for the firing alerts
+func (g *group) due(firing map[string]alerts.Alert, now time.Time) (Notification, bool) {
+	changed := false
+	for id, alert := range firing {
+		if notified, found := g.notified[id]; !found || notified.Severity != alert.Severity {
+			changed = true
+		}
+	}
+	resolved := []alerts.Alert{}
+	for id, alert := range g.notified {
+		if _, found := firing[id]; !found {
+			resolved = append(resolved, alert)
+			changed = true
+		}
+	}
+	elapsed := now.Sub(g.lastNotified)
+	switch {
+	case len(firing) == 0 && len(resolved) == 0:
+		return Notification{}, false
+	case g.lastNotified.IsZero():
+	case changed && elapsed >= g.route.config.GroupInterval.Duration:
+	case !changed && elapsed >= g.route.config.RepeatInterval.Duration && !acknowledged(firing):
+	default:
+		return Notification{}, false
+	}
+	notification := Notification{
+		Receiver:    g.route.config.Receiver,
+		Route:       g.route.path,
+		GroupKey:    g.key,
+		GroupLabels: g.labels,
+		Status:      StatusFiring,
+		Alerts:      sortedAlerts(firing),
+		Resolved:    resolved,
+		Time:        now,
+	}
+	if len(firing) == 0 {
+		notification.Status = StatusResolved
+	}
+	sortAlerts(notification.Resolved)
+	return notification, true
+}
+
+// acknowledged tells whether every firing alert was acknowledged, such groups are not notified again
+// until their alerts change
+func acknowledged(firing map[string]alerts.Alert) bool {
+	for _, alert := range firing {
+		if !alert.Acknowledged {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedAlerts(byID map[string]alerts.Alert) []alerts.Alert {
+	list := make([]alerts.Alert, 0, len(byID))
+	for _, alert := range byID {
+		list = append(list, alert)
+	}
+	sortAlerts(list)
+	return list
+}
+
+// sortAlerts orders the alerts by severity (highest first), cluster and message
+func sortAlerts(list []alerts.Alert) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Severity != list[j].Severity {
+			return list[i].Severity.Outranks(list[j].Severity)
+		}
+		if list[i].Cluster != list[j].Cluster {
+			return list[i].Cluster < list[j].Cluster
+		}
+		return list[i].Message < list[j].Message
+	})
+}
+
+// send delivers the notification through every integration of its receiver, it fails when one fails
+func (d *Dispatcher) send(notification Notification) error {
+	r := d.receivers[notification.Receiver]
+	var failure error
+	for _, integration := range r.integrations {
+		notifier := notification.Receiver + "/" + integration.kind()
+		if err := integration.send(notification); err != nil {
+			failure = err
+			metrics.Notifications.Inc(notifier, metrics.ResultFailure)
+			slog.Error("Cannot send notification", "notifier", notifier, "group", notification.GroupKey,
+				"error", err)
+			continue
+		}
+		metrics.Notifications.Inc(notifier, metrics.ResultSuccess)
+		slog.Info("Notification sent", "notifier", notifier, "group", notification.GroupKey, "status",
+			notification.Status, "alerts", len(notification.Alerts), "resolved", len(notification.Resolved))
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if failure != nil {
+		r.status.Failures++
+		r.status.LastError = failure.Error()
+		return failure
+	}
+	sent := notification.Time
+	r.status.Sent++
+	r.status.LastSent = &sent
+	return nil
+}
+
+// Status returns the delivery counters of the receivers by name
+func (d *Dispatcher) Status() []ReceiverStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	statuses := make([]ReceiverStatus, 0, len(d.receivers))
+	for _, r := range d.receivers {
+		statuses = append(statuses, r.status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
@@ -0,0 +1,310 @@
+package notify
+
+import (
+	"cbmonitor/internal/alerts"
+	"cbmonitor/internal/config"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+// recorder webhook endpoint keeping the notifications it receives
+type recorder struct {
+	server        *httptest.Server
+	notifications []Notification
+	mu            sync.Mutex
+}
+
+func newRecorder(t *testing.T) *recorder {
+	t.Helper()
+	r := &recorder{}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var notification Notification
+		if err := json.NewDecoder(req.Body).Decode(&notification); err != nil {
+			t.Errorf("invalid notification: %v", err)
+		}
+		r.mu.Lock()
+		r.notifications = append(r.notifications, notification)
+		r.mu.Unlock()
+	}))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+// take returns the notifications received since the previous call
+func (r *recorder) take() []Notification {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	taken := r.notifications
+	r.notifications = nil
+	return taken
+}
+
+func duration(d time.Duration) config.Duration {
+	return config.Duration{Duration: d}
+}
+
+// newTestDispatcher creates a dispatcher sending every receiver to the recorder, grouping by cluster with a
+// group interval of a minute and a repeat interval of an hour
+func newTestDispatcher(t *testing.T, r *recorder, routes ...config.Route) *Dispatcher {
+	t.Helper()
+	settings := config.Notifications{Route: &config.Route{
+		Receiver:       "default",
+		GroupBy:        []string{"cluster"},
+		GroupInterval:  duration(time.Minute),
+		RepeatInterval: duration(time.Hour),
+		Routes:         routes,
+	}}
+	for _, name := range []string{"default", "database", "pager"} {
+		settings.Receivers = append(settings.Receivers, config.Receiver{
+			Name:    name,
+			Webhook: &config.Webhook{URL: r.server.URL, Timeout: duration(time.Second)},
+		})
+	}
+	d, err := NewDispatcher(settings)
+	if err != nil {
+		t.Fatalf("NewDispatcher() = %v", err)
+	}
+	return d
+}
+
+// testRoute creates a child route with the intervals of the root
+func testRoute(receiver string, match map[string]string, proceed bool) config.Route {
+	return config.Route{
+		Receiver:       receiver,
+		Match:          match,
+		Continue:       proceed,
+		GroupBy:        []string{"cluster"},
+		GroupInterval:  duration(time.Minute),
+		RepeatInterval: duration(time.Hour),
+	}
+}
+
+func testAlert(cluster, name string, severity alerts.Severity) alerts.Alert {
+	return alerts.Alert{Cluster: cluster, Type: alerts.TypeRule, Name: name, Severity: severity,
+		Message: name + " is high", Labels: map[string]string{"team": "database"}}
+}
+
+func TestRoute(t *testing.T) {
+	d := newTestDispatcher(t, newRecorder(t),
+		testRoute("pager", map[string]string{"severity": "critical"}, true),
+		testRoute("database", map[string]string{"team": "database"}, false),
+		testRoute("pager", map[string]string{"team": "database"}, false),
+	)
+	tests := []struct {
+		name  string
+		alert alerts.Alert
+		want  []string
+	}{
+		{name: "continue", alert: testAlert("east", "cpu", alerts.SeverityCritical),
+			want: []string{"root/0 pager", "root/1 database"}},
+		{name: "first match", alert: testAlert("east", "cpu", alerts.SeverityWarning),
+			want: []string{"root/1 database"}},
+		{name: "no match", alert: alerts.Alert{Cluster: "east", Severity: alerts.SeverityWarning},
+			want: []string{"root default"}},
+	}
+	for _, test := range tests {
+		decisions := d.Route(test.alert)
+		if len(decisions) != len(test.want) {
+			t.Errorf("%s: Route() = %+v, want %v", test.name, decisions, test.want)
+			continue
+		}
+		for i, decision := range decisions {
+			if got := decision.Route + " " + decision.Receiver; got != test.want[i] {
+				t.Errorf("%s: decision %d = %s, want %s", test.name, i, got, test.want[i])
+			}
+			if decision.GroupKey != decision.Route+`{cluster="east"}` {
+				t.Errorf("%s: group key %s, want grouped by cluster", test.name, decision.GroupKey)
+			}
+		}
+	}
+}
+
+func TestDispatchContinue(t *testing.T) {
+	r := newRecorder(t)
+	d := newTestDispatcher(t, r,
+		testRoute("pager", map[string]string{"severity": "critical"}, true),
+		testRoute("database", map[string]string{"team": "database"}, false),
+	)
+	d.Dispatch([]alerts.Alert{testAlert("east", "cpu", alerts.SeverityCritical),
+		testAlert("west", "cpu", alerts.SeverityWarning)}, start)
+	received := map[string]int{}
+	for _, notification := range r.take() {
+		received[notification.Receiver+" "+notification.GroupLabels["cluster"]]++
+	}
+	want := map[string]int{"pager east": 1, "database east": 1, "database west": 1}
+	if len(received) != len(want) {
+		t.Fatalf("notifications %v, want %v", received, want)
+	}
+	for key, count := range want {
+		if received[key] != count {
+			t.Errorf("notifications %v, want %v", received, want)
+		}
+	}
+}
+
+func TestDispatchTiming(t *testing.T) {
+	cpu := testAlert("east", "cpu", alerts.SeverityWarning)
+	memory := testAlert("east", "memory", alerts.SeverityWarning)
+	critical := testAlert("east", "cpu", alerts.SeverityCritical)
+	steps := []struct {
+		name   string
+		after  time.Duration
+		raised []alerts.Alert
+		status string
+		alerts int
+	}{
+		{name: "first", raised: []alerts.Alert{cpu}, status: StatusFiring, alerts: 1},
+		{name: "unchanged", after: 30 * time.Second, raised: []alerts.Alert{cpu}},
+		{name: "change within the group interval", after: 45 * time.Second, raised: []alerts.Alert{cpu, memory}},
+		{name: "change after the group interval", after: time.Minute, raised: []alerts.Alert{cpu, memory},
+			status: StatusFiring, alerts: 2},
+		{name: "before repeating", after: 55 * time.Minute, raised: []alerts.Alert{cpu, memory}},
+		{name: "repeat", after: 61 * time.Minute, raised: []alerts.Alert{cpu, memory}, status: StatusFiring,
+			alerts: 2},
+		{name: "escalation", after: 62 * time.Minute, raised: []alerts.Alert{critical, memory},
+			status: StatusFiring, alerts: 2},
+		{name: "partly resolved", after: 63 * time.Minute, raised: []alerts.Alert{critical}, status: StatusFiring,
+			alerts: 1},
+		{name: "resolved", after: 64 * time.Minute, status: StatusResolved},
+		{name: "nothing left", after: 200 * time.Minute},
+	}
+	r := newRecorder(t)
+	d := newTestDispatcher(t, r)
+	for _, step := range steps {
+		d.Dispatch(step.raised, start.Add(step.after))
+		received := r.take()
+		if step.status == "" {
+			if len(received) > 0 {
+				t.Errorf("%s: sent %+v, want nothing", step.name, received)
+			}
+			continue
+		}
+		if len(received) != 1 || received[0].Status != step.status || len(received[0].Alerts) != step.alerts {
+			t.Errorf("%s: sent %+v, want one %s notification of %d alerts", step.name, received, step.status,
+				step.alerts)
+		}
+	}
+}
+
+func TestDispatchResolvedAlerts(t *testing.T) {
+	r := newRecorder(t)
+	d := newTestDispatcher(t, r)
+	cpu, memory := testAlert("east", "cpu", alerts.SeverityWarning), testAlert("east", "memory",
+		alerts.SeverityCritical)
+	d.Dispatch([]alerts.Alert{cpu, memory}, start)
+	if sent := r.take(); len(sent) != 1 || sent[0].Alerts[0].Name != "memory" {
+		t.Fatalf("sent %+v, want the critical alert first", sent)
+	}
+	d.Dispatch([]alerts.Alert{cpu}, start.Add(time.Minute))
+	sent := r.take()
+	if len(sent) != 1 || len(sent[0].Resolved) != 1 || sent[0].Resolved[0].Name != "memory" {
+		t.Errorf("sent %+v, want memory resolved", sent)
+	}
+}
+
+func TestDispatchAcknowledged(t *testing.T) {
+	r := newRecorder(t)
+	d := newTestDispatcher(t, r)
+	cpu := testAlert("east", "cpu", alerts.SeverityWarning)
+	d.Dispatch([]alerts.Alert{cpu}, start)
+	r.take()
+	cpu.Acknowledged = true
+	d.Dispatch([]alerts.Alert{cpu}, start.Add(2*time.Hour))
+	if sent := r.take(); len(sent) > 0 {
+		t.Errorf("sent %+v, want the acknowledged alert not repeated", sent)
+	}
+	memory := testAlert("east", "memory", alerts.SeverityWarning)
+	d.Dispatch([]alerts.Alert{cpu, memory}, start.Add(3*time.Hour))
+	if sent := r.take(); len(sent) != 1 || len(sent[0].Alerts) != 2 {
+		t.Errorf("sent %+v, want the group notified when an alert joins", sent)
+	}
+	d.Dispatch([]alerts.Alert{cpu, memory}, start.Add(5*time.Hour))
+	if sent := r.take(); len(sent) != 1 {
+		t.Errorf("sent %+v, want the group repeated while an alert is not acknowledged", sent)
+	}
+}
+
+func TestTrack(t *testing.T) {
+	r := newRecorder(t)
+	d := newTestDispatcher(t, r)
+	cpu := testAlert("east", "cpu", alerts.SeverityWarning)
+	d.Track([]alerts.Alert{cpu}, start)
+	if sent := r.take(); len(sent) > 0 {
+		t.Fatalf("Track() sent %+v", sent)
+	}
+	// the standby replica takes over
+	d.Dispatch([]alerts.Alert{cpu}, start.Add(time.Minute))
+	if sent := r.take(); len(sent) > 0 {
+		t.Errorf("sent %+v after taking over, want the tracked alert not notified again", sent)
+	}
+	d.Dispatch(nil, start.Add(2*time.Minute))
+	if sent := r.take(); len(sent) != 1 || sent[0].Status != StatusResolved {
+		t.Errorf("sent %+v, want the tracked alert resolved", sent)
+	}
+	if statuses := d.Status(); statuses[0].Name != "database" || statuses[1].Sent != 1 {
+		t.Errorf("Status() = %+v, want one notification sent to default", statuses)
+	}
+}
+
+func TestForget(t *testing.T) {
+	r := newRecorder(t)
+	d := newTestDispatcher(t, r)
+	d.Dispatch([]alerts.Alert{testAlert("east", "cpu", alerts.SeverityWarning)}, start)
+	r.take()
+	d.Forget([]string{"east"})
+	d.Dispatch(nil, start.Add(time.Minute))
+	if sent := r.take(); len(sent) > 0 {
+		t.Errorf("sent %+v, want the forgotten alerts not resolved", sent)
+	}
+}
+
+func TestFailedDeliveryRetried(t *testing.T) {
+	failing := true
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if failing {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	d, err := NewDispatcher(config.Notifications{
+		Receivers: []config.Receiver{{Name: "default", Webhook: &config.Webhook{URL: server.URL}}},
+		Route: &config.Route{Receiver: "default", GroupInterval: duration(time.Minute),
+			RepeatInterval: duration(time.Hour)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu := testAlert("east", "cpu", alerts.SeverityWarning)
+	d.Dispatch([]alerts.Alert{cpu}, start)
+	if status := d.Status()[0]; status.Failures != 1 || status.LastError == "" {
+		t.Errorf("Status() = %+v, want a failure", status)
+	}
+	failing = false
+	d.Dispatch([]alerts.Alert{cpu}, start.Add(time.Second))
+	if attempts != 2 || d.Status()[0].Sent != 1 {
+		t.Errorf("%d attempts and status %+v, want the notification retried", attempts, d.Status()[0])
+	}
+}
+
+func TestAnnounce(t *testing.T) {
+	r := newRecorder(t)
+	d := newTestDispatcher(t, r)
+	event := alerts.Alert{Cluster: "east", Type: alerts.TypeEvent, Name: "failover",
+		Severity: alerts.SeverityWarning}
+	d.Announce([]alerts.Alert{event, event}, start)
+	sent := r.take()
+	if len(sent) != 1 || sent[0].Status != StatusEvent || len(sent[0].Alerts) != 2 {
+		t.Errorf("Announce() sent %+v, want one event notification of 2 alerts", sent)
+	}
+	if subject := sent[0].Subject(); subject != "[EVENT:2] cluster=east" {
+		t.Errorf("Subject() = %q", subject)
+	}
+}
@@ -0,0 +1,85 @@
+package notify
+
+import (
+	"bytes"
+	"cbmonitor/internal/config"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// integration sends notifications to one destination of a receiver
+type integration interface {
+	kind() string
+	send(notification Notification) error
+}
+
+type webhook struct {
+	config config.Webhook
+	client *http.Client
+}
+
+func newWebhook(settings config.Webhook) *webhook {
+	return &webhook{config: settings, client: &http.Client{Timeout: settings.Timeout.Duration}}
+}
+
+func (w *webhook) kind() string {
+	return "webhook"
+}
+
+// send POSTs the notification as JSON, any status but 2xx is an error
+func (w *webhook) send(notification Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range w.config.Headers {
+		request.Header.Set(name, value)
+	}
+	response, err := w.client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %s", response.Status)
+	}
+	return nil
+}
+
+type email struct {
+	config config.Email
+}
+
+func (e *email) kind() string {
+	return "email"
+}
+
+// send mails the notification as plain text, authenticating with PLAIN when a user is configured
+func (e *email) send(notification Notification) error {
+	var auth smtp.Auth
+	if e.config.Username != "" {
+		host, _, err := net.SplitHostPort(e.config.SMTP)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", e.config.Username, e.config.Password, host)
+	}
+	var message strings.Builder
+	fmt.Fprintf(&message, "From: %s\r\n", e.config.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(e.config.To, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", notification.Subject())
+	fmt.Fprintf(&message, "Date: %s\r\n", notification.Time.Format(time.RFC1123Z))
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(notification.Text(), "\n", "\r\n"))
+	return smtp.SendMail(e.config.SMTP, auth, e.config.From, e.config.To, []byte(message.String()))
+}
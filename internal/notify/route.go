@@ -0,0 +1,120 @@
+package notify
+
+import (
+	"cbmonitor/internal/alerts"
+	"cbmonitor/internal/config"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// route compiled node of the routing tree, path locates it in the tree: root, root/0, root/0/2...
+type route struct {
+	path     string
+	config   config.Route
+	matchRE  map[string]*regexp.Regexp
+	children []*route
+}
+
+// Decision route handling an alert, the receiver it is sent to and the group it is notified with
+type Decision struct {
+	Route    string `json:"route"`
+	Receiver string `json:"receiver"`
+	GroupKey string `json:"groupKey"`
+	Continue bool   `json:"continue,omitempty"`
+}
+
+func newRoute(settings config.Route, path string) (*route, error) {
+	r := &route{path: path, config: settings, matchRE: map[string]*regexp.Regexp{}}
+	if settings.Name != "" {
+		r.path = fmt.Sprintf("%s(%s)", path, settings.Name)
+	}
+	for attribute, expression := range settings.MatchRE {
+		compiled, err := regexp.Compile("^(?:" + expression + ")$")
+		if err != nil {
+			return nil, err
+		}
+		r.matchRE[attribute] = compiled
+	}
+	for i, child := range settings.Routes {
+		compiled, err := newRoute(child, fmt.Sprintf("%s/%d", path, i))
+		if err != nil {
+			return nil, err
+		}
+		r.children = append(r.children, compiled)
+	}
+	return r, nil
+}
+
+// Attributes returns the attributes of an alert the routes match: its cluster labels, cluster, severity,
+// type, name, bucket and node
+func Attributes(alert alerts.Alert) map[string]string {
+	attributes := make(map[string]string, len(alert.Labels)+6)
+	for name, value := range alert.Labels {
+		attributes[name] = value
+	}
+	attributes["cluster"] = alert.Cluster
+	attributes["severity"] = alert.Severity.String()
+	attributes["type"] = alert.Type
+	attributes["name"] = alert.Name
+	attributes["bucket"] = alert.Bucket
+	attributes["node"] = alert.Node
+	return attributes
+}
+
+func (r *route) matches(attributes map[string]string) bool {
+	for attribute, value := range r.config.Match {
+		if attributes[attribute] != value {
+			return false
+		}
+	}
+	for attribute, expression := range r.matchRE {
+		if !expression.MatchString(attributes[attribute]) {
+			return false
+		}
+	}
+	return true
+}
+
+// handlers returns the routes handling the alert below this route, the route itself when none of its
+// children matches
+func (r *route) handlers(attributes map[string]string) []*route {
+	matched := []*route{}
+	for _, child := range r.children {
+		if !child.matches(attributes) {
+			continue
+		}
+		matched = append(matched, child.handlers(attributes)...)
+		if !child.config.Continue {
+			break
+		}
+	}
+	if len(matched) == 0 {
+		return []*route{r}
+	}
+	return matched
+}
+
+// groupLabels returns the values of the GroupBy attributes of the alert
+func (r *route) groupLabels(attributes map[string]string) map[string]string {
+	labels := make(map[string]string, len(r.config.GroupBy))
+	for _, attribute := range r.config.GroupBy {
+		labels[attribute] = attributes[attribute]
+	}
+	return labels
+}
+
+// groupKey identifies the group of the route with the given group labels
+func (r *route) groupKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=%q", name, labels[name])
+	}
+	return fmt.Sprintf("%s{%s}", r.path, strings.Join(pairs, ","))
+}
@@ -0,0 +1,291 @@
+package otlp
+
+import (
+	"bytes"
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/monitor/stats"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const grpcExportPath = "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+
+// Status outcome of the exports, reported by the self status endpoint
+type Status struct {
+	Endpoint            string     `json:"endpoint"`
+	Protocol            string     `json:"protocol"`
+	LastExport          *time.Time `json:"lastExport,omitempty"`
+	LastSuccess         *time.Time `json:"lastSuccess,omitempty"`
+	LastError           string     `json:"lastError,omitempty"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	ExportedPoints      int64      `json:"exportedPoints"`
+	RejectedPoints      int64      `json:"rejectedPoints"`
+}
+
+// Exporter pushes the latest statistics of every cluster to an OpenTelemetry collector on every interval
+type Exporter struct {
+	config  config.OTLP
+	client  *http.Client
+	url     string
+	latest  map[string]sample
+	order   []string
+	status  Status
+	mu      sync.Mutex
+	flushMu sync.Mutex
+}
+
+// NewExporter creates an exporter for the given (normalized) output configuration
+func NewExporter(output config.OTLP) (*Exporter, error) {
+	tlsConfig, err := clientTLS(output.TLS)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	endpoint, err := url.Parse(output.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid otlp endpoint: %w", err)
+	}
+	if output.Protocol == config.OTLPGRPC {
+		// gRPC requires HTTP/2, reached without TLS (h2c) for http:// endpoints
+		protocols := new(http.Protocols)
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+		transport.Protocols = protocols
+		endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + grpcExportPath
+	} else {
+		transport.ForceAttemptHTTP2 = true
+		if endpoint.Path == "" || endpoint.Path == "/" {
+			endpoint.Path = "/v1/metrics"
+		}
+	}
+	return &Exporter{
+		config: output,
+		client: &http.Client{Timeout: output.Timeout.Duration, Transport: transport},
+		url:    endpoint.String(),
+		latest: map[string]sample{},
+		status: Status{Endpoint: endpoint.String(), Protocol: output.Protocol},
+	}, nil
+}
+
+func clientTLS(settings config.ClientTLS) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: settings.InsecureSkipVerify}
+	if settings.CAFile != "" {
+		pem, err := ioutil.ReadFile(settings.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read otlp CA file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in otlp CA file %s", settings.CAFile)
+		}
+	}
+	if settings.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load otlp client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
+
+// Add keeps the statistics of a cluster, only the latest statistics are exported on the next interval
+func (e *Exporter) Add(cluster string, collected time.Time, clusterStats stats.ClusterStats) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, found := e.latest[cluster]; !found {
+		e.order = append(e.order, cluster)
+	}
+	e.latest[cluster] = sample{cluster: cluster, collected: collected, stats: clusterStats}
+}
+
+// Forget drops the statistics of the clusters, they are not exported anymore
+func (e *Exporter) Forget(clusters []string) {
+	forgotten := make(map[string]bool, len(clusters))
+	for _, cluster := range clusters {
+		forgotten[cluster] = true
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	order := []string{}
+	for _, cluster := range e.order {
+		if forgotten[cluster] {
+			delete(e.latest, cluster)
+		} else {
+			order = append(order, cluster)
+		}
+	}
+	e.order = order
+}
+
+// Status returns the outcome of the exports
+func (e *Exporter) Status() Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.status
+}
+
+// Run exports the statistics every interval until stop is closed, they are then exported a last time.
+// The statistics are only exported while open returns true, they are dropped otherwise so that a replica
+// that stopped leading does not export them again
+func (e *Exporter) Run(stop <-chan struct{}, open func() bool) {
+	ticker := time.NewTicker(e.config.Interval.Duration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			e.export(open)
+			return
+		case <-ticker.C:
+			e.export(open)
+		}
+	}
+}
+
+func (e *Exporter) export(open func() bool) {
+	if !open() {
+		e.mu.Lock()
+		e.latest = map[string]sample{}
+		e.order = nil
+		e.mu.Unlock()
+		return
+	}
+	if err := e.Export(); err != nil {
+		slog.Error("Cannot export metrics", "endpoint", e.url, "error", err)
+	}
+}
+
+// Export sends the latest statistics of every cluster, nothing is sent before the first scrape
+func (e *Exporter) Export() error {
+	e.flushMu.Lock()
+	defer e.flushMu.Unlock()
+	e.mu.Lock()
+	samples := make([]sample, 0, len(e.order))
+	for _, cluster := range e.order {
+		samples = append(samples, e.latest[cluster])
+	}
+	e.mu.Unlock()
+	if len(samples) == 0 {
+		return nil
+	}
+	request, points := exportRequest(samples)
+	var rejected int64
+	var err error
+	if e.config.Protocol == config.OTLPGRPC {
+		rejected, err = e.sendGRPC(request)
+	} else {
+		rejected, err = e.sendHTTP(request)
+	}
+
+	now := time.Now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.status.LastExport = &now
+	if err != nil {
+		e.status.LastError = err.Error()
+		e.status.ConsecutiveFailures++
+		return err
+	}
+	e.status.LastSuccess = &now
+	e.status.LastError = ""
+	e.status.ConsecutiveFailures = 0
+	e.status.ExportedPoints += int64(points) - rejected
+	e.status.RejectedPoints += rejected
+	return nil
+}
+
+func (e *Exporter) newRequest(body []byte, contentType string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range e.config.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", contentType)
+	return req, nil
+}
+
+func (e *Exporter) sendHTTP(request []byte) (int64, error) {
+	req, err := e.newRequest(request, "application/x-protobuf")
+	if err != nil {
+		return 0, err
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return 0, fmt.Errorf("collector answered HTTP %d", resp.StatusCode)
+	}
+	return e.partialSuccess(body)
+}
+
+func (e *Exporter) sendGRPC(request []byte) (int64, error) {
+	// Length prefixed message: compression flag and big endian length
+	frame := make([]byte, 5, 5+len(request))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(request)))
+	req, err := e.newRequest(append(frame, request...), "application/grpc")
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("TE", "trailers")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("collector answered HTTP %d", resp.StatusCode)
+	}
+	// Trailers-only responses carry the status in the headers
+	status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if code, err := strconv.Atoi(status); err != nil || code != 0 {
+		if unescaped, err := url.PathUnescape(message); err == nil {
+			message = unescaped
+		}
+		return 0, fmt.Errorf("collector answered gRPC status %s: %s", status, message)
+	}
+	if len(body) < 5 {
+		return 0, nil
+	}
+	if length := binary.BigEndian.Uint32(body[1:5]); uint32(len(body)-5) >= length {
+		body = body[5 : 5+length]
+	}
+	return e.partialSuccess(body)
+}
+
+func (e *Exporter) partialSuccess(response []byte) (int64, error) {
+	rejected, message, err := partialSuccess(response)
+	if err != nil {
+		return 0, fmt.Errorf("cannot decode collector response: %w", err)
+	}
+	if rejected > 0 || message != "" {
+		slog.Warn("Collector rejected data points", "endpoint", e.url, "rejected", rejected, "message", message)
+	}
+	return rejected, nil
+}
@@ -0,0 +1,207 @@
+package otlp
+
+import (
+	"bytes"
+	"cbmonitor/internal/config"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector OTLP endpoint keeping the requests it receives and answering with the given response
+type collector struct {
+	server   *httptest.Server
+	status   int
+	response []byte
+	requests []*http.Request
+	bodies   [][]byte
+	mu       sync.Mutex
+}
+
+func (c *collector) record(r *http.Request) []byte {
+	body, _ := ioutil.ReadAll(r.Body)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = append(c.requests, r)
+	c.bodies = append(c.bodies, body)
+	return body
+}
+
+func newHTTPCollector(t *testing.T, status int, response []byte) *collector {
+	t.Helper()
+	c := &collector{status: status, response: response}
+	c.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.record(r)
+		w.WriteHeader(c.status)
+		w.Write(c.response)
+	}))
+	t.Cleanup(c.server.Close)
+	return c
+}
+
+// newGRPCCollector starts a collector speaking gRPC over unencrypted HTTP/2, answering with the given gRPC
+// status
+func newGRPCCollector(t *testing.T, grpcStatus, grpcMessage string, response []byte) *collector {
+	t.Helper()
+	c := &collector{response: response}
+	c.server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			t.Errorf("gRPC request over %s, want HTTP/2", r.Proto)
+		}
+		c.record(r)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		frame := make([]byte, 5, 5+len(c.response))
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(c.response)))
+		w.Write(append(frame, c.response...))
+		w.Header().Set("Grpc-Status", grpcStatus)
+		w.Header().Set("Grpc-Message", grpcMessage)
+	}))
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	c.server.Config.Protocols = protocols
+	c.server.Start()
+	t.Cleanup(c.server.Close)
+	return c
+}
+
+func newTestExporter(t *testing.T, endpoint, protocol string) *Exporter {
+	t.Helper()
+	e, err := NewExporter(config.OTLP{
+		Endpoint: endpoint,
+		Protocol: protocol,
+		Headers:  map[string]string{"Authorization": "Bearer secret"},
+		Interval: config.Duration{Duration: time.Hour},
+		Timeout:  config.Duration{Duration: 5 * time.Second},
+	})
+	if err != nil {
+		t.Fatalf("NewExporter() = %v", err)
+	}
+	return e
+}
+
+func TestExportHTTP(t *testing.T) {
+	c := newHTTPCollector(t, http.StatusOK, nil)
+	e := newTestExporter(t, c.server.URL, config.OTLPHTTP)
+	if err := e.Export(); err != nil || len(c.requests) != 0 {
+		t.Fatalf("Export() before the first scrape = %v with %d requests, want nothing sent", err, len(c.requests))
+	}
+	s := testSample()
+	e.Add(s.cluster, s.collected, s.stats)
+	if err := e.Export(); err != nil {
+		t.Fatalf("Export() = %v", err)
+	}
+	if len(c.requests) != 1 {
+		t.Fatalf("%d requests, want 1", len(c.requests))
+	}
+	request := c.requests[0]
+	if request.URL.Path != "/v1/metrics" || request.Header.Get("Content-Type") != "application/x-protobuf" ||
+		request.Header.Get("Authorization") != "Bearer secret" {
+		t.Errorf("request %s %s with headers %v", request.Method, request.URL.Path, request.Header)
+	}
+	want, points := exportRequest([]sample{s})
+	if !bytes.Equal(c.bodies[0], want) {
+		t.Errorf("body differs from exportRequest()")
+	}
+	if status := e.Status(); status.ExportedPoints != int64(points) || status.LastSuccess == nil ||
+		status.ConsecutiveFailures != 0 {
+		t.Errorf("Status() = %+v, want %d points exported", status, points)
+	}
+}
+
+func TestExportHTTPFailures(t *testing.T) {
+	c := newHTTPCollector(t, http.StatusOK, []byte("\x0a\x0c\x08\x03\x12\x08too many"))
+	e := newTestExporter(t, c.server.URL+"/custom/path", config.OTLPHTTP)
+	s := testSample()
+	e.Add(s.cluster, s.collected, s.stats)
+	if err := e.Export(); err != nil {
+		t.Fatalf("Export() = %v", err)
+	}
+	_, points := exportRequest([]sample{s})
+	if status := e.Status(); status.RejectedPoints != 3 || status.ExportedPoints != int64(points-3) {
+		t.Errorf("Status() = %+v, want 3 points rejected", status)
+	}
+	if path := c.requests[0].URL.Path; path != "/custom/path" {
+		t.Errorf("exported to %s, want the configured path", path)
+	}
+
+	c.status = http.StatusServiceUnavailable
+	c.response = nil
+	for i := 0; i < 2; i++ {
+		if err := e.Export(); err == nil {
+			t.Fatalf("Export() to a failing collector succeeded")
+		}
+	}
+	if status := e.Status(); status.ConsecutiveFailures != 2 || status.LastError == "" {
+		t.Errorf("Status() = %+v, want 2 failures", status)
+	}
+}
+
+func TestExportGRPC(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   string
+		message  string
+		response []byte
+		rejected int64
+		err      bool
+	}{
+		{name: "success", status: "0"},
+		{name: "partial success", status: "0", response: []byte("\x0a\x02\x08\x02"), rejected: 2},
+		{name: "unavailable", status: "14", message: "collector%20overloaded", err: true},
+	}
+	for _, test := range tests {
+		c := newGRPCCollector(t, test.status, test.message, test.response)
+		e := newTestExporter(t, c.server.URL, config.OTLPGRPC)
+		s := testSample()
+		e.Add(s.cluster, s.collected, s.stats)
+		err := e.Export()
+		if (err != nil) != test.err {
+			t.Errorf("%s: Export() = %v", test.name, err)
+		}
+		if len(c.requests) != 1 {
+			t.Fatalf("%s: %d requests, want 1", test.name, len(c.requests))
+		}
+		if path := c.requests[0].URL.Path; path != grpcExportPath {
+			t.Errorf("%s: exported to %s", test.name, path)
+		}
+		want, _ := exportRequest([]sample{s})
+		body := c.bodies[0]
+		if len(body) < 5 || body[0] != 0 || binary.BigEndian.Uint32(body[1:5]) != uint32(len(want)) ||
+			!bytes.Equal(body[5:], want) {
+			t.Errorf("%s: body is not the length prefixed request", test.name)
+		}
+		if status := e.Status(); status.RejectedPoints != test.rejected {
+			t.Errorf("%s: %d points rejected, want %d", test.name, status.RejectedPoints, test.rejected)
+		}
+		if test.err && e.Status().LastError != "collector answered gRPC status 14: collector overloaded" {
+			t.Errorf("%s: LastError = %q", test.name, e.Status().LastError)
+		}
+	}
+}
+
+func TestForgetAndClosedExports(t *testing.T) {
+	c := newHTTPCollector(t, http.StatusOK, nil)
+	e := newTestExporter(t, c.server.URL, config.OTLPHTTP)
+	east, west := testSample(), testSample()
+	west.cluster = "west"
+	e.Add(east.cluster, east.collected, east.stats)
+	e.Add(west.cluster, west.collected, west.stats)
+	e.Forget([]string{"east"})
+	e.export(func() bool { return true })
+	if want, _ := exportRequest([]sample{west}); len(c.bodies) != 1 || !bytes.Equal(c.bodies[0], want) {
+		t.Errorf("export() did not send the statistics of west only")
+	}
+
+	e.Add(east.cluster, east.collected, east.stats)
+	e.export(func() bool { return false })
+	e.export(func() bool { return true })
+	if len(c.bodies) != 1 {
+		t.Errorf("%d requests, want the statistics dropped while closed", len(c.bodies))
+	}
+}
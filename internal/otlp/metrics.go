@@ -0,0 +1,103 @@
+package otlp
+
+import (
+	"cbmonitor/internal/labels"
+	"cbmonitor/internal/monitor/stats"
+	"sort"
+	"time"
+)
+
+const (
+	scopeName = "cbmonitor"
+	// ClusterAttribute resource attribute holding the configured cluster name
+	ClusterAttribute = "couchbase.cluster.name"
+	// NodeAttribute data point attribute holding the node hostname
+	NodeAttribute = "couchbase.node"
+	// BucketAttribute data point attribute holding the bucket name
+	BucketAttribute = "couchbase.bucket"
+)
+
+// sample latest statistics of a cluster
+type sample struct {
+	cluster   string
+	collected time.Time
+	stats     stats.ClusterStats
+}
+
+type dataPoint struct {
+	attributes [][2]string
+	value      float64
+}
+
+// gauges groups the values of every metric of the sample by metric name
+func (s sample) gauges() map[string][]dataPoint {
+	gauges := map[string][]dataPoint{}
+	add := func(prefix string, attributes [][2]string, fields map[string]float64) {
+		for name, value := range fields {
+			gauges[prefix+name] = append(gauges[prefix+name], dataPoint{attributes: attributes, value: value})
+		}
+	}
+	add("couchbase.cluster.", nil, s.stats.Metrics())
+	for _, node := range s.stats.Nodes {
+		add("couchbase.node.", [][2]string{{NodeAttribute, node.Hostname}}, node.Metrics())
+	}
+	for _, bucket := range s.stats.Buckets {
+		add("couchbase.bucket.", [][2]string{{BucketAttribute, bucket.Name}}, bucket.Metrics())
+	}
+	return gauges
+}
+
+// exportRequest encodes an ExportMetricsServiceRequest with a resource per cluster, carrying its labels as
+// attributes, and a gauge per metric.
+// It returns the number of data points of the request
+func exportRequest(samples []sample) ([]byte, int) {
+	request := &message{}
+	points := 0
+	for _, s := range samples {
+		resource := &message{}
+		resource.embed(1, keyValue(ClusterAttribute, s.cluster))
+		if s.stats.Name != "" {
+			resource.embed(1, keyValue("couchbase.cluster.reported_name", s.stats.Name))
+		}
+		resource.embed(1, keyValue("service.name", scopeName))
+		for _, name := range labels.Sorted(s.stats.Labels) {
+			resource.embed(1, keyValue(name, s.stats.Labels[name]))
+		}
+
+		scope := &message{}
+		scope.string(1, scopeName)
+		scopeMetrics := &message{}
+		scopeMetrics.embed(1, scope)
+
+		gauges := s.gauges()
+		names := make([]string, 0, len(gauges))
+		for name := range gauges {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		timestamp := uint64(s.collected.UnixNano())
+		for _, name := range names {
+			gauge := &message{}
+			for _, point := range gauges[name] {
+				dataPoint := &message{}
+				dataPoint.fixed64(3, timestamp)
+				dataPoint.double(4, point.value)
+				for _, attribute := range point.attributes {
+					dataPoint.embed(7, keyValue(attribute[0], attribute[1]))
+				}
+				gauge.embed(1, dataPoint)
+				points++
+			}
+			metric := &message{}
+			metric.string(1, name)
+			metric.embed(5, gauge)
+			scopeMetrics.embed(2, metric)
+		}
+
+		resourceMetrics := &message{}
+		resourceMetrics.embed(1, resource)
+		resourceMetrics.embed(2, scopeMetrics)
+		request.embed(1, resourceMetrics)
+	}
+	return request.buffer, points
+}
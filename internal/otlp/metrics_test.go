@@ -0,0 +1,121 @@
+package otlp
+
+import (
+	"cbmonitor/internal/monitor/stats"
+	"sort"
+	"testing"
+	"time"
+)
+
+// fields decodes the fields of a message by field number, failing the test when it is malformed
+func fields(t *testing.T, buffer []byte) map[int][][]byte {
+	t.Helper()
+	decoded := map[int][][]byte{}
+	for len(buffer) > 0 {
+		number, wireType, _, value, rest, err := field(buffer)
+		if err != nil {
+			t.Fatalf("malformed message: %v", err)
+		}
+		if wireType == wireBytes {
+			decoded[number] = append(decoded[number], value)
+		}
+		buffer = rest
+	}
+	return decoded
+}
+
+// testSample returns statistics of the east cluster with a bucket, collected a second after the epoch
+func testSample() sample {
+	clusterStats := stats.ClusterStats{RAMPctUsed: 42.5, Labels: map[string]string{"env": "prod"}}
+	clusterStats.Buckets = []stats.Bucket{{Name: "orders", ItemCount: 1200}}
+	return sample{cluster: "east", collected: time.Unix(1, 0), stats: clusterStats}
+}
+
+func TestExportRequestGolden(t *testing.T) {
+	request, points := exportRequest([]sample{testSample()})
+	resourceMetrics := fields(t, request)[1]
+	if len(resourceMetrics) != 1 {
+		t.Fatalf("%d resource metrics, want 1", len(resourceMetrics))
+	}
+	resourceFields := fields(t, resourceMetrics[0])
+
+	wantResource := "" +
+		"\x0a\x20\x0a\x16couchbase.cluster.name\x12\x06\x0a\x04east" +
+		"\x0a\x1b\x0a\x0cservice.name\x12\x0b\x0a\x09cbmonitor" +
+		"\x0a\x0d\x0a\x03env\x12\x06\x0a\x04prod"
+	if got := string(resourceFields[1][0]); got != wantResource {
+		t.Errorf("resource = %q, want %q", got, wantResource)
+	}
+
+	scopeMetrics := fields(t, resourceFields[2][0])
+	if got := string(scopeMetrics[1][0]); got != "\x0a\x09cbmonitor" {
+		t.Errorf("scope = %q, want cbmonitor", got)
+	}
+	metrics := map[string]string{}
+	names := []string{}
+	for _, metric := range scopeMetrics[2] {
+		name := string(fields(t, metric)[1][0])
+		metrics[name] = string(metric)
+		names = append(names, name)
+	}
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("metrics not sorted by name: %v", names)
+	}
+	clusterStats := testSample().stats
+	if want := len(clusterStats.Metrics()) + len(clusterStats.Buckets[0].Metrics()); points != want ||
+		len(names) != want {
+		t.Errorf("%d points in %d metrics, want %d", points, len(names), want)
+	}
+
+	timestamp := "\x19\x00\xca\x9a\x3b\x00\x00\x00\x00"
+	golden := map[string]string{
+		"couchbase.cluster.ram_pct_used": "\x0a\x1ecouchbase.cluster.ram_pct_used" +
+			"\x2a\x14\x0a\x12" + timestamp + "\x21\x00\x00\x00\x00\x00\x40\x45\x40",
+		"couchbase.bucket.item_count": "\x0a\x1bcouchbase.bucket.item_count" +
+			"\x2a\x32\x0a\x30" + timestamp + "\x21\x00\x00\x00\x00\x00\xc0\x92\x40" +
+			"\x3a\x1c\x0a\x10couchbase.bucket\x12\x08\x0a\x06orders",
+	}
+	for name, want := range golden {
+		if got := metrics[name]; got != want {
+			t.Errorf("metric %s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestExportRequestResources(t *testing.T) {
+	east, west := testSample(), testSample()
+	west.cluster = "west"
+	west.stats.Name = "West Cluster"
+	west.stats.Labels = map[string]string{"team": "payments", "env": "staging"}
+	west.stats.Buckets = nil
+	request, points := exportRequest([]sample{east, west})
+
+	resourceMetrics := fields(t, request)[1]
+	if len(resourceMetrics) != 2 {
+		t.Fatalf("%d resource metrics, want one per cluster", len(resourceMetrics))
+	}
+	want := [][][2]string{
+		{{ClusterAttribute, "east"}, {"service.name", "cbmonitor"}, {"env", "prod"}},
+		{{ClusterAttribute, "west"}, {"couchbase.cluster.reported_name", "West Cluster"},
+			{"service.name", "cbmonitor"}, {"env", "staging"}, {"team", "payments"}},
+	}
+	for i, resourceMetric := range resourceMetrics {
+		attributes := fields(t, fields(t, resourceMetric)[1][0])[1]
+		if len(attributes) != len(want[i]) {
+			t.Errorf("resource %d has %d attributes, want %d", i, len(attributes), len(want[i]))
+			continue
+		}
+		for k, attribute := range attributes {
+			if got, expected := string(attribute), string(keyValue(want[i][k][0], want[i][k][1]).buffer); got != expected {
+				t.Errorf("resource %d attribute %d = %q, want %q", i, k, got, expected)
+			}
+		}
+	}
+	wantPoints := 2*len(east.stats.Metrics()) + len(east.stats.Buckets[0].Metrics())
+	if points != wantPoints {
+		t.Errorf("exportRequest() = %d points, want %d", points, wantPoints)
+	}
+	if request, points := exportRequest(nil); len(request) != 0 || points != 0 {
+		t.Errorf("exportRequest(nil) = %q, %d, want an empty request", request, points)
+	}
+}
@@ -0,0 +1,124 @@
+package otlp
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Minimal protobuf encoding of the OTLP metrics messages, see
+// https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/metrics/v1/metrics.proto
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+type message struct {
+	buffer []byte
+}
+
+func (m *message) tag(field, wireType int) {
+	m.buffer = binary.AppendUvarint(m.buffer, uint64(field<<3|wireType))
+}
+
+func (m *message) bytes(field int, value []byte) {
+	m.tag(field, wireBytes)
+	m.buffer = binary.AppendUvarint(m.buffer, uint64(len(value)))
+	m.buffer = append(m.buffer, value...)
+}
+
+func (m *message) string(field int, value string) {
+	if value != "" {
+		m.bytes(field, []byte(value))
+	}
+}
+
+func (m *message) embed(field int, child *message) {
+	m.bytes(field, child.buffer)
+}
+
+func (m *message) fixed64(field int, value uint64) {
+	m.tag(field, wireFixed64)
+	m.buffer = binary.LittleEndian.AppendUint64(m.buffer, value)
+}
+
+func (m *message) double(field int, value float64) {
+	m.fixed64(field, math.Float64bits(value))
+}
+
+// keyValue encodes a KeyValue with a string AnyValue
+func keyValue(key, value string) *message {
+	anyValue := &message{}
+	anyValue.string(1, value)
+	keyValue := &message{}
+	keyValue.string(1, key)
+	keyValue.embed(2, anyValue)
+	return keyValue
+}
+
+var errTruncated = errors.New("truncated protobuf message")
+
+// field reads the next field of a message, value holds the varint or the bytes of the field
+func field(buffer []byte) (number, wireType int, varint uint64, value []byte, rest []byte, err error) {
+	key, n := binary.Uvarint(buffer)
+	if n <= 0 {
+		return 0, 0, 0, nil, nil, errTruncated
+	}
+	buffer = buffer[n:]
+	number, wireType = int(key>>3), int(key&7)
+	switch wireType {
+	case wireVarint:
+		varint, n = binary.Uvarint(buffer)
+		if n <= 0 {
+			return 0, 0, 0, nil, nil, errTruncated
+		}
+		return number, wireType, varint, nil, buffer[n:], nil
+	case wireFixed64:
+		if len(buffer) < 8 {
+			return 0, 0, 0, nil, nil, errTruncated
+		}
+		return number, wireType, binary.LittleEndian.Uint64(buffer), nil, buffer[8:], nil
+	case wireBytes:
+		length, n := binary.Uvarint(buffer)
+		if n <= 0 || uint64(len(buffer)-n) < length {
+			return 0, 0, 0, nil, nil, errTruncated
+		}
+		return number, wireType, 0, buffer[n : n+int(length)], buffer[n+int(length):], nil
+	case 5:
+		if len(buffer) < 4 {
+			return 0, 0, 0, nil, nil, errTruncated
+		}
+		return number, wireType, uint64(binary.LittleEndian.Uint32(buffer)), nil, buffer[4:], nil
+	}
+	return 0, 0, 0, nil, nil, errors.New("unsupported protobuf wire type")
+}
+
+// partialSuccess decodes the rejected data points and error message of an ExportMetricsServiceResponse
+func partialSuccess(response []byte) (rejected int64, message string, err error) {
+	for len(response) > 0 {
+		number, _, _, value, rest, err := field(response)
+		if err != nil {
+			return 0, "", err
+		}
+		response = rest
+		if number != 1 {
+			continue
+		}
+		for len(value) > 0 {
+			inner, _, varint, innerValue, innerRest, err := field(value)
+			if err != nil {
+				return 0, "", err
+			}
+			value = innerRest
+			switch inner {
+			case 1:
+				rejected = int64(varint)
+			case 2:
+				message = string(innerValue)
+			}
+		}
+	}
+	return rejected, message, nil
+}
@@ -0,0 +1,114 @@
+package otlp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestMessage(t *testing.T) {
+	tests := []struct {
+		name   string
+		encode func(*message)
+		want   string
+	}{
+		{name: "string", encode: func(m *message) { m.string(1, "east") }, want: "\x0a\x04east"},
+		{name: "empty string", encode: func(m *message) { m.string(1, "") }, want: ""},
+		{name: "large field number", encode: func(m *message) { m.string(16, "a") }, want: "\x82\x01\x01a"},
+		{name: "long bytes", encode: func(m *message) { m.bytes(2, bytes.Repeat([]byte{'x'}, 200)) },
+			want: "\x12\xc8\x01" + string(bytes.Repeat([]byte{'x'}, 200))},
+		{name: "fixed64", encode: func(m *message) { m.fixed64(3, 1000000000) },
+			want: "\x19\x00\xca\x9a\x3b\x00\x00\x00\x00"},
+		{name: "double", encode: func(m *message) { m.double(4, 42.5) },
+			want: "\x21\x00\x00\x00\x00\x00\x40\x45\x40"},
+		{name: "negative double", encode: func(m *message) { m.double(4, -2) },
+			want: "\x21\x00\x00\x00\x00\x00\x00\x00\xc0"},
+		{name: "embedded", encode: func(m *message) {
+			child := &message{}
+			child.string(1, "cbmonitor")
+			m.embed(1, child)
+		}, want: "\x0a\x0b\x0a\x09cbmonitor"},
+		{name: "empty embedded", encode: func(m *message) { m.embed(2, &message{}) }, want: "\x12\x00"},
+	}
+	for _, test := range tests {
+		m := &message{}
+		test.encode(m)
+		if string(m.buffer) != test.want {
+			t.Errorf("%s: encoded %q, want %q", test.name, m.buffer, test.want)
+		}
+	}
+}
+
+func TestKeyValue(t *testing.T) {
+	want := "\x0a\x03env\x12\x06\x0a\x04prod"
+	if got := keyValue("env", "prod").buffer; string(got) != want {
+		t.Errorf("keyValue() = %q, want %q", got, want)
+	}
+	// an empty value is still a string AnyValue, without its field
+	want = "\x0a\x03env\x12\x00"
+	if got := keyValue("env", "").buffer; string(got) != want {
+		t.Errorf("keyValue() with empty value = %q, want %q", got, want)
+	}
+}
+
+func TestField(t *testing.T) {
+	tests := []struct {
+		name     string
+		buffer   string
+		number   int
+		wireType int
+		varint   uint64
+		value    string
+		rest     string
+		err      bool
+	}{
+		{name: "varint", buffer: "\x08\x96\x01rest", number: 1, wireType: wireVarint, varint: 150, rest: "rest"},
+		{name: "bytes", buffer: "\x12\x02okrest", number: 2, wireType: wireBytes, value: "ok", rest: "rest"},
+		{name: "fixed64", buffer: "\x19\x01\x00\x00\x00\x00\x00\x00\x00", number: 3, wireType: wireFixed64,
+			varint: 1},
+		{name: "fixed32", buffer: "\x25\x02\x00\x00\x00", number: 4, wireType: 5, varint: 2},
+		{name: "empty", buffer: "", err: true},
+		{name: "truncated varint", buffer: "\x08\x96", err: true},
+		{name: "truncated bytes", buffer: "\x12\x05ok", err: true},
+		{name: "truncated fixed64", buffer: "\x19\x01\x00", err: true},
+		{name: "truncated fixed32", buffer: "\x25\x01", err: true},
+		{name: "group", buffer: "\x0b", err: true},
+	}
+	for _, test := range tests {
+		number, wireType, varint, value, rest, err := field([]byte(test.buffer))
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: field() succeeded, want an error", test.name)
+			}
+			continue
+		}
+		if err != nil || number != test.number || wireType != test.wireType || varint != test.varint ||
+			string(value) != test.value || string(rest) != test.rest {
+			t.Errorf("%s: field() = %d %d %d %q %q %v", test.name, number, wireType, varint, value, rest, err)
+		}
+	}
+}
+
+func TestPartialSuccess(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		rejected int64
+		message  string
+		err      error
+	}{
+		{name: "empty response", response: ""},
+		{name: "rejected", response: "\x0a\x0c\x08\x03\x12\x08too many", rejected: 3, message: "too many"},
+		{name: "message only", response: "\x0a\x04\x12\x02no", message: "no"},
+		{name: "unknown fields", response: "\x10\x01\x0a\x02\x08\x07\x1a\x01x", rejected: 7},
+		{name: "truncated", response: "\x0a\x0c\x08\x03", err: errTruncated},
+		{name: "truncated partial success", response: "\x0a\x02\x12\x05", err: errTruncated},
+	}
+	for _, test := range tests {
+		rejected, message, err := partialSuccess([]byte(test.response))
+		if !errors.Is(err, test.err) || rejected != test.rejected || message != test.message {
+			t.Errorf("%s: partialSuccess() = %d %q %v, want %d %q %v", test.name, rejected, message, err,
+				test.rejected, test.message, test.err)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package shard
+
+import (
+	"cbmonitor/internal/config"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Modes of sharding
+const (
+	ModeStatic = "static"
+	ModePeers  = "peers"
+)
+
+// Path of the endpoint describing the shard of an instance, probed by its peers
+const Path = "/shard"
+
+// Owner returns the member owning the key by rendezvous hashing: the member with the highest hash of
+// itself and the key. A member joining only takes keys over, a member leaving only hands its own keys over
+func Owner(key string, members []string) string {
+	owner, highest := "", uint64(0)
+	for _, member := range members {
+		if weight := hash(member, key); owner == "" || weight > highest {
+			owner, highest = member, weight
+		}
+	}
+	return owner
+}
+
+// hash mixes the FNV hash of member and key so that close members still spread the keys evenly
+func hash(member, key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(member))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// Peer outcome of the latest probe of a peer
+type Peer struct {
+	URL       string     `json:"url"`
+	Up        bool       `json:"up"`
+	LastProbe *time.Time `json:"lastProbe,omitempty"`
+	LastError string     `json:"lastError,omitempty"`
+}
+
+// Status shard of the instance: the members sharing the clusters, and the peers probed
+type Status struct {
+	Mode       string     `json:"mode"`
+	ID         string     `json:"id"`
+	Members    []string   `json:"members"`
+	Peers      []Peer     `json:"peers,omitempty"`
+	Rebalanced *time.Time `json:"rebalanced,omitempty"`
+}
+
+// Membership tells which clusters the instance owns. Static shards are numbered from 0 to the count, with
+// peers the members are the instances answering the probes. Instances may disagree on the members for a
+// probe interval after one joined or left, a cluster is then scraped twice or not at all
+type Membership struct {
+	config     config.Sharding
+	client     *http.Client
+	id         string
+	members    []string
+	peers      map[string]*Peer
+	rebalanced *time.Time
+	mu         sync.Mutex
+}
+
+// NewMembership creates the membership of the instance for the given (normalized) settings. Without
+// sharding it is the only member, with peers it starts alone until they answer
+func NewMembership(settings config.Sharding) *Membership {
+	m := &Membership{
+		config: settings,
+		client: &http.Client{Timeout: settings.Timeout.Duration},
+		peers:  map[string]*Peer{},
+	}
+	switch {
+	case settings.Static():
+		m.id = strconv.Itoa(settings.Index)
+		for i := 0; i < settings.Count; i++ {
+			m.members = append(m.members, strconv.Itoa(i))
+		}
+	case settings.Enabled():
+		m.id = settings.Self
+		m.members = []string{settings.Self}
+	}
+	for _, peer := range settings.Peers {
+		m.peers[peer] = &Peer{URL: peer}
+	}
+	return m
+}
+
+// Enabled tells whether the clusters are split between instances
+func (m *Membership) Enabled() bool {
+	return m.config.Enabled()
+}
+
+// Owns tells whether the instance owns the cluster
+func (m *Membership) Owns(cluster string) bool {
+	if !m.Enabled() {
+		return true
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return Owner(cluster, m.members) == m.id
+}
+
+// Peers returns the URLs of the peers that answered their latest probe
+func (m *Membership) Peers() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	up := []string{}
+	for _, peer := range m.config.Peers {
+		if m.peers[peer].Up {
+			up = append(up, peer)
+		}
+	}
+	return up
+}
+
+// Status returns the shard of the instance
+func (m *Membership) Status() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status := Status{Mode: ModePeers, ID: m.id, Members: append([]string{}, m.members...), Peers: []Peer{}}
+	if m.config.Static() {
+		status.Mode = ModeStatic
+	}
+	for _, peer := range m.config.Peers {
+		status.Peers = append(status.Peers, *m.peers[peer])
+	}
+	if m.rebalanced != nil {
+		rebalanced := *m.rebalanced
+		status.Rebalanced = &rebalanced
+	}
+	return status
+}
+
+// Run probes the peers every probe interval until stop is closed, with peers as members the clusters are
+// rebalanced as soon as one joins or leaves. The peers are expected to be probed once before
+func (m *Membership) Run(stop <-chan struct{}) {
+	if len(m.config.Peers) == 0 {
+		return
+	}
+	ticker := time.NewTicker(m.config.ProbeInterval.Duration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			m.Probe(now)
+		}
+	}
+}
+
+// Probe checks every peer at once and updates the members from the answers
+func (m *Membership) Probe(now time.Time) {
+	errs := make([]error, len(m.config.Peers))
+	var probes sync.WaitGroup
+	for i, peer := range m.config.Peers {
+		probes.Add(1)
+		go func(i int, peer string) {
+			defer probes.Done()
+			var status Status
+			errs[i] = m.Get(peer, Path, nil, &status)
+			if errs[i] == nil && status.Mode == ModePeers && status.ID != peer {
+				errs[i] = fmt.Errorf("%s identifies itself as %s", peer, status.ID)
+			}
+		}(i, peer)
+	}
+	probes.Wait()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, peer := range m.config.Peers {
+		state := m.peers[peer]
+		probed := now
+		state.LastProbe = &probed
+		state.LastError = ""
+		if errs[i] != nil {
+			state.LastError = errs[i].Error()
+		}
+		if up := errs[i] == nil; up != state.Up {
+			state.Up = up
+			if up {
+				slog.Info("Shard peer up", "peer", peer)
+			} else {
+				slog.Warn("Shard peer down", "peer", peer, "error", errs[i])
+			}
+		}
+	}
+	if m.config.Static() {
+		return
+	}
+	members := []string{m.config.Self}
+	for _, peer := range m.config.Peers {
+		if m.peers[peer].Up {
+			members = append(members, peer)
+		}
+	}
+	sort.Strings(members)
+	if strings.Join(members, " ") != strings.Join(m.members, " ") {
+		m.members = members
+		m.rebalanced = &now
+		slog.Info("Shard members changed, rebalancing the clusters", "members", len(members))
+	}
+}
+
+// Get decodes the JSON answer of a peer to a GET request, the token authenticates the instance
+func (m *Membership) Get(peer, path string, query url.Values, into interface{}) error {
+	target := peer + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	request, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	if m.config.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+m.config.Token)
+	}
+	response, err := m.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", target, response.Status)
+	}
+	if err := json.NewDecoder(response.Body).Decode(into); err != nil {
+		return fmt.Errorf("invalid answer from %s: %w", target, err)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package shard
+
+import (
+	"cbmonitor/internal/config"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testKeys returns cluster names to spread between members
+func testKeys(count int) []string {
+	keys := make([]string, count)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("cluster-%03d", i)
+	}
+	return keys
+}
+
+func TestOwnerSpreadsKeys(t *testing.T) {
+	members := []string{"http://a:3000", "http://b:3000", "http://c:3000"}
+	owned := map[string]int{}
+	for _, key := range testKeys(300) {
+		owner := Owner(key, members)
+		if owner != Owner(key, []string{members[2], members[0], members[1]}) {
+			t.Errorf("Owner(%q) depends on the order of the members", key)
+		}
+		owned[owner]++
+	}
+	for _, member := range members {
+		if owned[member] < 60 {
+			t.Errorf("%s owns %d keys of 300, want about 100", member, owned[member])
+		}
+	}
+	if owner := Owner("cluster", nil); owner != "" {
+		t.Errorf("Owner() without members = %q, want none", owner)
+	}
+}
+
+func TestOwnerStability(t *testing.T) {
+	members := []string{"http://a:3000", "http://b:3000", "http://c:3000"}
+	tests := []struct {
+		name   string
+		after  []string
+		member string
+		joins  bool
+	}{
+		{name: "join", after: append(append([]string{}, members...), "http://d:3000"), member: "http://d:3000",
+			joins: true},
+		{name: "leave", after: []string{"http://a:3000", "http://c:3000"}, member: "http://b:3000"},
+	}
+	for _, test := range tests {
+		moved := 0
+		for _, key := range testKeys(300) {
+			before, after := Owner(key, members), Owner(key, test.after)
+			if before == after {
+				continue
+			}
+			moved++
+			if test.joins && after != test.member {
+				t.Errorf("%s: %q moved from %s to %s, want to %s only", test.name, key, before, after, test.member)
+			}
+			if !test.joins && before != test.member {
+				t.Errorf("%s: %q moved from %s to %s, want from %s only", test.name, key, before, after,
+					test.member)
+			}
+		}
+		if moved == 0 {
+			t.Errorf("%s: no key moved", test.name)
+		}
+	}
+}
+
+func TestStaticOwns(t *testing.T) {
+	keys := testKeys(100)
+	owners := map[string][]int{}
+	for index := 0; index < 3; index++ {
+		membership := NewMembership(config.Sharding{Index: index, Count: 3})
+		if status := membership.Status(); status.Mode != ModeStatic || status.ID != fmt.Sprint(index) ||
+			len(status.Members) != 3 {
+			t.Errorf("Status() = %+v, want static shard %d of 3", status, index)
+		}
+		for _, key := range keys {
+			if membership.Owns(key) {
+				owners[key] = append(owners[key], index)
+			}
+		}
+	}
+	for _, key := range keys {
+		if len(owners[key]) != 1 {
+			t.Errorf("%q owned by shards %v, want exactly one", key, owners[key])
+		}
+	}
+	unsharded := NewMembership(config.Sharding{})
+	for _, key := range keys {
+		if !unsharded.Owns(key) {
+			t.Fatalf("without sharding Owns(%q) = false, want true", key)
+		}
+	}
+}
+
+// newTestPeer starts a peer answering the probes with the given ID, or with its own URL when empty
+func newTestPeer(t *testing.T, id string) *httptest.Server {
+	t.Helper()
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != Path {
+			http.NotFound(w, r)
+			return
+		}
+		status := Status{Mode: ModePeers, ID: id}
+		if id == "" {
+			status.ID = "http://" + r.Host
+		}
+		json.NewEncoder(w).Encode(status)
+	}))
+	t.Cleanup(peer.Close)
+	return peer
+}
+
+func TestProbeRebalances(t *testing.T) {
+	up, misnamed := newTestPeer(t, ""), newTestPeer(t, "http://elsewhere:3000")
+	down := newTestPeer(t, "")
+	down.Close()
+	membership := NewMembership(config.Sharding{
+		Self:    "http://self:3000",
+		Peers:   []string{up.URL, misnamed.URL, down.URL},
+		Timeout: config.Duration{Duration: time.Second},
+	})
+	if status := membership.Status(); len(status.Members) != 1 || status.Rebalanced != nil {
+		t.Fatalf("Status() before probing = %+v, want alone", status)
+	}
+	now := time.Now()
+	membership.Probe(now)
+	status := membership.Status()
+	if len(status.Members) != 2 || status.Members[0] != up.URL || status.Members[1] != "http://self:3000" {
+		t.Errorf("Members = %v, want %s and self", status.Members, up.URL)
+	}
+	if status.Rebalanced == nil || !status.Rebalanced.Equal(now) {
+		t.Errorf("Rebalanced = %v, want %s", status.Rebalanced, now)
+	}
+	if peers := membership.Peers(); len(peers) != 1 || peers[0] != up.URL {
+		t.Errorf("Peers() = %v, want %s", peers, up.URL)
+	}
+	for _, peer := range status.Peers {
+		if peer.URL != up.URL && peer.LastError == "" {
+			t.Errorf("peer %s has no error", peer.URL)
+		}
+	}
+	for _, key := range testKeys(50) {
+		if membership.Owns(key) != (Owner(key, status.Members) == "http://self:3000") {
+			t.Errorf("Owns(%q) disagrees with the members", key)
+		}
+	}
+	up.Close()
+	membership.Probe(now.Add(time.Second))
+	if status := membership.Status(); len(status.Members) != 1 || !status.Rebalanced.Equal(now.Add(time.Second)) {
+		t.Errorf("Status() after the peer left = %+v, want alone", status)
+	}
+}
@@ -0,0 +1,200 @@
+package sink
+
+import (
+	"cbmonitor/internal/logging"
+	"cbmonitor/internal/metrics"
+	"cbmonitor/internal/monitor"
+	"cbmonitor/internal/monitor/stats"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Sink receives every scrape result, successful or not. Write is only called from a single goroutine
+type Sink interface {
+	Name() string
+	Write(info monitor.ClusterInfo) error
+}
+
+// Status delivery counters of a registered sink
+type Status struct {
+	Name       string     `json:"name"`
+	BufferSize int        `json:"bufferSize"`
+	Queued     int        `json:"queued"`
+	Delivered  int64      `json:"delivered"`
+	Dropped    int64      `json:"dropped"`
+	Failures   int64      `json:"failures"`
+	LastError  string     `json:"lastError,omitempty"`
+	LastWrite  *time.Time `json:"lastWrite,omitempty"`
+}
+
+type funcSink struct {
+	name  string
+	write func(monitor.ClusterInfo) error
+}
+
+func (s funcSink) Name() string {
+	return s.name
+}
+
+func (s funcSink) Write(info monitor.ClusterInfo) error {
+	return s.write(info)
+}
+
+// Func turns a function into a sink
+func Func(name string, write func(monitor.ClusterInfo) error) Sink {
+	return funcSink{name: name, write: write}
+}
+
+// Stats turns an output that only accepts statistics into a sink, failed scrapes are skipped and
+// partial ones are forwarded
+func Stats(name string, add func(cluster string, collected time.Time, clusterStats stats.ClusterStats)) Sink {
+	return Func(name, func(info monitor.ClusterInfo) error {
+		if info.Err == nil || info.Partial() {
+			add(info.Name, info.Time, info.Stats)
+		}
+		return nil
+	})
+}
+
+// Gated only writes to the sink while open returns true, the results received while closed are skipped
+func Gated(s Sink, open func() bool) Sink {
+	return Func(s.Name(), func(info monitor.ClusterInfo) error {
+		if !open() {
+			return nil
+		}
+		return s.Write(info)
+	})
+}
+
+// buffered queue and worker of a registered sink
+type buffered struct {
+	sink   Sink
+	queue  chan monitor.ClusterInfo
+	status Status
+	done   chan struct{}
+	mu     sync.Mutex
+}
+
+// Registry dispatches the scrape results to the registered sinks. Each sink has its own bounded queue
+// and goroutine: when a sink cannot keep up its oldest queued results are dropped, so a slow sink
+// never stalls the scrapes nor the other sinks
+type Registry struct {
+	sinks []*buffered
+	mu    sync.Mutex
+}
+
+// NewRegistry creates an empty registry, the queue depth of its sinks is exposed by the self metrics
+func NewRegistry() *Registry {
+	r := &Registry{}
+	metrics.NewGaugeFunc("cbmonitor_sink_queue_depth", "Scrape results waiting to be written by a sink",
+		[]string{"sink"}, func() []metrics.GaugeValue {
+			statuses := r.Status()
+			values := make([]metrics.GaugeValue, len(statuses))
+			for i, status := range statuses {
+				values[i] = metrics.GaugeValue{LabelValues: []string{status.Name}, Value: float64(status.Queued)}
+			}
+			return values
+		})
+	return r
+}
+
+// Register starts delivering the results to the sink, bufferSize results at most are queued
+func (r *Registry) Register(sink Sink, bufferSize int) {
+	if bufferSize <= 0 {
+		bufferSize = 1
+	}
+	b := &buffered{
+		sink:   sink,
+		queue:  make(chan monitor.ClusterInfo, bufferSize),
+		status: Status{Name: sink.Name(), BufferSize: bufferSize},
+		done:   make(chan struct{}),
+	}
+	r.mu.Lock()
+	r.sinks = append(r.sinks, b)
+	r.mu.Unlock()
+	go b.run()
+}
+
+// Publish queues the result for every sink without blocking
+func (r *Registry) Publish(info monitor.ClusterInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, b := range r.sinks {
+		b.enqueue(info)
+	}
+}
+
+// Close delivers the queued results and waits for every sink to finish
+func (r *Registry) Close() {
+	r.mu.Lock()
+	sinks := r.sinks
+	r.sinks = nil
+	r.mu.Unlock()
+	for _, b := range sinks {
+		close(b.queue)
+	}
+	for _, b := range sinks {
+		<-b.done
+	}
+}
+
+// Status returns the delivery counters of every sink in registration order
+func (r *Registry) Status() []Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	statuses := make([]Status, 0, len(r.sinks))
+	for _, b := range r.sinks {
+		b.mu.Lock()
+		status := b.status
+		b.mu.Unlock()
+		status.Queued = len(b.queue)
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// enqueue adds the result to the queue, dropping the oldest queued result while it is full
+func (b *buffered) enqueue(info monitor.ClusterInfo) {
+	for {
+		select {
+		case b.queue <- info:
+			return
+		default:
+		}
+		select {
+		case dropped := <-b.queue:
+			b.mu.Lock()
+			b.status.Dropped++
+			b.mu.Unlock()
+			metrics.SinkDropped.Inc(b.status.Name)
+			slog.Warn("Sink cannot keep up, dropped a scrape result", "sink", b.status.Name,
+				logging.ClusterKey, dropped.Name, logging.ScrapeIDKey, dropped.ScrapeID)
+		default:
+		}
+	}
+}
+
+func (b *buffered) run() {
+	defer close(b.done)
+	for info := range b.queue {
+		err := b.sink.Write(info)
+		now := time.Now()
+		b.mu.Lock()
+		b.status.LastWrite = &now
+		if err != nil {
+			b.status.Failures++
+			b.status.LastError = err.Error()
+		} else {
+			b.status.Delivered++
+		}
+		b.mu.Unlock()
+		if err != nil {
+			metrics.SinkWrites.Inc(b.status.Name, metrics.ResultFailure)
+			slog.Error("Sink cannot write a scrape result", "sink", b.status.Name, logging.ClusterKey, info.Name,
+				logging.ScrapeIDKey, info.ScrapeID, "error", err)
+		} else {
+			metrics.SinkWrites.Inc(b.status.Name, metrics.ResultSuccess)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package sink
+
+import (
+	"cbmonitor/internal/monitor"
+	"fmt"
+	"io"
+)
+
+type writerSink struct {
+	writer io.Writer
+}
+
+// NewWriter creates a sink printing the statistics and errors of every scrape to writer
+func NewWriter(writer io.Writer) Sink {
+	return writerSink{writer: writer}
+}
+
+func (s writerSink) Name() string {
+	return "stdout"
+}
+
+func (s writerSink) Write(info monitor.ClusterInfo) error {
+	if info.Err == nil || info.Partial() {
+		if _, err := fmt.Fprintln(s.writer, info.Stats); err != nil {
+			return err
+		}
+	}
+	if info.Err != nil {
+		if _, err := fmt.Fprintln(s.writer, info.Err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
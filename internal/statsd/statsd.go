@@ -0,0 +1,102 @@
+package statsd
+
+import (
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/logging"
+	"cbmonitor/internal/metricpath"
+	"cbmonitor/internal/monitor/stats"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+)
+
+const queueSize = 64
+
+// Emitter sends the statistics as StatsD gauges over UDP
+type Emitter struct {
+	config config.StatsD
+	queue  chan []metricpath.Metric
+}
+
+// NewEmitter creates an emitter for the given (normalized) output configuration
+func NewEmitter(output config.StatsD) *Emitter {
+	return &Emitter{
+		config: output,
+		queue:  make(chan []metricpath.Metric, queueSize),
+	}
+}
+
+// Add queues the statistics of a cluster, they are dropped when the queue is full. StatsD stamps the
+// gauges itself so the collection time is not used
+func (e *Emitter) Add(cluster string, collected time.Time, clusterStats stats.ClusterStats) {
+	select {
+	case e.queue <- metricpath.Metrics(e.config.Paths, cluster, clusterStats):
+	default:
+		slog.Warn("StatsD output queue full, dropping statistics", logging.ClusterKey, cluster)
+	}
+}
+
+// Run sends the queued statistics until stop is closed, the statistics still queued are then sent
+func (e *Emitter) Run(stop <-chan struct{}) {
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	for {
+		select {
+		case <-stop:
+			for {
+				select {
+				case metrics := <-e.queue:
+					conn = e.send(conn, metrics)
+				default:
+					return
+				}
+			}
+		case metrics := <-e.queue:
+			conn = e.send(conn, metrics)
+		}
+	}
+}
+
+// send writes the gauges, dialing first when there is no connection yet, and returns the connection
+func (e *Emitter) send(conn net.Conn, metrics []metricpath.Metric) net.Conn {
+	if conn == nil {
+		var err error
+		if conn, err = net.Dial("udp", e.config.Address); err != nil {
+			slog.Error("Cannot reach StatsD", "address", e.config.Address, "error", err)
+			return nil
+		}
+	}
+	for _, packet := range e.packets(metrics) {
+		if _, err := conn.Write(packet); err != nil {
+			slog.Error("Cannot send gauges to StatsD", "address", e.config.Address, "error", err)
+			break
+		}
+	}
+	return conn
+}
+
+// packets groups the gauges in newline separated packets no larger than the maximum packet size
+func (e *Emitter) packets(metrics []metricpath.Metric) [][]byte {
+	packets := [][]byte{}
+	packet := []byte{}
+	for _, metric := range metrics {
+		gauge := metric.Path + ":" + strconv.FormatFloat(metric.Value, 'f', -1, 64) + "|g"
+		if len(packet) > 0 && len(packet)+1+len(gauge) > e.config.MaxPacketSize {
+			packets = append(packets, packet)
+			packet = []byte{}
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, gauge...)
+	}
+	if len(packet) > 0 {
+		packets = append(packets, packet)
+	}
+	return packets
+}
@@ -0,0 +1,93 @@
+package statsd
+
+import (
+	"cbmonitor/internal/config"
+	"cbmonitor/internal/metricpath"
+	"cbmonitor/internal/monitor/stats"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPackets(t *testing.T) {
+	metrics := []metricpath.Metric{
+		{Path: "couchbase.east.ram_pct_used", Value: 42.5},
+		{Path: "couchbase.east.hd_pct_used", Value: 10},
+		{Path: "couchbase.east.ram_total", Value: 1e12},
+	}
+	tests := []struct {
+		name string
+		size int
+		want []string
+	}{
+		{name: "single packet", size: 1432, want: []string{"couchbase.east.ram_pct_used:42.5|g\n" +
+			"couchbase.east.hd_pct_used:10|g\ncouchbase.east.ram_total:1000000000000|g"}},
+		{name: "exact fit", size: 34 + 1 + 31, want: []string{
+			"couchbase.east.ram_pct_used:42.5|g\ncouchbase.east.hd_pct_used:10|g",
+			"couchbase.east.ram_total:1000000000000|g"}},
+		{name: "one gauge per packet", size: 40, want: []string{"couchbase.east.ram_pct_used:42.5|g",
+			"couchbase.east.hd_pct_used:10|g", "couchbase.east.ram_total:1000000000000|g"}},
+		{name: "gauge larger than a packet", size: 10, want: []string{"couchbase.east.ram_pct_used:42.5|g",
+			"couchbase.east.hd_pct_used:10|g", "couchbase.east.ram_total:1000000000000|g"}},
+	}
+	for _, test := range tests {
+		e := NewEmitter(config.StatsD{MaxPacketSize: test.size})
+		packets := e.packets(metrics)
+		if len(packets) != len(test.want) {
+			t.Errorf("%s: %d packets %q, want %d", test.name, len(packets), packets, len(test.want))
+			continue
+		}
+		for i, packet := range packets {
+			if string(packet) != test.want[i] {
+				t.Errorf("%s: packet %d = %q, want %q", test.name, i, packet, test.want[i])
+			}
+		}
+	}
+	if packets := NewEmitter(config.StatsD{MaxPacketSize: 512}).packets(nil); len(packets) != 0 {
+		t.Errorf("packets() without metrics = %q, want none", packets)
+	}
+}
+
+func TestRun(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	e := NewEmitter(config.StatsD{
+		Address:       listener.LocalAddr().String(),
+		MaxPacketSize: 512,
+		Paths:         config.MetricPaths{Cluster: "cb.{cluster}.{metric}", Node: "cb.{node}", Bucket: "cb.{bucket}"},
+	})
+	e.Add("east", time.Now(), stats.ClusterStats{RAMPctUsed: 42.5})
+	stop := make(chan struct{})
+	close(stop)
+	// the queued statistics are sent before Run returns
+	e.Run(stop)
+
+	listener.SetReadDeadline(time.Now().Add(time.Second))
+	gauges := []string{}
+	buffer := make([]byte, 2048)
+	for {
+		n, _, err := listener.ReadFrom(buffer)
+		if err != nil {
+			break
+		}
+		if n > 512 {
+			t.Errorf("packet of %d bytes, want at most 512", n)
+		}
+		gauges = append(gauges, strings.Split(string(buffer[:n]), "\n")...)
+		listener.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	}
+	found := false
+	for _, gauge := range gauges {
+		if !strings.HasPrefix(gauge, "cb.east.") || !strings.HasSuffix(gauge, "|g") {
+			t.Errorf("invalid gauge %q", gauge)
+		}
+		found = found || gauge == "cb.east.ram_pct_used:42.5|g"
+	}
+	if !found {
+		t.Errorf("gauges %q, want cb.east.ram_pct_used:42.5|g", gauges)
+	}
+}
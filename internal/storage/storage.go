@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Store keeps state as JSON documents in a directory, a nil store keeps nothing
+type Store struct {
+	dir string
+}
+
+// Open creates the directory when needed, it returns a nil store when dir is empty
+func Open(dir string) (*Store, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("cannot create storage directory: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Enabled reports whether the state is persisted
+func (s *Store) Enabled() bool {
+	return s != nil
+}
+
+// Path returns the file of a document
+func (s *Store) Path(name string) string {
+	return filepath.Join(s.dir, name+".json")
+}
+
+// Load decodes a document into target, it reports false when the document was never saved
+func (s *Store) Load(name string, target interface{}) (bool, error) {
+	if s == nil {
+		return false, nil
+	}
+	content, err := ioutil.ReadFile(s.Path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(content, target); err != nil {
+		return false, fmt.Errorf("cannot decode %s: %w", s.Path(name), err)
+	}
+	return true, nil
+}
+
+// Save encodes a document, the previous version is only replaced once the new one is fully written
+func (s *Store) Save(name string, document interface{}) error {
+	if s == nil {
+		return nil
+	}
+	content, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	temporary, err := ioutil.TempFile(s.dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporary.Name())
+	if _, err := temporary.Write(content); err != nil {
+		temporary.Close()
+		return err
+	}
+	if err := temporary.Sync(); err != nil {
+		temporary.Close()
+		return err
+	}
+	if err := temporary.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temporary.Name(), s.Path(name))
+}
@@ -0,0 +1,69 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	enterAlternateScreen = "\x1b[?1049h\x1b[?25l"
+	leaveAlternateScreen = "\x1b[?25h\x1b[?1049l"
+	cursorHome           = "\x1b[H"
+	clearLine            = "\x1b[K"
+	clearBelow           = "\x1b[J"
+
+	colorReset  = "\x1b[0m"
+	colorBold   = "\x1b[1m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorGrey   = "\x1b[90m"
+	colorInvert = "\x1b[7m"
+)
+
+// ErrNotTerminal the standard input is not an interactive terminal
+var ErrNotTerminal = errors.New("the terminal UI requires an interactive terminal")
+
+// terminal puts the controlling terminal in raw mode, restore must be called before exiting
+type terminal struct {
+	savedState string
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	output, err := cmd.Output()
+	return strings.TrimSpace(string(output)), err
+}
+
+func openTerminal() (*terminal, error) {
+	state, err := stty("-g")
+	if err != nil {
+		return nil, ErrNotTerminal
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, fmt.Errorf("cannot set terminal in raw mode: %s", err)
+	}
+	os.Stdout.WriteString(enterAlternateScreen)
+	return &terminal{savedState: state}, nil
+}
+
+func (t *terminal) restore() {
+	os.Stdout.WriteString(colorReset + leaveAlternateScreen)
+	stty(t.savedState)
+}
+
+// size returns the terminal width and height, falling back to 80x24
+func (t *terminal) size() (int, int) {
+	output, err := stty("size")
+	if err == nil {
+		var rows, columns int
+		if _, err := fmt.Sscanf(output, "%d %d", &rows, &columns); err == nil && rows > 0 && columns > 0 {
+			return columns, rows
+		}
+	}
+	return 80, 24
+}
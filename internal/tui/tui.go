@@ -0,0 +1,466 @@
+package tui
+
+import (
+	"cbmonitor/internal/monitor"
+	"cbmonitor/internal/monitor/stats"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Cluster statistics of a cluster together with the status of its latest scrape
+type Cluster struct {
+	Status monitor.ClusterStatus
+	Stats  stats.ClusterStats
+}
+
+type view int
+
+const (
+	viewClusters view = iota
+	viewNodes
+	viewBuckets
+)
+
+type key int
+
+const (
+	keyUnknown key = iota
+	keyUp
+	keyDown
+	keyPageUp
+	keyPageDown
+	keyEnter
+	keyBack
+	keyTab
+	keyQuit
+)
+
+// App live terminal dashboard of the monitored clusters
+type App struct {
+	updates       chan []Cluster
+	clusters      []Cluster
+	updated       time.Time
+	view          view
+	clusterCursor int
+	clusterOffset int
+	rowCursor     int
+	rowOffset     int
+	pageSize      int
+}
+
+// NewApp creates a terminal dashboard, clusters are provided through Update
+func NewApp() *App {
+	return &App{
+		updates:  make(chan []Cluster, 1),
+		pageSize: 10,
+	}
+}
+
+// Update replaces the displayed clusters, it never blocks and can be called from any goroutine
+func (a *App) Update(clusters []Cluster) {
+	for {
+		select {
+		case a.updates <- clusters:
+			return
+		default:
+			select {
+			case <-a.updates:
+			default:
+			}
+		}
+	}
+}
+
+// Run takes over the terminal until the user quits
+func (a *App) Run() error {
+	term, err := openTerminal()
+	if err != nil {
+		return err
+	}
+	defer term.restore()
+	keys := make(chan key)
+	go readKeys(os.Stdin, keys)
+	// periodic renders pick up terminal resizes
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		a.render(term)
+		select {
+		case clusters := <-a.updates:
+			a.clusters = clusters
+			a.updated = time.Now()
+			a.clampCursors()
+		case pressed, ok := <-keys:
+			if !ok || pressed == keyQuit {
+				return nil
+			}
+			a.handle(pressed)
+		case <-ticker.C:
+		}
+	}
+}
+
+func readKeys(input io.Reader, keys chan<- key) {
+	defer close(keys)
+	buffer := make([]byte, 16)
+	for {
+		n, err := input.Read(buffer)
+		if err != nil {
+			return
+		}
+		keys <- parseKey(string(buffer[:n]))
+	}
+}
+
+func parseKey(sequence string) key {
+	switch sequence {
+	case "q", "Q", "\x03":
+		return keyQuit
+	case "k", "\x1b[A", "\x1bOA":
+		return keyUp
+	case "j", "\x1b[B", "\x1bOB":
+		return keyDown
+	case "\x1b[5~":
+		return keyPageUp
+	case "\x1b[6~", " ":
+		return keyPageDown
+	case "\r", "\n", "l", "\x1b[C", "\x1bOC":
+		return keyEnter
+	case "\x1b", "h", "\x7f", "\x1b[D", "\x1bOD":
+		return keyBack
+	case "\t":
+		return keyTab
+	}
+	return keyUnknown
+}
+
+func (a *App) handle(pressed key) {
+	switch pressed {
+	case keyUp:
+		a.move(-1)
+	case keyDown:
+		a.move(1)
+	case keyPageUp:
+		a.move(-a.pageSize)
+	case keyPageDown:
+		a.move(a.pageSize)
+	case keyEnter:
+		if a.view == viewClusters && len(a.clusters) > 0 {
+			a.view = viewNodes
+			a.rowCursor, a.rowOffset = 0, 0
+		}
+	case keyBack:
+		a.view = viewClusters
+	case keyTab:
+		switch a.view {
+		case viewNodes:
+			a.view = viewBuckets
+		case viewBuckets:
+			a.view = viewNodes
+		}
+		a.rowCursor, a.rowOffset = 0, 0
+	}
+	a.clampCursors()
+}
+
+func (a *App) move(delta int) {
+	if a.view == viewClusters {
+		a.clusterCursor += delta
+	} else {
+		a.rowCursor += delta
+	}
+}
+
+func (a *App) rowCount() int {
+	cluster, ok := a.selected()
+	if !ok {
+		return 0
+	}
+	if a.view == viewBuckets {
+		return len(cluster.Stats.Buckets)
+	}
+	return len(cluster.Stats.Nodes)
+}
+
+func (a *App) clampCursors() {
+	a.clusterCursor = clamp(a.clusterCursor, 0, len(a.clusters)-1)
+	a.rowCursor = clamp(a.rowCursor, 0, a.rowCount()-1)
+}
+
+func clamp(value, min, max int) int {
+	if value > max {
+		value = max
+	}
+	if value < min {
+		value = min
+	}
+	return value
+}
+
+func (a *App) selected() (Cluster, bool) {
+	if a.clusterCursor < 0 || a.clusterCursor >= len(a.clusters) {
+		return Cluster{}, false
+	}
+	return a.clusters[a.clusterCursor], true
+}
+
+func (a *App) render(t *terminal) {
+	width, height := t.size()
+	lines := a.frame(width, height)
+	var builder strings.Builder
+	builder.WriteString(cursorHome)
+	for i, line := range lines {
+		if i > 0 {
+			builder.WriteString("\r\n")
+		}
+		builder.WriteString(line)
+		builder.WriteString(colorReset + clearLine)
+	}
+	builder.WriteString(clearBelow)
+	os.Stdout.WriteString(builder.String())
+}
+
+func (a *App) frame(width, height int) []string {
+	updated := "waiting for the first scrape"
+	if !a.updated.IsZero() {
+		updated = "updated " + a.updated.Format("15:04:05")
+	}
+	title := fmt.Sprintf(" cbmonitor  |  %d clusters  |  %s", len(a.clusters), updated)
+	lines := []string{colorInvert + colorBold + fit(title, width)}
+	body := height - 2
+	alertsHeight := body / 3
+	if alertsHeight < 4 {
+		alertsHeight = 4
+	}
+	mainHeight := clamp(body-alertsHeight, 0, body)
+	var main []string
+	if a.view == viewClusters {
+		main = a.clustersPane(width, mainHeight)
+	} else {
+		main = a.clusterPane(width, mainHeight)
+	}
+	for len(main) < mainHeight {
+		main = append(main, "")
+	}
+	lines = append(lines, main[:mainHeight]...)
+	lines = append(lines, a.alertsPane(width, alertsHeight)...)
+	help := " ↑/↓ move  enter open  q quit"
+	if a.view != viewClusters {
+		help = " ↑/↓ move  tab nodes/buckets  esc back  q quit"
+	}
+	return append(lines, colorGrey+fit(help, width))
+}
+
+type column struct {
+	title string
+	width int
+	right bool
+}
+
+// fit pads or truncates text to exactly width runes
+func fit(text string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	runes := []rune(text)
+	if len(runes) > width {
+		if width == 1 {
+			return "…"
+		}
+		return string(runes[:width-1]) + "…"
+	}
+	return text + strings.Repeat(" ", width-len(runes))
+}
+
+func fitColumn(text string, c column) string {
+	if c.right {
+		runes := []rune(text)
+		if len(runes) < c.width {
+			return strings.Repeat(" ", c.width-len(runes)) + text
+		}
+	}
+	return fit(text, c.width)
+}
+
+// formatRow lays out the cells, the last column takes the remaining width. color returns the color
+// of a cell (empty for the default one)
+func formatRow(cells []string, columns []column, width int, color func(int) string) string {
+	var builder strings.Builder
+	used := 0
+	for i, c := range columns {
+		if i == len(columns)-1 || used+c.width > width {
+			c.width = width - used
+		}
+		if c.width <= 0 {
+			break
+		}
+		text := fitColumn(cells[i], c)
+		if cellColor := color(i); cellColor != "" {
+			text = cellColor + text + colorReset
+		}
+		builder.WriteString(text)
+		used += c.width
+	}
+	return builder.String()
+}
+
+// table renders a header and the visible rows keeping the cursor on screen
+func table(columns []column, rows [][]string, cursor int, offset *int, width, height int,
+	color func(row, column int) string) []string {
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = c.title
+	}
+	lines := []string{colorBold + formatRow(header, columns, width, func(int) string { return "" })}
+	visible := height - 1
+	if visible < 1 {
+		return lines
+	}
+	if cursor < *offset {
+		*offset = cursor
+	}
+	if cursor >= *offset+visible {
+		*offset = cursor - visible + 1
+	}
+	for i := *offset; i < len(rows) && i < *offset+visible; i++ {
+		row := i
+		line := formatRow(rows[i], columns, width, func(c int) string { return color(row, c) })
+		if i == cursor {
+			line = colorInvert + strings.Replace(line, colorReset, colorReset+colorInvert, -1)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func stateColor(state monitor.State) string {
+	switch state {
+	case monitor.StateUp:
+		return colorGreen
+	case monitor.StateDegraded:
+		return colorYellow
+	case monitor.StateDown:
+		return colorRed
+	}
+	return colorGrey
+}
+
+func percent(value float64) string {
+	return fmt.Sprintf("%.1f%%", 100*value)
+}
+
+func version(clusterStats stats.ClusterStats) string {
+	if len(clusterStats.Nodes) == 0 {
+		return "-"
+	}
+	return clusterStats.Nodes[0].Version
+}
+
+func (a *App) clustersPane(width, height int) []string {
+	columns := []column{{"  NAME", 22, false}, {"STATE", 10, false}, {"VERSION", 24, false},
+		{"NODES", 7, true}, {"BUCKETS", 9, true}, {"RAM", 8, true}, {"DISK", 8, true}, {"ALERTS", 8, true},
+		{"  LAST ERROR", 0, false}}
+	rows := make([][]string, len(a.clusters))
+	for i, cluster := range a.clusters {
+		alerts := len(cluster.Stats.Alerts.Calculated) + len(cluster.Stats.Alerts.Cluster)
+		state := string(cluster.Status.State)
+		if cluster.Status.Stale {
+			state += "*"
+		}
+		rows[i] = []string{"  " + cluster.Status.Name, state, version(cluster.Stats),
+			fmt.Sprint(len(cluster.Stats.Nodes)), fmt.Sprint(len(cluster.Stats.Buckets)),
+			percent(cluster.Stats.RAMPctUsed), percent(cluster.Stats.HdPctUsed), fmt.Sprint(alerts),
+			"  " + cluster.Status.LastError}
+	}
+	a.pageSize = height - 2
+	return table(columns, rows, a.clusterCursor, &a.clusterOffset, width, height, func(row, c int) string {
+		if c == 1 {
+			return stateColor(a.clusters[row].Status.State)
+		}
+		return ""
+	})
+}
+
+func (a *App) clusterPane(width, height int) []string {
+	cluster, ok := a.selected()
+	if !ok {
+		return []string{}
+	}
+	clusterStats := cluster.Stats
+	status := string(cluster.Status.State)
+	if cluster.Status.Stale {
+		status += " (stale data)"
+	}
+	lines := []string{
+		colorBold + fit(" "+cluster.Status.Name, 24) + colorReset + stateColor(cluster.Status.State) + fit(status, width-24),
+		fit(fmt.Sprintf(" Version %s  |  RAM %s  |  Disk %s  |  Get hit ratio %.2f  |  Rebalance %s",
+			version(clusterStats), percent(clusterStats.RAMPctUsed), percent(clusterStats.HdPctUsed),
+			clusterStats.GetHitRatio, clusterStats.RebalanceStatus), width),
+	}
+	nodesTab, bucketsTab := colorInvert+" Nodes "+colorReset, " Buckets "
+	if a.view == viewBuckets {
+		nodesTab, bucketsTab = " Nodes ", colorInvert+" Buckets "+colorReset
+	}
+	lines = append(lines, " "+nodesTab+" "+bucketsTab)
+	tableHeight := height - len(lines)
+	a.pageSize = tableHeight - 2
+	noColor := func(row, c int) string { return "" }
+	if a.view == viewBuckets {
+		columns := []column{{" NAME", 24, false}, {"TYPE", 12, false}, {"REPLICAS", 10, true},
+			{"ITEMS", 12, true}, {"OPS/S", 10, true}, {"DISK FETCHES", 14, true}, {"QUOTA USED", 12, true},
+			{"", 0, false}}
+		rows := make([][]string, len(clusterStats.Buckets))
+		for i, bucket := range clusterStats.Buckets {
+			rows[i] = []string{" " + bucket.Name, bucket.BucketType, fmt.Sprint(bucket.ReplicaNumber),
+				fmt.Sprint(bucket.ItemCount), fmt.Sprint(bucket.OpsPerSec), fmt.Sprint(bucket.DiskFetches),
+				fmt.Sprintf("%.1f%%", bucket.QuotaPctUsed), ""}
+		}
+		return append(lines, table(columns, rows, a.rowCursor, &a.rowOffset, width, tableHeight, noColor)...)
+	}
+	columns := []column{{" HOSTNAME", 24, false}, {"STATUS", 12, false}, {"MEMBERSHIP", 12, false},
+		{"VERSION", 24, false}, {"CPU", 8, true}, {"MEMORY", 9, true}, {"  SERVICES", 0, false}}
+	rows := make([][]string, len(clusterStats.Nodes))
+	for i, node := range clusterStats.Nodes {
+		rows[i] = []string{" " + node.Hostname, node.Status, node.ClusterMembership, node.Version,
+			fmt.Sprintf("%.1f%%", node.CPURate), percent(node.MemUsedPct), "  " + strings.Join(node.Services, ",")}
+	}
+	return append(lines, table(columns, rows, a.rowCursor, &a.rowOffset, width, tableHeight,
+		func(row, c int) string {
+			if c == 1 && clusterStats.Nodes[row].Status != "healthy" {
+				return colorRed
+			}
+			return noColor(row, c)
+		})...)
+}
+
+func (a *App) alertsPane(width, height int) []string {
+	cluster, ok := a.selected()
+	alerts := []string{}
+	if ok {
+		if cluster.Status.LastError != "" {
+			alerts = append(alerts, colorRed+fit(fmt.Sprintf(" [%s] %s", cluster.Status.ErrorCategory,
+				cluster.Status.LastError), width))
+		}
+		if cluster.Status.Reason != "" {
+			alerts = append(alerts, colorYellow+fit(" "+cluster.Status.Reason, width))
+		}
+		for _, calculated := range cluster.Stats.Alerts.Calculated {
+			alerts = append(alerts, colorYellow+fit(" "+calculated, width))
+		}
+		for _, alert := range cluster.Stats.Alerts.Cluster {
+			alerts = append(alerts, fit(fmt.Sprintf(" %s: %s", alert.ServerTime, alert.Message), width))
+		}
+	}
+	title := fmt.Sprintf("── Alerts (%d) ", len(alerts))
+	if ok {
+		title = fmt.Sprintf("── Alerts of %s (%d) ", cluster.Status.Name, len(alerts))
+	}
+	lines := []string{colorBold + title + strings.Repeat("─", clamp(width-len([]rune(title)), 0, width))}
+	for len(alerts) < height-1 {
+		alerts = append(alerts, "")
+	}
+	return append(lines, alerts[:height-1]...)
+}